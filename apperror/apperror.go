@@ -0,0 +1,94 @@
+package apperror
+
+import (
+	"errors"
+)
+
+// Kind classifies an error independently of the transport, the HTTP layer maps it to a status code
+type Kind int
+
+const (
+	Internal Kind = iota
+	BadRequest
+	Unauthorized
+	Forbidden
+	NotFound
+	Invalid
+	RateLimited
+	Unavailable
+	Conflict
+	TooLarge
+)
+
+type (
+	// Error carries a stable machine readable Code and a Message that is safe to show to clients.
+	// The underlying cause in Err is only meant for logs.
+	Error struct {
+		Kind    Kind
+		Code    string
+		Message string
+		Details map[string]interface{}
+		Err     error
+	}
+)
+
+func New(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func Wrap(kind Kind, code, message string, err error) *Error {
+	return &Error{Kind: kind, Code: code, Message: message, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches errors of the same kind and code, so sentinels still match once details or a cause are attached
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+
+	return ok && t.Kind == e.Kind && t.Code == e.Code
+}
+
+// WithMessage returns a copy with a more specific message
+func (e *Error) WithMessage(message string) *Error {
+	result := *e
+	result.Message = message
+
+	return &result
+}
+
+// WithDetails returns a copy carrying extra structured information for the client
+func (e *Error) WithDetails(details map[string]interface{}) *Error {
+	result := *e
+	result.Details = details
+
+	return &result
+}
+
+// WithErr returns a copy wrapping the underlying cause
+func (e *Error) WithErr(err error) *Error {
+	result := *e
+	result.Err = err
+
+	return &result
+}
+
+// As returns the outermost *Error in the chain of err, or an Internal error wrapping err
+func As(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	return Wrap(Internal, "internal_error", "Internal server error", err)
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/emanpicar/currency-api/apperror"
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/entities/jsondata"
+	"github.com/emanpicar/currency-api/logger"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+	// apiKeyPrefixTag marks our keys so they are easy to spot in logs and secret scanners
+	apiKeyPrefixTag = "cak_"
+	// lastUsedPrecision limits how often a busy key writes its last used time
+	lastUsedPrecision = time.Minute
+)
+
+var (
+	errInvalidAPIKey          = apperror.New(apperror.Unauthorized, "invalid_api_key", "Invalid API key")
+	errAPIKeyManagedByAPIKey  = ErrInsufficientScope.WithMessage("Insufficient scope: API keys cannot be managed with an API key")
+	errAPIKeyNameRequired     = apperror.New(apperror.Invalid, "api_key_name_required", "API key name is required")
+	errAPIKeyExpiresInThePast = apperror.New(apperror.Invalid, "invalid_api_key_expiry", "API key expiry must be in the future")
+)
+
+type (
+	apiKeyManager interface {
+		CreateAPIKey(apiKey *dbdata.APIKey) error
+		GetAPIKeyByPrefix(prefix string) (*dbdata.APIKey, error)
+		ListAPIKeys(username string) ([]dbdata.APIKey, error)
+		RevokeAPIKey(prefix, username string, revokedAt time.Time) error
+		TouchAPIKey(apiKey *dbdata.APIKey, usedAt time.Time) error
+	}
+
+	APIKeyRequest struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+)
+
+// CreateAPIKey issues a key owned by the principal, its scopes default to and may not exceed the principal scopes.
+// The plain key is only returned here, only its hash is stored.
+func (a *authHandler) CreateAPIKey(principal *Principal, body io.ReadCloser) (*jsondata.APIKey, error) {
+	if principal.APIKeyPrefix != "" {
+		return nil, errAPIKeyManagedByAPIKey
+	}
+
+	var request APIKeyRequest
+	if err := decodeBody(body, &request); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(request.Name) == "" {
+		return nil, errAPIKeyNameRequired
+	}
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		return nil, errAPIKeyExpiresInThePast
+	}
+
+	if len(request.Scopes) == 0 {
+		request.Scopes = principal.Scopes
+	}
+	if err := principal.requireScopes(request.Scopes...); err != nil {
+		return nil, err
+	}
+
+	prefixID, err := generateRandomToken(6)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := generateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := apiKeyPrefixTag + prefixID
+	plainKey := prefix + "." + secret
+	apiKey := &dbdata.APIKey{
+		Name:      request.Name,
+		Prefix:    prefix,
+		KeyHash:   hashToken(plainKey),
+		Username:  principal.Username,
+		Scopes:    joinScopes(request.Scopes),
+		ExpiresAt: request.ExpiresAt,
+	}
+
+	if err := a.apiKeyManager.CreateAPIKey(apiKey); err != nil {
+		return nil, err
+	}
+	logger.Log.Infof("Created API key %v for username: %v", prefix, principal.Username)
+
+	result := a.toJSONAPIKey(apiKey)
+	result.Key = plainKey
+
+	return &result, nil
+}
+
+// ListAPIKeys returns the keys of the principal, or of every user for users:admin
+func (a *authHandler) ListAPIKeys(principal *Principal) ([]jsondata.APIKey, error) {
+	if principal.APIKeyPrefix != "" {
+		return nil, errAPIKeyManagedByAPIKey
+	}
+
+	username := principal.Username
+	if principal.HasScopes(ScopeUsersAdmin) {
+		username = ""
+	}
+
+	apiKeys, err := a.apiKeyManager.ListAPIKeys(username)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []jsondata.APIKey{}
+	for _, apiKey := range apiKeys {
+		result = append(result, a.toJSONAPIKey(&apiKey))
+	}
+
+	return result, nil
+}
+
+// RevokeAPIKey revokes a key of the principal, users:admin may revoke any key
+func (a *authHandler) RevokeAPIKey(principal *Principal, prefix string) error {
+	if principal.APIKeyPrefix != "" {
+		return errAPIKeyManagedByAPIKey
+	}
+
+	username := principal.Username
+	if principal.HasScopes(ScopeUsersAdmin) {
+		username = ""
+	}
+
+	if err := a.apiKeyManager.RevokeAPIKey(prefix, username, time.Now()); err != nil {
+		return err
+	}
+	logger.Log.Infof("Revoked API key %v by username: %v", prefix, principal.Username)
+
+	return nil
+}
+
+// validateAPIKey looks the key up by its public prefix and compares the hash of the full key in constant time
+func (a *authHandler) validateAPIKey(plainKey string) (*Principal, error) {
+	separator := strings.Index(plainKey, ".")
+	if !strings.HasPrefix(plainKey, apiKeyPrefixTag) || separator < 0 {
+		return nil, errInvalidAPIKey
+	}
+
+	apiKey, err := a.apiKeyManager.GetAPIKeyByPrefix(plainKey[:separator])
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Log.Warnf("Unknown API key %v", plainKey[:separator])
+		return nil, errInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(hashToken(plainKey))) != 1 {
+		return nil, errInvalidAPIKey
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && !apiKey.ExpiresAt.After(now)) {
+		return nil, errInvalidAPIKey
+	}
+
+	// A key never grants more than its owner currently holds
+	owner, err := a.activePrincipal(apiKey.Username)
+	if err != nil {
+		return nil, err
+	}
+	if owner == nil {
+		return nil, errInvalidAPIKey
+	}
+
+	scopes := []string{}
+	for _, scope := range parseScopes(apiKey.Scopes) {
+		if owner.HasScopes(scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= lastUsedPrecision {
+		if err := a.apiKeyManager.TouchAPIKey(apiKey, now); err != nil {
+			logger.Log.Warnf("Unable to update last used time of API key %v: %v", apiKey.Prefix, err)
+		}
+	}
+
+	return &Principal{Username: apiKey.Username, Scopes: scopes, APIKeyPrefix: apiKey.Prefix}, nil
+}
+
+func (a *authHandler) toJSONAPIKey(apiKey *dbdata.APIKey) jsondata.APIKey {
+	return jsondata.APIKey{
+		Name:       apiKey.Name,
+		Prefix:     apiKey.Prefix,
+		Username:   apiKey.Username,
+		Scopes:     parseScopes(apiKey.Scopes),
+		CreatedAt:  apiKey.CreatedAt,
+		LastUsedAt: apiKey.LastUsedAt,
+		ExpiresAt:  apiKey.ExpiresAt,
+		RevokedAt:  apiKey.RevokedAt,
+	}
+}
@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/jinzhu/gorm"
+)
+
+type (
+	apiKeyManagerMock struct {
+		apiKeys map[string]*dbdata.APIKey
+	}
+)
+
+func newAPIKeyManagerMock() *apiKeyManagerMock {
+	return &apiKeyManagerMock{apiKeys: make(map[string]*dbdata.APIKey)}
+}
+
+func (m *apiKeyManagerMock) CreateAPIKey(apiKey *dbdata.APIKey) error {
+	m.apiKeys[apiKey.Prefix] = apiKey
+	return nil
+}
+
+func (m *apiKeyManagerMock) GetAPIKeyByPrefix(prefix string) (*dbdata.APIKey, error) {
+	if apiKey, ok := m.apiKeys[prefix]; ok {
+		return apiKey, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (m *apiKeyManagerMock) ListAPIKeys(username string) ([]dbdata.APIKey, error) {
+	apiKeys := []dbdata.APIKey{}
+	for _, apiKey := range m.apiKeys {
+		if username == "" || apiKey.Username == username {
+			apiKeys = append(apiKeys, *apiKey)
+		}
+	}
+	return apiKeys, nil
+}
+
+func (m *apiKeyManagerMock) RevokeAPIKey(prefix, username string, revokedAt time.Time) error {
+	apiKey, ok := m.apiKeys[prefix]
+	if !ok || (username != "" && apiKey.Username != username) {
+		return gorm.ErrRecordNotFound
+	}
+	apiKey.RevokedAt = &revokedAt
+	return nil
+}
+
+func (m *apiKeyManagerMock) TouchAPIKey(apiKey *dbdata.APIKey, usedAt time.Time) error {
+	apiKey.LastUsedAt = &usedAt
+	return nil
+}
+
+func Test_authHandler_CreateAPIKey(t *testing.T) {
+	owner := &Principal{Username: "dbuser", Scopes: []string{ScopeRatesRead}}
+	tests := []struct {
+		name      string
+		principal *Principal
+		body      string
+		wantErr   error
+	}{
+		struct {
+			name      string
+			principal *Principal
+			body      string
+			wantErr   error
+		}{
+			name:      "Scopes default to the owner scopes",
+			principal: owner,
+			body:      `{"name": "batch job"}`,
+			wantErr:   nil,
+		},
+		struct {
+			name      string
+			principal *Principal
+			body      string
+			wantErr   error
+		}{
+			name:      "Scopes beyond the owner scopes",
+			principal: owner,
+			body:      `{"name": "batch job", "scopes": ["rates:admin"]}`,
+			wantErr:   ErrInsufficientScope,
+		},
+		struct {
+			name      string
+			principal *Principal
+			body      string
+			wantErr   error
+		}{
+			name:      "Missing name",
+			principal: owner,
+			body:      `{"scopes": ["rates:read"]}`,
+			wantErr:   errAPIKeyNameRequired,
+		},
+		struct {
+			name      string
+			principal *Principal
+			body      string
+			wantErr   error
+		}{
+			name:      "Expiry in the past",
+			principal: owner,
+			body:      `{"name": "batch job", "expires_at": "2020-01-01T00:00:00Z"}`,
+			wantErr:   errAPIKeyExpiresInThePast,
+		},
+		struct {
+			name      string
+			principal *Principal
+			body      string
+			wantErr   error
+		}{
+			name:      "Created with an API key",
+			principal: &Principal{Username: "dbuser", Scopes: []string{ScopeRatesRead}, APIKeyPrefix: "cak_existing"},
+			body:      `{"name": "batch job"}`,
+			wantErr:   ErrInsufficientScope,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &authHandler{userManager: &userManagerMock{}, apiKeyManager: newAPIKeyManagerMock()}
+			got, err := a.CreateAPIKey(tt.principal, ioutil.NopCloser(bytes.NewReader([]byte(tt.body))))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("authHandler.CreateAPIKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && (got.Key == "" || got.Username != "dbuser" || len(got.Scopes) != 1) {
+				t.Errorf("authHandler.CreateAPIKey() = %v, want a key of dbuser with rates:read", got)
+			}
+		})
+	}
+}
+
+func Test_authHandler_ValidateRequestWithAPIKey(t *testing.T) {
+	apiKeyManager := newAPIKeyManagerMock()
+	a := &authHandler{jwtManager: &jwtHandlerMock{}, userManager: &userManagerMock{}, apiKeyManager: apiKeyManager}
+	owner := &Principal{Username: "dbuser", Scopes: []string{ScopeRatesRead}}
+
+	createKey := func(body string) string {
+		created, err := a.CreateAPIKey(owner, ioutil.NopCloser(bytes.NewReader([]byte(body))))
+		if err != nil {
+			t.Fatalf("authHandler.CreateAPIKey() error = %v", err)
+		}
+		return created.Key
+	}
+	validKey := createKey(`{"name": "valid"}`)
+	revokedKey := createKey(`{"name": "revoked"}`)
+	expiringKey := createKey(`{"name": "expiring", "expires_at": "` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`)
+
+	for _, apiKey := range apiKeyManager.apiKeys {
+		switch apiKey.Name {
+		case "revoked":
+			if err := a.RevokeAPIKey(owner, apiKey.Prefix); err != nil {
+				t.Fatalf("authHandler.RevokeAPIKey() error = %v", err)
+			}
+		case "expiring":
+			expired := time.Now().Add(-time.Minute)
+			apiKey.ExpiresAt = &expired
+		}
+	}
+
+	tests := []struct {
+		name           string
+		apiKey         string
+		requiredScopes []string
+		wantErr        error
+	}{
+		struct {
+			name           string
+			apiKey         string
+			requiredScopes []string
+			wantErr        error
+		}{
+			name:           "Valid API key",
+			apiKey:         validKey,
+			requiredScopes: []string{ScopeRatesRead},
+			wantErr:        nil,
+		},
+		struct {
+			name           string
+			apiKey         string
+			requiredScopes []string
+			wantErr        error
+		}{
+			name:           "API key lacks scope",
+			apiKey:         validKey,
+			requiredScopes: []string{ScopeRatesAdmin},
+			wantErr:        ErrInsufficientScope,
+		},
+		struct {
+			name           string
+			apiKey         string
+			requiredScopes []string
+			wantErr        error
+		}{
+			name:    "Wrong secret",
+			apiKey:  validKey + "tampered",
+			wantErr: errInvalidAPIKey,
+		},
+		struct {
+			name           string
+			apiKey         string
+			requiredScopes []string
+			wantErr        error
+		}{
+			name:    "Revoked API key",
+			apiKey:  revokedKey,
+			wantErr: errInvalidAPIKey,
+		},
+		struct {
+			name           string
+			apiKey         string
+			requiredScopes []string
+			wantErr        error
+		}{
+			name:    "Expired API key",
+			apiKey:  expiringKey,
+			wantErr: errInvalidAPIKey,
+		},
+		struct {
+			name           string
+			apiKey         string
+			requiredScopes []string
+			wantErr        error
+		}{
+			name:    "Malformed API key",
+			apiKey:  "not-an-api-key",
+			wantErr: errInvalidAPIKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{Header: http.Header{"X-Api-Key": []string{tt.apiKey}}}
+			got, err := a.ValidateRequest(r, tt.requiredScopes...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("authHandler.ValidateRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && (got.Username != "dbuser" || got.APIKeyPrefix == "") {
+				t.Errorf("authHandler.ValidateRequest() = %v, want API key principal of dbuser", got)
+			}
+		})
+	}
+}
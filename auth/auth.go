@@ -9,108 +9,274 @@ import (
 	"strings"
 	"time"
 
+	"github.com/emanpicar/currency-api/apperror"
+	"github.com/emanpicar/currency-api/db"
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/entities/jsondata"
 	"github.com/emanpicar/currency-api/logger"
 	"github.com/emanpicar/currency-api/settings"
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
 
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
+var (
+	errInvalidCredentials     = apperror.New(apperror.Unauthorized, "invalid_credentials", "Invalid user username/password")
+	errMissingAuthorization   = apperror.New(apperror.Unauthorized, "missing_authorization", "An authorization header is required")
+	errMalformedAuthorization = apperror.New(apperror.Unauthorized, "invalid_authorization_header", "Cannot parse authorization header")
+	errInvalidToken           = apperror.New(apperror.Unauthorized, "invalid_token", "Invalid authorization token")
+	errExpiredToken           = apperror.New(apperror.Unauthorized, "token_expired", "Authorization token has expired")
+	errRevokedToken           = apperror.New(apperror.Unauthorized, "token_revoked", "Authorization token has been revoked")
+	errMalformedBody          = apperror.New(apperror.BadRequest, "malformed_body", "Request body is not valid JSON")
+	// bcrypt hash of a random password, compared against when the username does not exist
+	dummyPasswordHash = "$2a$10$hn3sb7i2jzlSFQitw.Kk2uE8wzQXnie4C7lZvpxgpB.ugL0AUEhny"
+	// Development users, only accepted when settings.GetInMemoryUsersEnabled() is true
+	inMemoryUsers = []inMemoryUser{
+		inMemoryUser{User: User{Username: "user123", Password: "pass123"}, Scopes: AllScopes},
+		inMemoryUser{User: User{Username: "useruser", Password: "passpass"}, Scopes: []string{ScopeRatesRead}},
+	}
+)
+
 type (
 	Manager interface {
-		Authenticate(body io.ReadCloser) (string, error)
-		ValidateRequest(r *http.Request) error
+		Authenticate(body io.ReadCloser) (*jsondata.Token, error)
+		Refresh(body io.ReadCloser) (*jsondata.Token, error)
+		Logout(r *http.Request) error
+		ValidateRequest(r *http.Request, requiredScopes ...string) (*Principal, error)
+		GetJWKS() *jsondata.JWKS
+		CreateAPIKey(principal *Principal, body io.ReadCloser) (*jsondata.APIKey, error)
+		ListAPIKeys(principal *Principal) ([]jsondata.APIKey, error)
+		RevokeAPIKey(principal *Principal, prefix string) error
 	}
 
 	jwtManager interface {
 		generateJwtToken(mapClaims jwt.MapClaims) (string, error)
-		parseJwtToken(tokenString string) error
+		parseJwtToken(tokenString string) (jwt.MapClaims, error)
+		getJWKS() *jsondata.JWKS
+	}
+
+	userManager interface {
+		GetUserByUsername(username string) (*dbdata.User, error)
+		CreateUser(user *dbdata.User) error
+	}
+
+	tokenManager interface {
+		CreateRefreshToken(refreshToken *dbdata.RefreshToken) error
+		ConsumeRefreshToken(tokenHash string, consumedAt time.Time) (*dbdata.RefreshToken, error)
+		RevokeRefreshToken(tokenHash, username string, revokedAt time.Time) error
+		RevokeUserRefreshTokens(username string, revokedAt time.Time) error
+		CreateRevokedToken(revokedToken *dbdata.RevokedToken) error
+		IsTokenRevoked(jti string) (bool, error)
 	}
 
 	authHandler struct {
-		jwtManager jwtManager
+		jwtManager    jwtManager
+		userManager   userManager
+		tokenManager  tokenManager
+		apiKeyManager apiKeyManager
+	}
+
+	jwtHandler struct {
+		tokenManager tokenManager
+		keys         *keySet
 	}
-	jwtHandler struct{}
 
 	User struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
 	}
+
+	inMemoryUser struct {
+		User
+		Scopes []string
+	}
 )
 
-func NewManager() Manager {
-	return &authHandler{newJwtManager()}
+func NewManager(dbManager db.Manager) Manager {
+	authHandler := &authHandler{
+		jwtManager:    newJwtManager(dbManager),
+		userManager:   dbManager,
+		tokenManager:  dbManager,
+		apiKeyManager: dbManager,
+	}
+	authHandler.bootstrapUser(settings.GetBootstrapUsername(), settings.GetBootstrapPassword())
+
+	return authHandler
 }
 
-func newJwtManager() jwtManager {
-	return &jwtHandler{}
+func newJwtManager(tokenManager tokenManager) jwtManager {
+	keys, err := newKeySet(settings.GetJWTSigningAlgorithm(), settings.GetJWTSigningKey(), settings.GetJWTVerificationKeys())
+	if err != nil {
+		logger.Log.Fatalln(err)
+	}
+	logger.Log.Infof("Signing JWT tokens with %v", keys.signingMethod.Alg())
+
+	return &jwtHandler{tokenManager: tokenManager, keys: keys}
 }
 
-func (a *authHandler) Authenticate(body io.ReadCloser) (string, error) {
+func (a *authHandler) Authenticate(body io.ReadCloser) (*jsondata.Token, error) {
 	var user User
-	if err := json.NewDecoder(body).Decode(&user); err != nil {
-		return "", err
+	if err := decodeBody(body, &user); err != nil {
+		return nil, err
+	}
+
+	principal, err := a.dbAuthentication(user)
+	if err != nil {
+		return nil, err
+	}
+	if principal == nil && settings.GetInMemoryUsersEnabled() {
+		principal = a.inMemoryAuthentication(user)
 	}
 
-	// TODO validate user and pass in DB
-	if !a.inMemoryAuthentication(user) {
-		return "", errors.New("Invalid user username/password")
+	if principal == nil {
+		return nil, errInvalidCredentials
 	}
 
-	mapClaims := jwt.MapClaims{
-		"username":   user.Username,
-		"authorized": true,
-		"exp":        time.Now().Add(time.Hour * 24).Unix(),
+	return a.issueTokens(principal)
+}
+
+// ValidateRequest authenticates the X-API-Key header or else the bearer token, and checks every required scope
+func (a *authHandler) ValidateRequest(r *http.Request, requiredScopes ...string) (*Principal, error) {
+	if plainKey := r.Header.Get(apiKeyHeader); plainKey != "" {
+		principal, err := a.validateAPIKey(plainKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := principal.requireScopes(requiredScopes...); err != nil {
+			return nil, err
+		}
+
+		return principal, nil
 	}
 
-	tokenString, err := a.jwtManager.generateJwtToken(mapClaims)
+	tokenString, err := a.bearerToken(r)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	logger.Log.Infoln("Successfully generated JWT token")
 
-	return tokenString, nil
+	mapClaims, err := a.jwtManager.parseJwtToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	username, _ := mapClaims["username"].(string)
+	scopes, _ := mapClaims["scope"].(string)
+	principal := &Principal{Username: username, Scopes: parseScopes(scopes)}
+
+	if err := principal.requireScopes(requiredScopes...); err != nil {
+		return nil, err
+	}
+
+	return principal, nil
+}
+
+// GetJWKS publishes the public verification keys, empty when tokens are signed with a shared secret
+func (a *authHandler) GetJWKS() *jsondata.JWKS {
+	return a.jwtManager.getJWKS()
 }
 
-func (a *authHandler) ValidateRequest(r *http.Request) error {
+func (a *authHandler) bearerToken(r *http.Request) (string, error) {
 	authorizationHeader := r.Header.Get("Authorization")
 	if authorizationHeader == "" {
-		return errors.New("An authorization header is required")
+		return "", errMissingAuthorization
 	}
 
 	bearerToken := strings.Split(authorizationHeader, " ")
 	if len(bearerToken) != 2 {
-		return errors.New("Cannot parse authorization header")
+		return "", errMalformedAuthorization
 	}
 
-	err := a.jwtManager.parseJwtToken(bearerToken[1])
-	if err != nil {
-		return err
+	return bearerToken[1], nil
+}
+
+// dbAuthentication always runs a bcrypt comparison so unknown usernames take as long as wrong passwords.
+// Only database failures are returned as errors, rejected credentials return a nil principal.
+func (a *authHandler) dbAuthentication(userCreds User) (*Principal, error) {
+	logger.Log.Infof("Authenticating user against DB with username: %v", userCreds.Username)
+
+	passwordHash := dummyPasswordHash
+	dbUser, err := a.userManager.GetUserByUsername(userCreds.Username)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		passwordHash = dbUser.PasswordHash
 	}
 
-	return nil
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(userCreds.Password)) != nil || err != nil {
+		return nil, nil
+	}
+
+	if !dbUser.Enabled {
+		logger.Log.Warnf("Rejected disabled user with username: %v", userCreds.Username)
+		return nil, nil
+	}
+
+	return &Principal{Username: dbUser.Username, Scopes: parseScopes(dbUser.Scopes)}, nil
 }
 
-func (a *authHandler) inMemoryAuthentication(userCreds User) bool {
-	logger.Log.Infof("Authenticating user with username: %v", userCreds.Username)
+// bootstrapUser creates the initial user on a fresh database, existing users are left untouched
+func (a *authHandler) bootstrapUser(username, password string) {
+	if username == "" || password == "" {
+		return
+	}
 
-	users := []User{
-		User{Username: "user123", Password: "pass123"},
-		User{Username: "useruser", Password: "passpass"},
+	if _, err := a.userManager.GetUserByUsername(username); err == nil {
+		return
 	}
 
-	for _, val := range users {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		logger.Log.Fatalln("Unable to hash bootstrap user password:", err)
+	}
+
+	err = a.userManager.CreateUser(&dbdata.User{
+		Username:     username,
+		PasswordHash: string(passwordHash),
+		Scopes:       joinScopes(AllScopes),
+		Enabled:      true,
+	})
+	if err != nil {
+		logger.Log.Fatalln("Unable to create bootstrap user:", err)
+	}
+
+	logger.Log.Infof("Created bootstrap user with username: %v", username)
+}
+
+func (a *authHandler) inMemoryAuthentication(userCreds User) *Principal {
+	logger.Log.Infof("Authenticating development user with username: %v", userCreds.Username)
+
+	for _, val := range inMemoryUsers {
 		if val.Username == userCreds.Username && val.Password == userCreds.Password {
-			return true
+			return &Principal{Username: val.Username, Scopes: val.Scopes}
 		}
 	}
 
-	return false
+	return nil
+}
+
+func (a *authHandler) inMemoryPrincipal(username string) *Principal {
+	for _, val := range inMemoryUsers {
+		if val.Username == username {
+			return &Principal{Username: val.Username, Scopes: val.Scopes}
+		}
+	}
+
+	return nil
 }
 
 func (j *jwtHandler) generateJwtToken(mapClaims jwt.MapClaims) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, mapClaims)
+	token := jwt.NewWithClaims(j.keys.signingMethod, mapClaims)
 
-	tokenString, err := token.SignedString([]byte(settings.GetTokenSecret()))
+	var signingKey interface{} = []byte(settings.GetTokenSecret())
+	if !j.keys.isSymmetric() {
+		token.Header["kid"] = j.keys.signingKeyID
+		signingKey = j.keys.signingKey
+	}
+
+	tokenString, err := token.SignedString(signingKey)
 	if err != nil {
 		return "", err
 	}
@@ -118,20 +284,57 @@ func (j *jwtHandler) generateJwtToken(mapClaims jwt.MapClaims) (string, error) {
 	return tokenString, nil
 }
 
-func (j *jwtHandler) parseJwtToken(tokenString string) error {
+// parseJwtToken rejects tokens without an id or whose id is on the revocation denylist
+func (j *jwtHandler) parseJwtToken(tokenString string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if !j.keys.isSymmetric() {
+			return j.keys.verificationKey(token)
+		}
+
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return []byte(settings.GetTokenSecret()), nil
 	})
 
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+		return nil, errExpiredToken.WithErr(err)
+	}
+	if err != nil {
+		return nil, errInvalidToken.WithErr(err)
+	}
+
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !token.Valid || !ok {
+		return nil, errInvalidToken
+	}
+
+	jti, _ := mapClaims["jti"].(string)
+	if jti == "" {
+		return nil, errInvalidToken
+	}
+
+	revoked, err := j.tokenManager.IsTokenRevoked(jti)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if revoked {
+		return nil, errRevokedToken
 	}
 
-	if !token.Valid {
-		return errors.New("Invalid authorization token")
+	return mapClaims, nil
+}
+
+func (j *jwtHandler) getJWKS() *jsondata.JWKS {
+	return j.keys.jwks()
+}
+
+// decodeBody reports malformed JSON as a client error
+func decodeBody(body io.Reader, v interface{}) error {
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		return errMalformedBody.WithErr(err)
 	}
 
 	return nil
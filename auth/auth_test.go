@@ -7,8 +7,15 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/entities/jsondata"
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
 
 	jwt "github.com/dgrijalva/jwt-go"
 )
@@ -19,70 +26,172 @@ var (
 )
 
 type (
-	jwtHandlerMock struct{}
+	jwtHandlerMock  struct{}
+	userManagerMock struct{}
 )
 
+func mockPasswordHash(password string) string {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		panic(err)
+	}
+
+	return string(passwordHash)
+}
+
+func (u *userManagerMock) GetUserByUsername(username string) (*dbdata.User, error) {
+	switch username {
+	case "dbuser":
+		return &dbdata.User{Username: username, PasswordHash: mockPasswordHash("dbpass"), Scopes: ScopeRatesRead, Enabled: true}, nil
+	case "disableduser":
+		return &dbdata.User{Username: username, PasswordHash: mockPasswordHash("dbpass"), Enabled: false}, nil
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (u *userManagerMock) CreateUser(user *dbdata.User) error {
+	return nil
+}
+
 func (j *jwtHandlerMock) generateJwtToken(mapClaims jwt.MapClaims) (string, error) {
 	return mockTokenResult, nil
 }
 
-func (j *jwtHandlerMock) parseJwtToken(tokenString string) error {
+func (j *jwtHandlerMock) getJWKS() *jsondata.JWKS {
+	return &jsondata.JWKS{Keys: []jsondata.JWK{}}
+}
+
+func (j *jwtHandlerMock) parseJwtToken(tokenString string) (jwt.MapClaims, error) {
 	if throwUnexpectedSigningMethod == tokenString {
-		return errors.New("Unexpected signing method")
+		return nil, errors.New("Unexpected signing method")
 	}
 
-	return nil
+	return jwt.MapClaims{
+		"jti":      tokenString,
+		"username": "dbuser",
+		"scope":    ScopeRatesRead,
+		"exp":      float64(time.Now().Add(time.Hour).Unix()),
+	}, nil
 }
 
 func Test_authHandler_Authenticate(t *testing.T) {
 	mockJSONUser := `{"username": "user123", "password": "pass123"}`
 	mockJSONWrongUser := `{"username": "user123", "password": "wrongpass123"}`
+	mockJSONDBUser := `{"username": "dbuser", "password": "dbpass"}`
+	mockJSONWrongDBUser := `{"username": "dbuser", "password": "wrongpass"}`
+	mockJSONDisabledDBUser := `{"username": "disableduser", "password": "dbpass"}`
 	type args struct {
 		body io.ReadCloser
 	}
 	tests := []struct {
-		name    string
-		a       *authHandler
-		args    args
-		want    string
-		wantErr bool
+		name          string
+		a             *authHandler
+		args          args
+		inMemoryUsers string
+		want          string
+		wantErr       bool
 	}{
 		struct {
-			name    string
-			a       *authHandler
-			args    args
-			want    string
-			wantErr bool
+			name          string
+			a             *authHandler
+			args          args
+			inMemoryUsers string
+			want          string
+			wantErr       bool
 		}{
-			name:    "Valid username/password",
-			a:       &authHandler{&jwtHandlerMock{}},
-			args:    args{body: ioutil.NopCloser(bytes.NewReader([]byte(mockJSONUser)))},
+			name:    "Valid DB username/password",
+			a:       &authHandler{jwtManager: &jwtHandlerMock{}, userManager: &userManagerMock{}, tokenManager: newTokenManagerMock()},
+			args:    args{body: ioutil.NopCloser(bytes.NewReader([]byte(mockJSONDBUser)))},
 			want:    mockTokenResult,
 			wantErr: false,
 		},
 		struct {
-			name    string
-			a       *authHandler
-			args    args
-			want    string
-			wantErr bool
+			name          string
+			a             *authHandler
+			args          args
+			inMemoryUsers string
+			want          string
+			wantErr       bool
+		}{
+			name:    "Invalid DB username/password",
+			a:       &authHandler{jwtManager: &jwtHandlerMock{}, userManager: &userManagerMock{}, tokenManager: newTokenManagerMock()},
+			args:    args{body: ioutil.NopCloser(bytes.NewReader([]byte(mockJSONWrongDBUser)))},
+			want:    "",
+			wantErr: true,
+		},
+		struct {
+			name          string
+			a             *authHandler
+			args          args
+			inMemoryUsers string
+			want          string
+			wantErr       bool
 		}{
-			name:    "Invalid username/password",
-			a:       &authHandler{&jwtHandlerMock{}},
-			args:    args{body: ioutil.NopCloser(bytes.NewReader([]byte(mockJSONWrongUser)))},
+			name:    "Disabled DB user",
+			a:       &authHandler{jwtManager: &jwtHandlerMock{}, userManager: &userManagerMock{}, tokenManager: newTokenManagerMock()},
+			args:    args{body: ioutil.NopCloser(bytes.NewReader([]byte(mockJSONDisabledDBUser)))},
 			want:    "",
 			wantErr: true,
 		},
+		struct {
+			name          string
+			a             *authHandler
+			args          args
+			inMemoryUsers string
+			want          string
+			wantErr       bool
+		}{
+			name:          "Valid in-memory username/password",
+			a:             &authHandler{jwtManager: &jwtHandlerMock{}, userManager: &userManagerMock{}, tokenManager: newTokenManagerMock()},
+			args:          args{body: ioutil.NopCloser(bytes.NewReader([]byte(mockJSONUser)))},
+			inMemoryUsers: "true",
+			want:          mockTokenResult,
+			wantErr:       false,
+		},
+		struct {
+			name          string
+			a             *authHandler
+			args          args
+			inMemoryUsers string
+			want          string
+			wantErr       bool
+		}{
+			name:          "In-memory users disabled",
+			a:             &authHandler{jwtManager: &jwtHandlerMock{}, userManager: &userManagerMock{}, tokenManager: newTokenManagerMock()},
+			args:          args{body: ioutil.NopCloser(bytes.NewReader([]byte(mockJSONUser)))},
+			inMemoryUsers: "false",
+			want:          "",
+			wantErr:       true,
+		},
+		struct {
+			name          string
+			a             *authHandler
+			args          args
+			inMemoryUsers string
+			want          string
+			wantErr       bool
+		}{
+			name:          "Invalid in-memory username/password",
+			a:             &authHandler{jwtManager: &jwtHandlerMock{}, userManager: &userManagerMock{}, tokenManager: newTokenManagerMock()},
+			args:          args{body: ioutil.NopCloser(bytes.NewReader([]byte(mockJSONWrongUser)))},
+			inMemoryUsers: "true",
+			want:          "",
+			wantErr:       true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("AUTH_IN_MEMORY_USERS", tt.inMemoryUsers)
+			defer os.Unsetenv("AUTH_IN_MEMORY_USERS")
+
 			got, err := tt.a.Authenticate(tt.args.body)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("authHandler.Authenticate() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if got != tt.want {
-				t.Errorf("authHandler.Authenticate() = %v, want %v", got, tt.want)
+			if got != nil && (got.AccessToken != tt.want || got.RefreshToken == "") {
+				t.Errorf("authHandler.Authenticate() = %v, want access token %v", got, tt.want)
 			}
 		})
 	}
@@ -90,7 +199,8 @@ func Test_authHandler_Authenticate(t *testing.T) {
 
 func Test_authHandler_ValidateRequest(t *testing.T) {
 	type args struct {
-		r *http.Request
+		r              *http.Request
+		requiredScopes []string
 	}
 	tests := []struct {
 		name        string
@@ -107,7 +217,7 @@ func Test_authHandler_ValidateRequest(t *testing.T) {
 			errorPrefix string
 		}{
 			name:    "Valid token",
-			a:       &authHandler{&jwtHandlerMock{}},
+			a:       &authHandler{jwtManager: &jwtHandlerMock{}, userManager: &userManagerMock{}, tokenManager: newTokenManagerMock()},
 			args:    args{r: &http.Request{Header: http.Header{"Authorization": []string{"Bearer ValidToken"}}}},
 			wantErr: false,
 		},
@@ -119,7 +229,7 @@ func Test_authHandler_ValidateRequest(t *testing.T) {
 			errorPrefix string
 		}{
 			name:        "Missing authorization header",
-			a:           &authHandler{&jwtHandlerMock{}},
+			a:           &authHandler{jwtManager: &jwtHandlerMock{}, userManager: &userManagerMock{}, tokenManager: newTokenManagerMock()},
 			args:        args{r: &http.Request{}},
 			wantErr:     true,
 			errorPrefix: "An authorization header is required",
@@ -132,7 +242,7 @@ func Test_authHandler_ValidateRequest(t *testing.T) {
 			errorPrefix string
 		}{
 			name:        "Cannot parse authorization header",
-			a:           &authHandler{&jwtHandlerMock{}},
+			a:           &authHandler{jwtManager: &jwtHandlerMock{}, userManager: &userManagerMock{}, tokenManager: newTokenManagerMock()},
 			args:        args{r: &http.Request{Header: http.Header{"Authorization": []string{"BearerNospacetoken"}}}},
 			wantErr:     true,
 			errorPrefix: "Cannot parse authorization header",
@@ -145,15 +255,40 @@ func Test_authHandler_ValidateRequest(t *testing.T) {
 			errorPrefix string
 		}{
 			name:        "Unexpected signing method",
-			a:           &authHandler{&jwtHandlerMock{}},
+			a:           &authHandler{jwtManager: &jwtHandlerMock{}, userManager: &userManagerMock{}, tokenManager: newTokenManagerMock()},
 			args:        args{r: &http.Request{Header: http.Header{"Authorization": []string{fmt.Sprintf("Bearer %v", throwUnexpectedSigningMethod)}}}},
 			wantErr:     true,
 			errorPrefix: "Unexpected signing method",
 		},
+		struct {
+			name        string
+			a           *authHandler
+			args        args
+			wantErr     bool
+			errorPrefix string
+		}{
+			name:    "Granted scope",
+			a:       &authHandler{jwtManager: &jwtHandlerMock{}, userManager: &userManagerMock{}, tokenManager: newTokenManagerMock()},
+			args:    args{r: &http.Request{Header: http.Header{"Authorization": []string{"Bearer ValidToken"}}}, requiredScopes: []string{ScopeRatesRead}},
+			wantErr: false,
+		},
+		struct {
+			name        string
+			a           *authHandler
+			args        args
+			wantErr     bool
+			errorPrefix string
+		}{
+			name:        "Insufficient scope",
+			a:           &authHandler{jwtManager: &jwtHandlerMock{}, userManager: &userManagerMock{}, tokenManager: newTokenManagerMock()},
+			args:        args{r: &http.Request{Header: http.Header{"Authorization": []string{"Bearer ValidToken"}}}, requiredScopes: []string{ScopeRatesRead, ScopeRatesAdmin}},
+			wantErr:     true,
+			errorPrefix: ErrInsufficientScope.Error(),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := tt.a.ValidateRequest(tt.args.r)
+			_, err := tt.a.ValidateRequest(tt.args.r, tt.args.requiredScopes...)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("authHandler.ValidateRequest() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/emanpicar/currency-api/entities/jsondata"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+type (
+	// keySet holds the key used to sign new tokens and every public key accepted during rotation, indexed by kid
+	keySet struct {
+		signingMethod    jwt.SigningMethod
+		signingKey       interface{}
+		signingKeyID     string
+		verificationKeys map[string]crypto.PublicKey
+	}
+)
+
+// newKeySet loads a PEM private key for RS256/ES256 and any extra PEM public keys still accepted for verification.
+// HS256 keeps using the shared token secret and needs no key files.
+func newKeySet(algorithm, signingKeyPath string, verificationKeyPaths []string) (*keySet, error) {
+	keys := &keySet{verificationKeys: make(map[string]crypto.PublicKey)}
+
+	switch strings.ToUpper(algorithm) {
+	case "HS256":
+		keys.signingMethod = jwt.SigningMethodHS256
+		return keys, nil
+	case "RS256":
+		keys.signingMethod = jwt.SigningMethodRS256
+	case "ES256":
+		keys.signingMethod = jwt.SigningMethodES256
+	default:
+		return nil, fmt.Errorf("Unsupported JWT signing algorithm: %v", algorithm)
+	}
+
+	signingKey, publicKey, err := keys.loadPrivateKey(signingKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := keys.addVerificationKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	keys.signingKey, keys.signingKeyID = signingKey, kid
+
+	for _, path := range verificationKeyPaths {
+		publicKey, err := keys.loadPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := keys.addVerificationKey(publicKey); err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+	}
+
+	return keys, nil
+}
+
+func (k *keySet) isSymmetric() bool {
+	return k.signingMethod == jwt.SigningMethodHS256
+}
+
+// verificationKey returns the public key for the token kid, provided its algorithm matches the key type
+func (k *keySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	publicKey, ok := k.verificationKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %q", kid)
+	}
+
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+	case *ecdsa.PublicKey:
+		if token.Method != jwt.SigningMethodES256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+	}
+
+	return publicKey, nil
+}
+
+func (k *keySet) jwks() *jsondata.JWKS {
+	result := &jsondata.JWKS{Keys: []jsondata.JWK{}}
+
+	for kid, publicKey := range k.verificationKeys {
+		jwk := jsondata.JWK{Kid: kid, Use: "sig"}
+
+		switch key := publicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty, jwk.Alg = "RSA", jwt.SigningMethodRS256.Alg()
+			jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+		case *ecdsa.PublicKey:
+			jwk.Kty, jwk.Alg, jwk.Crv = "EC", jwt.SigningMethodES256.Alg(), key.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(padCoordinate(key.X, key.Params()))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(padCoordinate(key.Y, key.Params()))
+		}
+
+		result.Keys = append(result.Keys, jwk)
+	}
+
+	return result
+}
+
+func (k *keySet) addVerificationKey(publicKey crypto.PublicKey) (string, error) {
+	kid, err := thumbprint(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	k.verificationKeys[kid] = publicKey
+
+	return kid, nil
+}
+
+func (k *keySet) loadPrivateKey(path string) (interface{}, crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to read JWT signing key: %v", err)
+	}
+
+	if k.signingMethod == jwt.SigningMethodRS256 {
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to parse RSA signing key: %v", err)
+		}
+		return privateKey, &privateKey.PublicKey, nil
+	}
+
+	privateKey, err := jwt.ParseECPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to parse EC signing key: %v", err)
+	}
+	if privateKey.Curve != elliptic.P256() {
+		return nil, nil, errors.New("ES256 requires a P-256 signing key")
+	}
+
+	return privateKey, &privateKey.PublicKey, nil
+}
+
+func (k *keySet) loadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read JWT verification key: %v", err)
+	}
+
+	if publicKey, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return publicKey, nil
+	}
+
+	publicKey, err := jwt.ParseECPublicKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse JWT verification key %v: not an RSA or EC public key", path)
+	}
+	if publicKey.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("Unable to use JWT verification key %v: ES256 requires a P-256 key", path)
+	}
+
+	return publicKey, nil
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint, used as kid so every service derives the same id
+func thumbprint(publicKey crypto.PublicKey) (string, error) {
+	var members interface{}
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{
+			Crv: key.Params().Name,
+			Kty: "EC",
+			X:   base64.RawURLEncoding.EncodeToString(padCoordinate(key.X, key.Params())),
+			Y:   base64.RawURLEncoding.EncodeToString(padCoordinate(key.Y, key.Params())),
+		}
+	default:
+		return "", fmt.Errorf("Unsupported public key type %T", publicKey)
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func padCoordinate(coordinate *big.Int, params *elliptic.CurveParams) []byte {
+	size := (params.BitSize + 7) / 8
+	data := coordinate.Bytes()
+
+	return append(make([]byte, size-len(data)), data...)
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func writePEM(t *testing.T, dir, name, blockType string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600); err != nil {
+		t.Fatalf("ioutil.WriteFile() error = %v", err)
+	}
+
+	return path
+}
+
+func writeTestKeys(t *testing.T, dir string) (rsaPrivatePath, rsaPublicPath, ecPrivatePath, ecPublicPath string) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+
+	rsaPublic, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	ecPrivate, _ := x509.MarshalECPrivateKey(ecKey)
+	ecPublic, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+
+	return writePEM(t, dir, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)),
+		writePEM(t, dir, "rsa.pub.pem", "PUBLIC KEY", rsaPublic),
+		writePEM(t, dir, "ec.pem", "EC PRIVATE KEY", ecPrivate),
+		writePEM(t, dir, "ec.pub.pem", "PUBLIC KEY", ecPublic)
+}
+
+func Test_newKeySet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "currency-api-keys")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	rsaPrivatePath, rsaPublicPath, ecPrivatePath, ecPublicPath := writeTestKeys(t, dir)
+
+	type args struct {
+		algorithm            string
+		signingKeyPath       string
+		verificationKeyPaths []string
+	}
+	tests := []struct {
+		name     string
+		args     args
+		wantKeys int
+		wantErr  bool
+	}{
+		struct {
+			name     string
+			args     args
+			wantKeys int
+			wantErr  bool
+		}{
+			name:     "HS256 needs no keys",
+			args:     args{algorithm: "HS256"},
+			wantKeys: 0,
+			wantErr:  false,
+		},
+		struct {
+			name     string
+			args     args
+			wantKeys int
+			wantErr  bool
+		}{
+			name:     "RS256 rotating from ES256",
+			args:     args{algorithm: "RS256", signingKeyPath: rsaPrivatePath, verificationKeyPaths: []string{ecPublicPath}},
+			wantKeys: 2,
+			wantErr:  false,
+		},
+		struct {
+			name     string
+			args     args
+			wantKeys int
+			wantErr  bool
+		}{
+			name:     "ES256 with its own public key listed",
+			args:     args{algorithm: "es256", signingKeyPath: ecPrivatePath, verificationKeyPaths: []string{ecPublicPath, rsaPublicPath}},
+			wantKeys: 2,
+			wantErr:  false,
+		},
+		struct {
+			name     string
+			args     args
+			wantKeys int
+			wantErr  bool
+		}{
+			name:    "Key does not match algorithm",
+			args:    args{algorithm: "ES256", signingKeyPath: rsaPrivatePath},
+			wantErr: true,
+		},
+		struct {
+			name     string
+			args     args
+			wantKeys int
+			wantErr  bool
+		}{
+			name:    "Missing signing key",
+			args:    args{algorithm: "RS256", signingKeyPath: filepath.Join(dir, "missing.pem")},
+			wantErr: true,
+		},
+		struct {
+			name     string
+			args     args
+			wantKeys int
+			wantErr  bool
+		}{
+			name:    "Unsupported algorithm",
+			args:    args{algorithm: "none"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newKeySet(tt.args.algorithm, tt.args.signingKeyPath, tt.args.verificationKeyPaths)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newKeySet() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != nil && len(got.jwks().Keys) != tt.wantKeys {
+				t.Errorf("newKeySet() jwks keys = %v, want %v", len(got.jwks().Keys), tt.wantKeys)
+			}
+		})
+	}
+}
+
+func Test_jwtHandler_asymmetricRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "currency-api-keys")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	rsaPrivatePath, _, ecPrivatePath, ecPublicPath := writeTestKeys(t, dir)
+
+	oldKeys, err := newKeySet("ES256", ecPrivatePath, nil)
+	if err != nil {
+		t.Fatalf("newKeySet() error = %v", err)
+	}
+	newKeys, err := newKeySet("RS256", rsaPrivatePath, []string{ecPublicPath})
+	if err != nil {
+		t.Fatalf("newKeySet() error = %v", err)
+	}
+	unrotatedKeys, err := newKeySet("RS256", rsaPrivatePath, nil)
+	if err != nil {
+		t.Fatalf("newKeySet() error = %v", err)
+	}
+
+	mapClaims := jwt.MapClaims{"jti": "rotationId", "exp": time.Now().Add(time.Hour).Unix()}
+	oldHandler := &jwtHandler{tokenManager: newTokenManagerMock(), keys: oldKeys}
+	oldToken, err := oldHandler.generateJwtToken(mapClaims)
+	if err != nil {
+		t.Fatalf("jwtHandler.generateJwtToken() error = %v", err)
+	}
+	hmacHandler := &jwtHandler{tokenManager: newTokenManagerMock(), keys: &keySet{signingMethod: jwt.SigningMethodHS256}}
+	hmacToken, err := hmacHandler.generateJwtToken(mapClaims)
+	if err != nil {
+		t.Fatalf("jwtHandler.generateJwtToken() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		j           *jwtHandler
+		tokenString string
+		wantErr     bool
+	}{
+		struct {
+			name        string
+			j           *jwtHandler
+			tokenString string
+			wantErr     bool
+		}{
+			name:        "Old key still accepted during rotation",
+			j:           &jwtHandler{tokenManager: newTokenManagerMock(), keys: newKeys},
+			tokenString: oldToken,
+			wantErr:     false,
+		},
+		struct {
+			name        string
+			j           *jwtHandler
+			tokenString string
+			wantErr     bool
+		}{
+			name:        "Old key rejected once removed",
+			j:           &jwtHandler{tokenManager: newTokenManagerMock(), keys: unrotatedKeys},
+			tokenString: oldToken,
+			wantErr:     true,
+		},
+		struct {
+			name        string
+			j           *jwtHandler
+			tokenString string
+			wantErr     bool
+		}{
+			name:        "HMAC token rejected by asymmetric keys",
+			j:           &jwtHandler{tokenManager: newTokenManagerMock(), keys: newKeys},
+			tokenString: hmacToken,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.j.parseJwtToken(tt.tokenString)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("jwtHandler.parseJwtToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	newHandler := &jwtHandler{tokenManager: newTokenManagerMock(), keys: newKeys}
+	newToken, err := newHandler.generateJwtToken(mapClaims)
+	if err != nil {
+		t.Fatalf("jwtHandler.generateJwtToken() error = %v", err)
+	}
+	token, _ := jwt.Parse(newToken, newKeys.verificationKey)
+	if token == nil || token.Header["kid"] != newKeys.signingKeyID {
+		t.Errorf("jwtHandler.generateJwtToken() kid header missing, want %v", newKeys.signingKeyID)
+	}
+}
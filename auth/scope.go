@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/emanpicar/currency-api/apperror"
+)
+
+const (
+	ScopeRatesRead  = "rates:read"
+	ScopeRatesAdmin = "rates:admin"
+	ScopeUsersAdmin = "users:admin"
+)
+
+// ErrInsufficientScope is matched by errors.Is when the caller is authenticated but not allowed
+var ErrInsufficientScope = apperror.New(apperror.Forbidden, "insufficient_scope", "Insufficient scope")
+
+// AllScopes is granted to the bootstrap user
+var AllScopes = []string{ScopeRatesRead, ScopeRatesAdmin, ScopeUsersAdmin}
+
+type (
+	// Principal is the authenticated caller of a request
+	Principal struct {
+		Username string
+		Scopes   []string
+		// APIKeyPrefix identifies the API key used, empty for JWT authentication
+		APIKeyPrefix string
+	}
+
+	principalContextKey struct{}
+)
+
+// NewContext returns a copy of ctx carrying the principal
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// FromContext returns the principal stored by NewContext, nil if there is none
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalContextKey{}).(*Principal)
+
+	return principal
+}
+
+// Subject identifies the caller for quotas and logs, the API key prefix when one was used
+func (p *Principal) Subject() string {
+	if p.APIKeyPrefix != "" {
+		return p.APIKeyPrefix
+	}
+
+	return p.Username
+}
+
+func (p *Principal) HasScopes(requiredScopes ...string) bool {
+	granted := make(map[string]bool)
+	for _, scope := range p.Scopes {
+		granted[scope] = true
+	}
+
+	for _, scope := range requiredScopes {
+		if !granted[scope] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *Principal) requireScopes(requiredScopes ...string) error {
+	if !p.HasScopes(requiredScopes...) {
+		return ErrInsufficientScope.WithMessage(fmt.Sprintf("Insufficient scope: %v required", strings.Join(requiredScopes, ", "))).
+			WithDetails(map[string]interface{}{"required_scopes": requiredScopes})
+	}
+
+	return nil
+}
+
+// parseScopes splits the space separated form used in the JWT "scope" claim and the users table
+func parseScopes(scopes string) []string {
+	return strings.Fields(scopes)
+}
+
+func joinScopes(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
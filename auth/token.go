@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/emanpicar/currency-api/apperror"
+	"github.com/emanpicar/currency-api/db"
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/entities/jsondata"
+	"github.com/emanpicar/currency-api/logger"
+	"github.com/emanpicar/currency-api/settings"
+	"github.com/jinzhu/gorm"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var errInvalidRefreshToken = apperror.New(apperror.Unauthorized, "invalid_refresh_token", "Invalid refresh token")
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh exchanges a single-use refresh token for a new access and refresh token pair.
+// Presenting an already used refresh token revokes every refresh token of its user.
+func (a *authHandler) Refresh(body io.ReadCloser) (*jsondata.Token, error) {
+	var request RefreshRequest
+	if err := decodeBody(body, &request); err != nil {
+		return nil, err
+	}
+
+	if request.RefreshToken == "" {
+		return nil, errInvalidRefreshToken
+	}
+
+	now := time.Now()
+	refreshToken, err := a.tokenManager.ConsumeRefreshToken(hashToken(request.RefreshToken), now)
+	if errors.Is(err, db.ErrRefreshTokenUnusable) && refreshToken != nil && refreshToken.UsedAt != nil && refreshToken.RevokedAt == nil {
+		logger.Log.Warnf("Refresh token reuse detected for username: %v, revoking all refresh tokens", refreshToken.Username)
+		if err := a.tokenManager.RevokeUserRefreshTokens(refreshToken.Username, now); err != nil {
+			logger.Log.Errorf("Unable to revoke refresh tokens of %v: %v", refreshToken.Username, err)
+		}
+	}
+	if errors.Is(err, db.ErrRefreshTokenUnusable) || errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Log.Warnf("Rejected refresh token: %v", err)
+		return nil, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	principal, err := a.activePrincipal(refreshToken.Username)
+	if err != nil {
+		return nil, err
+	}
+	if principal == nil {
+		return nil, errInvalidRefreshToken
+	}
+
+	return a.issueTokens(principal)
+}
+
+// Logout denylists the presented access token until it expires, and revokes the refresh token in the body if any
+func (a *authHandler) Logout(r *http.Request) error {
+	tokenString, err := a.bearerToken(r)
+	if err != nil {
+		return err
+	}
+
+	mapClaims, err := a.jwtManager.parseJwtToken(tokenString)
+	if err != nil {
+		return err
+	}
+
+	jti, _ := mapClaims["jti"].(string)
+	username, _ := mapClaims["username"].(string)
+	exp, _ := mapClaims["exp"].(float64)
+
+	err = a.tokenManager.CreateRevokedToken(&dbdata.RevokedToken{JTI: jti, ExpiresAt: time.Unix(int64(exp), 0)})
+	if err != nil {
+		return err
+	}
+
+	var request RefreshRequest
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+			return errMalformedBody.WithErr(err)
+		}
+	}
+
+	if request.RefreshToken != "" {
+		if err := a.tokenManager.RevokeRefreshToken(hashToken(request.RefreshToken), username, time.Now()); err != nil {
+			return err
+		}
+	}
+	logger.Log.Infof("Successfully logged out username: %v", username)
+
+	return nil
+}
+
+// issueTokens embeds the principal scopes in the access token, refreshing picks up the current scopes of the user
+func (a *authHandler) issueTokens(principal *Principal) (*jsondata.Token, error) {
+	now := time.Now()
+	jti, err := generateRandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	mapClaims := jwt.MapClaims{
+		"jti":        jti,
+		"username":   principal.Username,
+		"scope":      joinScopes(principal.Scopes),
+		"authorized": true,
+		"iat":        now.Unix(),
+		"exp":        now.Add(settings.GetAccessTokenTTL()).Unix(),
+	}
+
+	tokenString, err := a.jwtManager.generateJwtToken(mapClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := generateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.tokenManager.CreateRefreshToken(&dbdata.RefreshToken{
+		TokenHash: hashToken(refreshToken),
+		Username:  principal.Username,
+		ExpiresAt: now.Add(settings.GetRefreshTokenTTL()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Log.Infoln("Successfully generated JWT token")
+
+	return &jsondata.Token{
+		AccessToken:  tokenString,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(settings.GetAccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// activePrincipal returns nil when the user no longer exists or has been disabled, an error only when the lookup failed
+func (a *authHandler) activePrincipal(username string) (*Principal, error) {
+	dbUser, err := a.userManager.GetUserByUsername(username)
+	if err == nil {
+		if !dbUser.Enabled {
+			return nil, nil
+		}
+		return &Principal{Username: dbUser.Username, Scopes: parseScopes(dbUser.Scopes)}, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if settings.GetInMemoryUsersEnabled() {
+		return a.inMemoryPrincipal(username), nil
+	}
+
+	return nil, nil
+}
+
+func generateRandomToken(size int) (string, error) {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Refresh tokens are random and long enough that a fast hash is sufficient
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/emanpicar/currency-api/db"
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/jinzhu/gorm"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+type (
+	tokenManagerMock struct {
+		refreshTokens map[string]*dbdata.RefreshToken
+		revokedTokens map[string]bool
+	}
+)
+
+func newTokenManagerMock() *tokenManagerMock {
+	return &tokenManagerMock{refreshTokens: make(map[string]*dbdata.RefreshToken), revokedTokens: make(map[string]bool)}
+}
+
+func (m *tokenManagerMock) CreateRefreshToken(refreshToken *dbdata.RefreshToken) error {
+	m.refreshTokens[refreshToken.TokenHash] = refreshToken
+	return nil
+}
+
+func (m *tokenManagerMock) ConsumeRefreshToken(tokenHash string, consumedAt time.Time) (*dbdata.RefreshToken, error) {
+	refreshToken, ok := m.refreshTokens[tokenHash]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	if refreshToken.UsedAt != nil || refreshToken.RevokedAt != nil || !refreshToken.ExpiresAt.After(consumedAt) {
+		return refreshToken, db.ErrRefreshTokenUnusable
+	}
+
+	refreshToken.UsedAt = &consumedAt
+	return refreshToken, nil
+}
+
+func (m *tokenManagerMock) RevokeRefreshToken(tokenHash, username string, revokedAt time.Time) error {
+	if refreshToken, ok := m.refreshTokens[tokenHash]; ok && refreshToken.Username == username {
+		refreshToken.RevokedAt = &revokedAt
+	}
+	return nil
+}
+
+func (m *tokenManagerMock) RevokeUserRefreshTokens(username string, revokedAt time.Time) error {
+	for _, refreshToken := range m.refreshTokens {
+		if refreshToken.Username == username && refreshToken.RevokedAt == nil {
+			refreshToken.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func (m *tokenManagerMock) CreateRevokedToken(revokedToken *dbdata.RevokedToken) error {
+	m.revokedTokens[revokedToken.JTI] = true
+	return nil
+}
+
+func (m *tokenManagerMock) IsTokenRevoked(jti string) (bool, error) {
+	return m.revokedTokens[jti], nil
+}
+
+func Test_authHandler_Refresh(t *testing.T) {
+	a := &authHandler{jwtManager: &jwtHandlerMock{}, userManager: &userManagerMock{}, tokenManager: newTokenManagerMock()}
+	issued, err := a.issueTokens(&Principal{Username: "dbuser"})
+	if err != nil {
+		t.Fatalf("authHandler.issueTokens() error = %v", err)
+	}
+	disabled, err := a.issueTokens(&Principal{Username: "disableduser"})
+	if err != nil {
+		t.Fatalf("authHandler.issueTokens() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		refreshToken string
+		wantErr      bool
+	}{
+		struct {
+			name         string
+			refreshToken string
+			wantErr      bool
+		}{
+			name:         "Valid refresh token",
+			refreshToken: issued.RefreshToken,
+			wantErr:      false,
+		},
+		struct {
+			name         string
+			refreshToken string
+			wantErr      bool
+		}{
+			name:         "Refresh token already used",
+			refreshToken: issued.RefreshToken,
+			wantErr:      true,
+		},
+		struct {
+			name         string
+			refreshToken string
+			wantErr      bool
+		}{
+			name:         "Unknown refresh token",
+			refreshToken: "unknownRefreshToken",
+			wantErr:      true,
+		},
+		struct {
+			name         string
+			refreshToken string
+			wantErr      bool
+		}{
+			name:         "Disabled user",
+			refreshToken: disabled.RefreshToken,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := ioutil.NopCloser(bytes.NewReader([]byte(`{"refresh_token": "` + tt.refreshToken + `"}`)))
+			got, err := a.Refresh(body)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("authHandler.Refresh() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != nil && (got.AccessToken != mockTokenResult || got.RefreshToken == tt.refreshToken) {
+				t.Errorf("authHandler.Refresh() = %v, want a new token pair", got)
+			}
+		})
+	}
+
+	for _, refreshToken := range a.tokenManager.(*tokenManagerMock).refreshTokens {
+		if refreshToken.Username == "dbuser" && refreshToken.RevokedAt == nil {
+			t.Errorf("authHandler.Refresh() reuse did not revoke refresh token %v", refreshToken.TokenHash)
+		}
+	}
+}
+
+func Test_authHandler_Logout(t *testing.T) {
+	tokenManager := newTokenManagerMock()
+	a := &authHandler{jwtManager: &jwtHandlerMock{}, userManager: &userManagerMock{}, tokenManager: tokenManager}
+	issued, err := a.issueTokens(&Principal{Username: "dbuser"})
+	if err != nil {
+		t.Fatalf("authHandler.issueTokens() error = %v", err)
+	}
+
+	r := &http.Request{
+		Header: http.Header{"Authorization": []string{"Bearer accessTokenId"}},
+		Body:   ioutil.NopCloser(bytes.NewReader([]byte(`{"refresh_token": "` + issued.RefreshToken + `"}`))),
+	}
+	if err := a.Logout(r); err != nil {
+		t.Fatalf("authHandler.Logout() error = %v", err)
+	}
+
+	if !tokenManager.revokedTokens["accessTokenId"] {
+		t.Errorf("authHandler.Logout() did not denylist the access token")
+	}
+	if tokenManager.refreshTokens[hashToken(issued.RefreshToken)].RevokedAt == nil {
+		t.Errorf("authHandler.Logout() did not revoke the refresh token")
+	}
+}
+
+func Test_jwtHandler_parseJwtToken(t *testing.T) {
+	tokenManager := newTokenManagerMock()
+	tokenManager.revokedTokens["revokedId"] = true
+	j := &jwtHandler{tokenManager: tokenManager, keys: &keySet{signingMethod: jwt.SigningMethodHS256}}
+
+	signedToken := func(mapClaims jwt.MapClaims) string {
+		tokenString, err := j.generateJwtToken(mapClaims)
+		if err != nil {
+			t.Fatalf("jwtHandler.generateJwtToken() error = %v", err)
+		}
+		return tokenString
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name        string
+		tokenString string
+		wantErr     bool
+	}{
+		struct {
+			name        string
+			tokenString string
+			wantErr     bool
+		}{
+			name:        "Valid token",
+			tokenString: signedToken(jwt.MapClaims{"jti": "validId", "exp": exp}),
+			wantErr:     false,
+		},
+		struct {
+			name        string
+			tokenString string
+			wantErr     bool
+		}{
+			name:        "Revoked token",
+			tokenString: signedToken(jwt.MapClaims{"jti": "revokedId", "exp": exp}),
+			wantErr:     true,
+		},
+		struct {
+			name        string
+			tokenString string
+			wantErr     bool
+		}{
+			name:        "Token without id",
+			tokenString: signedToken(jwt.MapClaims{"exp": exp}),
+			wantErr:     true,
+		},
+		struct {
+			name        string
+			tokenString string
+			wantErr     bool
+		}{
+			name:        "Expired token",
+			tokenString: signedToken(jwt.MapClaims{"jti": "expiredId", "exp": time.Now().Add(-time.Hour).Unix()}),
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := j.parseJwtToken(tt.tokenString)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("jwtHandler.parseJwtToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,27 +1,92 @@
 package db
 
 import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"time"
 
+	"github.com/emanpicar/currency-api/apperror"
+	"github.com/emanpicar/currency-api/decimal"
 	"github.com/emanpicar/currency-api/entities/dbdata"
 	"github.com/emanpicar/currency-api/logger"
 	"github.com/emanpicar/currency-api/settings"
 	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
 
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 )
 
+// rateScale is the number of decimals kept by the rate columns
+const rateScale = 8
+
+var (
+	// ErrRefreshTokenUnusable is returned with the stored token when it exists but is expired, revoked or already used
+	ErrRefreshTokenUnusable = errors.New("Refresh token is expired, revoked or already used")
+	// ErrNotFound wraps gorm.ErrRecordNotFound, its message names what is missing
+	ErrNotFound = apperror.New(apperror.NotFound, "not_found", "Record not found")
+	// ErrUnavailable is returned when the database cannot be reached, clients may retry later
+	ErrUnavailable = apperror.New(apperror.Unavailable, "database_unavailable", "Database is unavailable")
+	errDatabase    = apperror.New(apperror.Internal, "database_error", "Database error")
+)
+
 type (
 	Manager interface {
-		BatchFirstOrCreate(dbEnvelopeList *[]dbdata.Envelope)
-		GetLatestRates() (*dbdata.Envelope, error)
-		GetRatesByDate(cubeTime string) (*dbdata.Envelope, error)
-		GetAnalyzedRates() (*dbdata.QuantitativeExchangeRate, error)
+		BatchUpsert(dbEnvelopeList *[]dbdata.Envelope) (*UpsertSummary, error)
+		GetLatestRates(query RateQuery) (*dbdata.Envelope, error)
+		GetRatesByDate(query RateQuery, cubeTime string) (*dbdata.Envelope, error)
+		GetRatesByDateRange(query RateQuery, start, end string, currencies []string) ([]dbdata.Envelope, error)
+		GetRatesOnOrBefore(query RateQuery, cubeTime, earliest string) (*dbdata.Envelope, error)
+		GetRatesOnOrAfter(query RateQuery, cubeTime, latest string) (*dbdata.Envelope, error)
+		GetUserByUsername(username string) (*dbdata.User, error)
+		CreateUser(user *dbdata.User) error
+		CreateRefreshToken(refreshToken *dbdata.RefreshToken) error
+		ConsumeRefreshToken(tokenHash string, consumedAt time.Time) (*dbdata.RefreshToken, error)
+		RevokeRefreshToken(tokenHash, username string, revokedAt time.Time) error
+		RevokeUserRefreshTokens(username string, revokedAt time.Time) error
+		CreateRevokedToken(revokedToken *dbdata.RevokedToken) error
+		IsTokenRevoked(jti string) (bool, error)
+		CreateAPIKey(apiKey *dbdata.APIKey) error
+		GetAPIKeyByPrefix(prefix string) (*dbdata.APIKey, error)
+		ListAPIKeys(username string) ([]dbdata.APIKey, error)
+		RevokeAPIKey(prefix, username string, revokedAt time.Time) error
+		TouchAPIKey(apiKey *dbdata.APIKey, usedAt time.Time) error
+		IncrementQuotaUsage(subject string, day time.Time, weight int) (int, error)
+		SaveIngestionRun(run *dbdata.IngestionRun) error
+		ListIngestionRuns(source string, limit int) ([]dbdata.IngestionRun, error)
+		CountIngestionRuns(filter *dbdata.IngestionRun) (int, error)
+		QuarantineDay(day *dbdata.QuarantinedDay) error
+		ListQuarantinedDays(source string, limit int) ([]dbdata.QuarantinedDay, error)
+		ListCurrencies(source string) ([]CurrencyRange, error)
 	}
 
 	dbHandler struct {
 		database *gorm.DB
 	}
+
+	// RateQuery selects the rates of Source as they were recorded at AsOf, the current rates when AsOf is zero
+	RateQuery struct {
+		Source string
+		AsOf   time.Time
+	}
+
+	// CurrencyRange is the first and last stored day with a current rate of Currency
+	CurrencyRange struct {
+		Currency  string
+		FirstDate string
+		LastDate  string
+	}
+
+	// UpsertSummary counts what BatchUpsert stored, DaysUpdated counts stored days that gained or corrected a rate
+	UpsertSummary struct {
+		DaysCreated  int
+		DaysUpdated  int
+		CubesCreated int
+		CubesUpdated int
+	}
 )
 
 func NewManager() Manager {
@@ -48,51 +113,459 @@ func (dbHandler *dbHandler) connect(openConnection func(dialect string, args ...
 }
 
 func (dbHandler *dbHandler) migrateTables() {
-	dbHandler.database.AutoMigrate(&dbdata.Envelope{})
+	// The unique index replaces the plain one, which is kept while duplicate days prevent creating it
+	if err := dbHandler.database.AutoMigrate(&dbdata.Envelope{}).Error; err != nil {
+		logger.Log.Errorf("Unable to enforce one envelope per source and day, remove the duplicate days: %v", err)
+	} else {
+		dbHandler.database.Exec("DROP INDEX IF EXISTS idx_envelopes_source_cube_time")
+	}
 	dbHandler.database.AutoMigrate(&dbdata.Cube{}).AddForeignKey("envelope_id", "envelopes(id)", "CASCADE", "CASCADE")
+	dbHandler.database.Exec("UPDATE cubes SET recorded_at = created_at WHERE recorded_at IS NULL")
+	if err := dbHandler.database.Exec("CREATE UNIQUE INDEX IF NOT EXISTS uix_cubes_current ON cubes (envelope_id, currency) " +
+		"WHERE superseded_at IS NULL AND deleted_at IS NULL").Error; err != nil {
+		logger.Log.Errorf("Unable to enforce one current rate per currency and day: %v", err)
+	}
+	dbHandler.database.AutoMigrate(&dbdata.User{})
+	dbHandler.database.AutoMigrate(&dbdata.RefreshToken{})
+	dbHandler.database.AutoMigrate(&dbdata.RevokedToken{})
+	dbHandler.database.AutoMigrate(&dbdata.APIKey{})
+	dbHandler.database.AutoMigrate(&dbdata.QuotaUsage{})
+	dbHandler.database.AutoMigrate(&dbdata.RateChange{})
+	dbHandler.database.AutoMigrate(&dbdata.IngestionRun{})
+	dbHandler.database.AutoMigrate(&dbdata.QuarantinedDay{})
+	if err := dbHandler.database.Exec("CREATE UNIQUE INDEX IF NOT EXISTS uix_quarantined_days_day " +
+		"ON quarantined_days (source, cube_time, md5(reasons)) WHERE deleted_at IS NULL").Error; err != nil {
+		logger.Log.Errorf("Unable to enforce one quarantined day per source, day and reasons: %v", err)
+	}
+}
+
+// BatchUpsert stores new days, adds missing currencies to stored days and corrects changed rates, one transaction per day.
+// A corrected rate is stored as a new revision superseding the previous one, which is kept for as of queries.
+// Added and corrected rates of stored days are recorded as rate changes, days of other sources are never touched.
+func (dbHandler *dbHandler) BatchUpsert(dbEnvelopeList *[]dbdata.Envelope) (*UpsertSummary, error) {
+	summary := &UpsertSummary{}
+
+	for index := range *dbEnvelopeList {
+		daySummary, err := dbHandler.upsertDay(&(*dbEnvelopeList)[index])
+		if isUniqueViolation(err) {
+			// Another ingestion stored the same day meanwhile, the retry finds and updates it
+			logger.Log.Warnf("Day %v was stored concurrently, retrying: %v", (*dbEnvelopeList)[index].CubeTime, err)
+			daySummary, err = dbHandler.upsertDay(&(*dbEnvelopeList)[index])
+		}
+		if err != nil {
+			return summary, wrapError(err, "")
+		}
+
+		summary.Add(daySummary)
+	}
+
+	return summary, nil
+}
+
+func (dbHandler *dbHandler) upsertDay(envelope *dbdata.Envelope) (UpsertSummary, error) {
+	tx := dbHandler.database.Begin()
+	if tx.Error != nil {
+		return UpsertSummary{}, tx.Error
+	}
+
+	summary, err := dbHandler.upsertEnvelope(tx, envelope)
+	if err != nil {
+		tx.Rollback()
+		return UpsertSummary{}, err
+	}
+
+	return summary, tx.Commit().Error
 }
 
-func (dbHandler *dbHandler) BatchFirstOrCreate(dbEnvelopeList *[]dbdata.Envelope) {
-	for _, envelope := range *dbEnvelopeList {
-		dbHandler.database.FirstOrCreate(&envelope, dbdata.Envelope{CubeTime: envelope.CubeTime})
+func (dbHandler *dbHandler) upsertEnvelope(tx *gorm.DB, envelope *dbdata.Envelope) (UpsertSummary, error) {
+	summary := UpsertSummary{}
+	stored := &dbdata.Envelope{}
+	recordedAt := time.Now()
+
+	err := tx.Preload("Cube", "superseded_at IS NULL").
+		Where(&dbdata.Envelope{Source: envelope.Source, CubeTime: envelope.CubeTime}).First(stored).Error
+	if gorm.IsRecordNotFoundError(err) {
+		for index := range envelope.Cube {
+			envelope.Cube[index].RecordedAt = recordedAt
+		}
+		if err := tx.Create(envelope).Error; err != nil {
+			return summary, err
+		}
+
+		summary.DaysCreated = 1
+		summary.CubesCreated = len(envelope.Cube)
+		return summary, nil
+	}
+	if err != nil {
+		return summary, err
 	}
+
+	storedCubes := make(map[string]*dbdata.Cube)
+	for index := range stored.Cube {
+		storedCubes[stored.Cube[index].Currency] = &stored.Cube[index]
+	}
+
+	for _, cube := range envelope.Cube {
+		change := &dbdata.RateChange{Source: stored.Source, CubeTime: stored.CubeTime, Currency: cube.Currency, NewRate: cube.Rate}
+		storedCube, ok := storedCubes[cube.Currency]
+
+		switch {
+		case !ok:
+			summary.CubesCreated++
+		case !sameRate(storedCube.Rate, cube.Rate):
+			oldRate := storedCube.Rate
+			change.OldRate = &oldRate
+			err = tx.Model(storedCube).Update("superseded_at", recordedAt).Error
+			summary.CubesUpdated++
+		default:
+			continue
+		}
+
+		if err == nil {
+			err = tx.Create(&dbdata.Cube{EnvelopeID: stored.ID, Currency: cube.Currency, Rate: cube.Rate, RecordedAt: recordedAt}).Error
+		}
+		if err == nil {
+			err = tx.Create(change).Error
+		}
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	if summary.CubesCreated > 0 || summary.CubesUpdated > 0 {
+		summary.DaysUpdated = 1
+	}
+
+	return summary, nil
 }
 
-func (dbHandler *dbHandler) GetLatestRates() (*dbdata.Envelope, error) {
+// Add accumulates the counts of other
+func (summary *UpsertSummary) Add(other UpsertSummary) {
+	summary.DaysCreated += other.DaysCreated
+	summary.DaysUpdated += other.DaysUpdated
+	summary.CubesCreated += other.CubesCreated
+	summary.CubesUpdated += other.CubesUpdated
+}
+
+// sameRate compares rates at the 8 decimals stored by the rate column
+func sameRate(stored, incoming decimal.Decimal) bool {
+	return stored.Round(rateScale, decimal.RoundHalfEven) == incoming.Round(rateScale, decimal.RoundHalfEven)
+}
+
+func (dbHandler *dbHandler) GetLatestRates(query RateQuery) (*dbdata.Envelope, error) {
 	env := &dbdata.Envelope{}
-	err := dbHandler.database.Set("gorm:auto_preload", true).Order("cube_time desc").First(env).Error
+	err := dbHandler.rates(query, nil).Order("cube_time desc").First(env).Error
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err, "No rates available")
 	}
 
 	return env, nil
 }
 
-func (dbHandler *dbHandler) GetRatesByDate(cubeTime string) (*dbdata.Envelope, error) {
+func (dbHandler *dbHandler) GetRatesByDate(query RateQuery, cubeTime string) (*dbdata.Envelope, error) {
 	env := &dbdata.Envelope{}
-	err := dbHandler.database.Set("gorm:auto_preload", true).Where(&dbdata.Envelope{CubeTime: cubeTime}).First(env).Error
+	err := dbHandler.rates(query, nil).Where(&dbdata.Envelope{CubeTime: cubeTime}).First(env).Error
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err, fmt.Sprintf("No rates available for %v", cubeTime))
 	}
 
 	return env, nil
 }
 
-func (dbHandler *dbHandler) GetAnalyzedRates() (*dbdata.QuantitativeExchangeRate, error) {
-	result := &dbdata.QuantitativeExchangeRate{RatesAnalyze: []dbdata.RatesAnalyze{}}
+// GetRatesOnOrBefore returns the latest stored day between earliest and cubeTime inclusive
+func (dbHandler *dbHandler) GetRatesOnOrBefore(query RateQuery, cubeTime, earliest string) (*dbdata.Envelope, error) {
+	env := &dbdata.Envelope{}
+	err := dbHandler.rates(query, nil).
+		Where("cube_time BETWEEN ? AND ?", earliest, cubeTime).Order("cube_time desc").First(env).Error
+	if err != nil {
+		return nil, wrapError(err, fmt.Sprintf("No rates available between %v and %v", earliest, cubeTime))
+	}
+
+	return env, nil
+}
 
+// GetRatesOnOrAfter returns the earliest stored day between cubeTime and latest inclusive
+func (dbHandler *dbHandler) GetRatesOnOrAfter(query RateQuery, cubeTime, latest string) (*dbdata.Envelope, error) {
 	env := &dbdata.Envelope{}
-	err := dbHandler.database.Set("gorm:auto_preload", false).First(env).Error
+	err := dbHandler.rates(query, nil).
+		Where("cube_time BETWEEN ? AND ?", cubeTime, latest).Order("cube_time asc").First(env).Error
+	if err != nil {
+		return nil, wrapError(err, fmt.Sprintf("No rates available between %v and %v", cubeTime, latest))
+	}
+
+	return env, nil
+}
+
+// GetRatesByDateRange returns envelopes ordered by date, preloading only the given currencies unless empty
+func (dbHandler *dbHandler) GetRatesByDateRange(query RateQuery, start, end string, currencies []string) ([]dbdata.Envelope, error) {
+	envelopes := []dbdata.Envelope{}
+	err := dbHandler.rates(query, currencies).Where("cube_time BETWEEN ? AND ?", start, end).Order("cube_time asc").Find(&envelopes).Error
+	if err != nil {
+		return nil, wrapError(err, "")
+	}
+
+	return envelopes, nil
+}
+
+// rates scopes envelopes to the source of query and preloads the cube revisions that were current at its AsOf,
+// only the given currencies unless empty. Days first recorded after AsOf are left out.
+func (dbHandler *dbHandler) rates(query RateQuery, currencies []string) *gorm.DB {
+	envelopes := dbHandler.database.Where("source = ?", query.Source)
+	cubeCondition := "superseded_at IS NULL"
+	cubeArgs := []interface{}{}
+
+	if !query.AsOf.IsZero() {
+		envelopes = envelopes.Where("created_at <= ?", query.AsOf)
+		cubeCondition = "recorded_at <= ? AND (superseded_at IS NULL OR superseded_at > ?)"
+		cubeArgs = append(cubeArgs, query.AsOf, query.AsOf)
+	}
+
+	if len(currencies) > 0 {
+		cubeCondition += " AND currency IN (?)"
+		cubeArgs = append(cubeArgs, currencies)
+	}
+
+	return envelopes.Preload("Cube", append([]interface{}{cubeCondition}, cubeArgs...)...)
+}
+
+func (dbHandler *dbHandler) GetUserByUsername(username string) (*dbdata.User, error) {
+	user := &dbdata.User{}
+	err := dbHandler.database.Where(&dbdata.User{Username: username}).First(user).Error
+	if err != nil {
+		return nil, wrapError(err, "User not found")
+	}
+
+	return user, nil
+}
+
+func (dbHandler *dbHandler) CreateUser(user *dbdata.User) error {
+	return wrapError(dbHandler.database.Create(user).Error, "")
+}
+
+func (dbHandler *dbHandler) CreateRefreshToken(refreshToken *dbdata.RefreshToken) error {
+	return wrapError(dbHandler.database.Create(refreshToken).Error, "")
+}
+
+// ConsumeRefreshToken marks the token as used in a single conditional update so it can only be consumed once
+func (dbHandler *dbHandler) ConsumeRefreshToken(tokenHash string, consumedAt time.Time) (*dbdata.RefreshToken, error) {
+	result := dbHandler.database.Model(&dbdata.RefreshToken{}).
+		Where("token_hash = ? AND used_at IS NULL AND revoked_at IS NULL AND expires_at > ?", tokenHash, consumedAt).
+		Update("used_at", consumedAt)
+	if result.Error != nil {
+		return nil, wrapError(result.Error, "")
+	}
+
+	refreshToken := &dbdata.RefreshToken{}
+	if err := dbHandler.database.Where(&dbdata.RefreshToken{TokenHash: tokenHash}).First(refreshToken).Error; err != nil {
+		return nil, wrapError(err, "Refresh token not found")
+	}
+
+	if result.RowsAffected == 0 {
+		return refreshToken, ErrRefreshTokenUnusable
+	}
+
+	return refreshToken, nil
+}
+
+func (dbHandler *dbHandler) RevokeRefreshToken(tokenHash, username string, revokedAt time.Time) error {
+	err := dbHandler.database.Model(&dbdata.RefreshToken{}).
+		Where("token_hash = ? AND username = ? AND revoked_at IS NULL", tokenHash, username).
+		Update("revoked_at", revokedAt).Error
+
+	return wrapError(err, "")
+}
+
+func (dbHandler *dbHandler) RevokeUserRefreshTokens(username string, revokedAt time.Time) error {
+	err := dbHandler.database.Model(&dbdata.RefreshToken{}).
+		Where("username = ? AND revoked_at IS NULL", username).
+		Update("revoked_at", revokedAt).Error
+
+	return wrapError(err, "")
+}
+
+// CreateRevokedToken also purges denylist entries whose tokens have expired in the meantime
+func (dbHandler *dbHandler) CreateRevokedToken(revokedToken *dbdata.RevokedToken) error {
+	err := dbHandler.database.Unscoped().Where("expires_at < ?", time.Now()).Delete(&dbdata.RevokedToken{}).Error
+	if err != nil {
+		return wrapError(err, "")
+	}
+
+	return wrapError(dbHandler.database.Create(revokedToken).Error, "")
+}
+
+func (dbHandler *dbHandler) IsTokenRevoked(jti string) (bool, error) {
+	count := 0
+	err := dbHandler.database.Model(&dbdata.RevokedToken{}).Where(&dbdata.RevokedToken{JTI: jti}).Count(&count).Error
+	if err != nil {
+		return false, wrapError(err, "")
+	}
+
+	return count > 0, nil
+}
+
+func (dbHandler *dbHandler) CreateAPIKey(apiKey *dbdata.APIKey) error {
+	return wrapError(dbHandler.database.Create(apiKey).Error, "")
+}
+
+func (dbHandler *dbHandler) GetAPIKeyByPrefix(prefix string) (*dbdata.APIKey, error) {
+	apiKey := &dbdata.APIKey{}
+	err := dbHandler.database.Where(&dbdata.APIKey{Prefix: prefix}).First(apiKey).Error
+	if err != nil {
+		return nil, wrapError(err, "API key not found")
+	}
+
+	return apiKey, nil
+}
+
+// ListAPIKeys returns the keys of username, or of every user when username is empty
+func (dbHandler *dbHandler) ListAPIKeys(username string) ([]dbdata.APIKey, error) {
+	apiKeys := []dbdata.APIKey{}
+	err := dbHandler.database.Where(&dbdata.APIKey{Username: username}).Order("created_at desc").Find(&apiKeys).Error
+	if err != nil {
+		return nil, wrapError(err, "")
+	}
+
+	return apiKeys, nil
+}
+
+// RevokeAPIKey revokes the key if owned by username, any owner when username is empty
+func (dbHandler *dbHandler) RevokeAPIKey(prefix, username string, revokedAt time.Time) error {
+	result := dbHandler.database.Model(&dbdata.APIKey{}).
+		Where(&dbdata.APIKey{Prefix: prefix, Username: username}).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", revokedAt)
+	if result.Error != nil {
+		return wrapError(result.Error, "")
+	}
+
+	if result.RowsAffected == 0 {
+		return wrapError(gorm.ErrRecordNotFound, "API key not found")
+	}
+
+	return nil
+}
+
+func (dbHandler *dbHandler) TouchAPIKey(apiKey *dbdata.APIKey, usedAt time.Time) error {
+	return wrapError(dbHandler.database.Model(apiKey).UpdateColumn("last_used_at", usedAt).Error, "")
+}
+
+// IncrementQuotaUsage adds weight to the usage of subject on day in a single statement and returns the new total
+func (dbHandler *dbHandler) IncrementQuotaUsage(subject string, day time.Time, weight int) (int, error) {
+	now := time.Now()
+	quotaUsage := &dbdata.QuotaUsage{}
+	err := dbHandler.database.Raw(`INSERT INTO quota_usages (created_at, updated_at, subject, day, count) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (subject, day) DO UPDATE SET count = quota_usages.count + EXCLUDED.count, updated_at = EXCLUDED.updated_at
+		RETURNING count`, now, now, subject, day.Format("2006-01-02"), weight).Scan(quotaUsage).Error
+	if err != nil {
+		return 0, wrapError(err, "")
+	}
+
+	return quotaUsage.Count, nil
+}
+
+// SaveIngestionRun inserts a new run or updates it once finished
+func (dbHandler *dbHandler) SaveIngestionRun(run *dbdata.IngestionRun) error {
+	return wrapError(dbHandler.database.Save(run).Error, "")
+}
+
+// ListIngestionRuns returns the latest runs first, of every source when source is empty
+func (dbHandler *dbHandler) ListIngestionRuns(source string, limit int) ([]dbdata.IngestionRun, error) {
+	runs := []dbdata.IngestionRun{}
+	err := dbHandler.database.Where(&dbdata.IngestionRun{Source: source}).Order("started_at desc").Limit(limit).Find(&runs).Error
+	if err != nil {
+		return nil, wrapError(err, "")
+	}
+
+	return runs, nil
+}
+
+// CountIngestionRuns counts the runs matching the non-zero fields of filter
+func (dbHandler *dbHandler) CountIngestionRuns(filter *dbdata.IngestionRun) (int, error) {
+	count := 0
+	if err := dbHandler.database.Model(&dbdata.IngestionRun{}).Where(filter).Count(&count).Error; err != nil {
+		return 0, wrapError(err, "")
+	}
+
+	return count, nil
+}
+
+// QuarantineDay keeps day for review, a day already quarantined by the same source for the same reasons is skipped
+func (dbHandler *dbHandler) QuarantineDay(day *dbdata.QuarantinedDay) error {
+	now := time.Now()
+	result := dbHandler.database.Exec(`INSERT INTO quarantined_days
+		(created_at, updated_at, ingestion_run_id, source, sender_name, cube_time, rates, reasons) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`, now, now, day.IngestionRunID, day.Source, day.SenderName, day.CubeTime, day.Rates, day.Reasons)
+	if result.Error != nil {
+		return wrapError(result.Error, "")
+	}
+	if result.RowsAffected == 0 {
+		logger.Log.Infof("Day %v of %v rates is already quarantined for the same reasons", day.CubeTime, day.Source)
+	}
+
+	return nil
+}
+
+// ListQuarantinedDays returns the latest quarantined days first, of every source when source is empty
+func (dbHandler *dbHandler) ListQuarantinedDays(source string, limit int) ([]dbdata.QuarantinedDay, error) {
+	days := []dbdata.QuarantinedDay{}
+	err := dbHandler.database.Where(&dbdata.QuarantinedDay{Source: source}).Order("created_at desc").Limit(limit).Find(&days).Error
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err, "")
 	}
 
-	result.Base = env.SenderName
+	return days, nil
+}
+
+// wrapError classifies gorm and driver errors, notFoundMessage names what is missing when no record matched
+func wrapError(err error, notFoundMessage string) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if notFoundMessage == "" {
+			return ErrNotFound.WithErr(err)
+		}
+		return ErrNotFound.WithMessage(notFoundMessage).WithErr(err)
+	case isConnectionError(err):
+		return ErrUnavailable.WithErr(err)
+	}
+
+	return errDatabase.WithErr(err)
+}
+
+// isUniqueViolation reports a PostgreSQL unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// isConnectionError reports network failures and the PostgreSQL connection, shutdown and too many connections errors
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	var pqErr *pq.Error
+
+	switch {
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case errors.As(err, &netErr):
+		return true
+	case errors.As(err, &pqErr):
+		return pqErr.Code.Class() == "08" || pqErr.Code.Class() == "57" || pqErr.Code == "53300"
+	}
+
+	return false
+}
 
-	err = dbHandler.database.Raw("SELECT currency, min(rate) AS min, max(rate) AS max, avg(rate) AS avg FROM cubes GROUP BY currency").Scan(&result.RatesAnalyze).Error
+func (dbHandler *dbHandler) ListCurrencies(source string) ([]CurrencyRange, error) {
+	ranges := []CurrencyRange{}
+	err := dbHandler.database.Table("cubes").
+		Select("cubes.currency, MIN(envelopes.cube_time) AS first_date, MAX(envelopes.cube_time) AS last_date").
+		Joins("JOIN envelopes ON envelopes.id = cubes.envelope_id").
+		Where("envelopes.source = ? AND envelopes.deleted_at IS NULL AND cubes.deleted_at IS NULL AND cubes.superseded_at IS NULL", source).
+		Group("cubes.currency").Order("cubes.currency").Scan(&ranges).Error
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err, "")
 	}
 
-	return result, nil
+	return ranges, nil
 }
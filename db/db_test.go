@@ -2,13 +2,19 @@ package db
 
 import (
 	"database/sql"
+	"errors"
+	"net"
 	"reflect"
 	"testing"
+	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/emanpicar/currency-api/apperror"
+	"github.com/emanpicar/currency-api/decimal"
 	"github.com/emanpicar/currency-api/entities/dbdata"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/lib/pq"
 )
 
 var (
@@ -83,7 +89,7 @@ func Test_dbHandler_GetLatestRates(t *testing.T) {
 					AddRow("Dummy Sender", "2020-06-02"))
 			}
 
-			got, err := tt.dbHandler.GetLatestRates()
+			got, err := tt.dbHandler.GetLatestRates(RateQuery{Source: "ecb"})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("dbHandler.GetLatestRates() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -155,7 +161,7 @@ func Test_dbHandler_GetRatesByDate(t *testing.T) {
 					AddRow("Dummy Sender", dummyCubeTime))
 			}
 
-			got, err := tt.dbHandler.GetRatesByDate(tt.args.cubeTime)
+			got, err := tt.dbHandler.GetRatesByDate(RateQuery{Source: "ecb"}, tt.args.cubeTime)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("dbHandler.GetRatesByDate() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -170,14 +176,128 @@ func Test_dbHandler_GetRatesByDate(t *testing.T) {
 	}
 }
 
-func Test_dbHandler_GetAnalyzedRates(t *testing.T) {
+func Test_dbHandler_GetRatesByDate_asOf(t *testing.T) {
 	beforeEach()
 	defer afterEach()
 
+	asOf := time.Date(2020, 6, 2, 12, 0, 0, 0, time.UTC)
+	mockSQL.ExpectQuery(`SELECT \* FROM \"envelopes\" WHERE (.+)source = (.+)created_at <= (.+)\"envelopes\"\.\"cube_time\" = (.+) LIMIT 1`).
+		WithArgs("ecb", asOf, "2020-06-01").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "cube_time"}).AddRow(1, "2020-06-01"))
+	mockSQL.ExpectQuery(`SELECT \* FROM \"cubes\" WHERE (.+)recorded_at <= (.+) AND \(superseded_at IS NULL OR superseded_at > (.+)\)`).
+		WithArgs(1, asOf, asOf).
+		WillReturnRows(sqlmock.NewRows([]string{"envelope_id", "currency", "rate"}).AddRow(1, "USD", 1.1136))
+
+	got, err := (&dbHandler{database: gormDB}).GetRatesByDate(RateQuery{Source: "ecb", AsOf: asOf}, "2020-06-01")
+	if err != nil {
+		t.Errorf("dbHandler.GetRatesByDate() error = %v", err)
+		return
+	}
+	if err = mockSQL.ExpectationsWereMet(); err != nil {
+		t.Errorf("mockSQL.ExpectationsWereMet() error = %v", err)
+	}
+	want := []dbdata.Cube{dbdata.Cube{EnvelopeID: 1, Currency: "USD", Rate: decimal.RequireFromString("1.1136")}}
+	if !reflect.DeepEqual(got.Cube, want) {
+		t.Errorf("dbHandler.GetRatesByDate() cubes = %v, want %v", got.Cube, want)
+	}
+}
+
+func Test_dbHandler_GetRatesOnOrBeforeAndAfter(t *testing.T) {
+	beforeEach()
+	defer afterEach()
+
+	tests := []struct {
+		name          string
+		getRates      func(dbHandler *dbHandler) (*dbdata.Envelope, error)
+		expectedQuery string
+		wantCubeTime  string
+		wantErr       bool
+	}{
+		struct {
+			name          string
+			getRates      func(dbHandler *dbHandler) (*dbdata.Envelope, error)
+			expectedQuery string
+			wantCubeTime  string
+			wantErr       bool
+		}{
+			name: "Previous business day",
+			getRates: func(dbHandler *dbHandler) (*dbdata.Envelope, error) {
+				return dbHandler.GetRatesOnOrBefore(RateQuery{Source: "ecb"}, "2020-05-30", "2020-05-23")
+			},
+			expectedQuery: `SELECT \* FROM \"envelopes\" WHERE (.+)cube_time BETWEEN (.+) ORDER BY cube_time desc(.+)LIMIT 1`,
+			wantCubeTime:  "2020-05-29",
+			wantErr:       false,
+		},
+		struct {
+			name          string
+			getRates      func(dbHandler *dbHandler) (*dbdata.Envelope, error)
+			expectedQuery string
+			wantCubeTime  string
+			wantErr       bool
+		}{
+			name: "Next business day",
+			getRates: func(dbHandler *dbHandler) (*dbdata.Envelope, error) {
+				return dbHandler.GetRatesOnOrAfter(RateQuery{Source: "ecb"}, "2020-05-30", "2020-06-06")
+			},
+			expectedQuery: `SELECT \* FROM \"envelopes\" WHERE (.+)cube_time BETWEEN (.+) ORDER BY cube_time asc(.+)LIMIT 1`,
+			wantCubeTime:  "2020-06-01",
+			wantErr:       false,
+		},
+		struct {
+			name          string
+			getRates      func(dbHandler *dbHandler) (*dbdata.Envelope, error)
+			expectedQuery string
+			wantCubeTime  string
+			wantErr       bool
+		}{
+			name: "No business day within range",
+			getRates: func(dbHandler *dbHandler) (*dbdata.Envelope, error) {
+				return dbHandler.GetRatesOnOrAfter(RateQuery{Source: "ecb"}, "2030-01-01", "2030-01-08")
+			},
+			expectedQuery: `SELECT \* FROM \"envelopes\" WHERE (.+)cube_time BETWEEN (.+) ORDER BY cube_time asc(.+)LIMIT 1`,
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := sqlmock.NewRows([]string{"sender_name", "cube_time"})
+			if !tt.wantErr {
+				rows.AddRow("Dummy Sender", tt.wantCubeTime)
+			}
+			mockSQL.ExpectQuery(tt.expectedQuery).WillReturnRows(rows)
+
+			got, err := tt.getRates(&dbHandler{database: gormDB})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("dbHandler.GetRatesOnOrBefore/After() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !errors.Is(err, ErrNotFound) {
+				t.Errorf("dbHandler.GetRatesOnOrBefore/After() error = %v, want %v", err, ErrNotFound)
+			}
+			if err = mockSQL.ExpectationsWereMet(); err != nil {
+				t.Errorf("mockSQL.ExpectationsWereMet() error = %v", err)
+			}
+			if got != nil && got.CubeTime != tt.wantCubeTime {
+				t.Errorf("dbHandler.GetRatesOnOrBefore/After() = %v, want %v", got.CubeTime, tt.wantCubeTime)
+			}
+		})
+	}
+}
+
+func Test_dbHandler_GetRatesByDateRange(t *testing.T) {
+	beforeEach()
+	defer afterEach()
+
+	type args struct {
+		start      string
+		end        string
+		currencies []string
+	}
 	tests := []struct {
 		name             string
 		dbHandler        *dbHandler
-		want             *dbdata.QuantitativeExchangeRate
+		args             args
+		want             []dbdata.Envelope
 		wantErr          bool
 		expected1stQuery string
 		expected2ndQuery string
@@ -185,58 +305,581 @@ func Test_dbHandler_GetAnalyzedRates(t *testing.T) {
 		struct {
 			name             string
 			dbHandler        *dbHandler
-			want             *dbdata.QuantitativeExchangeRate
+			args             args
+			want             []dbdata.Envelope
 			wantErr          bool
 			expected1stQuery string
 			expected2ndQuery string
 		}{
-			name:      "Get analyzed rates - Success",
+			name:      "Get rates by date range - Success",
 			dbHandler: &dbHandler{database: gormDB},
-			want: &dbdata.QuantitativeExchangeRate{
-				Base:         "Dummy Sender",
-				RatesAnalyze: []dbdata.RatesAnalyze{dbdata.RatesAnalyze{"PHP", 50.555, 60.555, 55.555}},
+			args:      args{start: "2020-06-01", end: "2020-06-02", currencies: []string{"PHP"}},
+			want: []dbdata.Envelope{
+				dbdata.Envelope{Model: gorm.Model{ID: 1}, CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+					dbdata.Cube{EnvelopeID: 1, Currency: "PHP", Rate: decimal.RequireFromString("50.555")},
+				}},
+				dbdata.Envelope{Model: gorm.Model{ID: 2}, CubeTime: "2020-06-02", Cube: []dbdata.Cube{
+					dbdata.Cube{EnvelopeID: 2, Currency: "PHP", Rate: decimal.RequireFromString("51.555")},
+				}},
 			},
 			wantErr:          false,
-			expected1stQuery: `SELECT \* FROM \"envelopes\" (.+) LIMIT 1`,
-			expected2ndQuery: `SELECT currency, min\(rate\) AS min, max\(rate\) AS max, avg\(rate\) AS avg FROM cubes GROUP BY currency`,
+			expected1stQuery: `SELECT \* FROM \"envelopes\" WHERE (.+)cube_time BETWEEN (.+) ORDER BY cube_time asc`,
+			expected2ndQuery: `SELECT \* FROM \"cubes\" WHERE (.+)currency IN (.+)`,
 		},
 		struct {
 			name             string
 			dbHandler        *dbHandler
-			want             *dbdata.QuantitativeExchangeRate
+			args             args
+			want             []dbdata.Envelope
 			wantErr          bool
 			expected1stQuery string
 			expected2ndQuery string
 		}{
-			name:             "Get analyzed rates - Failed",
+			name:             "Get rates by date range - Failed",
 			dbHandler:        &dbHandler{database: gormDB},
+			args:             args{start: "2020-06-01", end: "2020-06-02"},
 			want:             nil,
 			wantErr:          true,
-			expected1stQuery: `SELECT \* FROM \"envelopes\" (.+) LIMIT 1`,
+			expected1stQuery: `SELECT \* FROM \"envelopes\" WHERE (.+)cube_time BETWEEN (.+) ORDER BY cube_time asc`,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.wantErr {
-				mockSQL.ExpectQuery(tt.expected1stQuery).WillReturnRows(sqlmock.NewRows(nil))
+				mockSQL.ExpectQuery(tt.expected1stQuery).WillReturnError(sql.ErrConnDone)
 			} else {
-				mockSQL.ExpectQuery(tt.expected1stQuery).WillReturnRows(sqlmock.NewRows([]string{"sender_name"}).
-					AddRow("Dummy Sender"))
-				mockSQL.ExpectQuery(tt.expected2ndQuery).
-					WillReturnRows(sqlmock.NewRows([]string{"currency", "min", "max", "avg"}).
-						AddRow("PHP", 50.555, 60.555, 55.555))
+				mockSQL.ExpectQuery(tt.expected1stQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "cube_time"}).
+					AddRow(1, "2020-06-01").
+					AddRow(2, "2020-06-02"))
+				mockSQL.ExpectQuery(tt.expected2ndQuery).WillReturnRows(sqlmock.NewRows([]string{"envelope_id", "currency", "rate"}).
+					AddRow(1, "PHP", 50.555).
+					AddRow(2, "PHP", 51.555))
 			}
 
-			got, err := tt.dbHandler.GetAnalyzedRates()
+			got, err := tt.dbHandler.GetRatesByDateRange(RateQuery{Source: "ecb"}, tt.args.start, tt.args.end, tt.args.currencies)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("dbHandler.GetAnalyzedRates() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("dbHandler.GetRatesByDateRange() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if err = mockSQL.ExpectationsWereMet(); err != nil {
 				t.Errorf("mockSQL.ExpectationsWereMet() error = %v", err)
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("dbHandler.GetAnalyzedRates() = %v, want %v", got, tt.want)
+				t.Errorf("dbHandler.GetRatesByDateRange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dbHandler_GetUserByUsername(t *testing.T) {
+	beforeEach()
+	defer afterEach()
+
+	type args struct {
+		username string
+	}
+	tests := []struct {
+		name          string
+		dbHandler     *dbHandler
+		args          args
+		want          *dbdata.User
+		wantErr       bool
+		expectedQuery string
+	}{
+		struct {
+			name          string
+			dbHandler     *dbHandler
+			args          args
+			want          *dbdata.User
+			wantErr       bool
+			expectedQuery string
+		}{
+			name:          "Get user by username - Success",
+			dbHandler:     &dbHandler{database: gormDB},
+			args:          args{username: "user123"},
+			want:          &dbdata.User{Username: "user123", PasswordHash: "hash", Enabled: true},
+			wantErr:       false,
+			expectedQuery: `SELECT \* FROM \"users\" WHERE (.+)\"users\"\.\"username\" = (.+) LIMIT 1`,
+		},
+		struct {
+			name          string
+			dbHandler     *dbHandler
+			args          args
+			want          *dbdata.User
+			wantErr       bool
+			expectedQuery string
+		}{
+			name:          "Get user by username - Failed",
+			dbHandler:     &dbHandler{database: gormDB},
+			args:          args{username: "unknown"},
+			want:          nil,
+			wantErr:       true,
+			expectedQuery: `SELECT \* FROM \"users\" WHERE (.+)\"users\"\.\"username\" = (.+) LIMIT 1`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr {
+				mockSQL.ExpectQuery(tt.expectedQuery).WillReturnRows(sqlmock.NewRows(nil))
+			} else {
+				mockSQL.ExpectQuery(tt.expectedQuery).WillReturnRows(sqlmock.NewRows([]string{"username", "password_hash", "enabled"}).
+					AddRow(tt.args.username, "hash", true))
+			}
+
+			got, err := tt.dbHandler.GetUserByUsername(tt.args.username)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("dbHandler.GetUserByUsername() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err = mockSQL.ExpectationsWereMet(); err != nil {
+				t.Errorf("mockSQL.ExpectationsWereMet() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dbHandler.GetUserByUsername() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dbHandler_ConsumeRefreshToken(t *testing.T) {
+	beforeEach()
+	defer afterEach()
+
+	tests := []struct {
+		name         string
+		dbHandler    *dbHandler
+		rowsAffected int64
+		wantErr      error
+	}{
+		struct {
+			name         string
+			dbHandler    *dbHandler
+			rowsAffected int64
+			wantErr      error
+		}{
+			name:         "Consume refresh token - Success",
+			dbHandler:    &dbHandler{database: gormDB},
+			rowsAffected: 1,
+			wantErr:      nil,
+		},
+		struct {
+			name         string
+			dbHandler    *dbHandler
+			rowsAffected int64
+			wantErr      error
+		}{
+			name:         "Consume refresh token - Already used",
+			dbHandler:    &dbHandler{database: gormDB},
+			rowsAffected: 0,
+			wantErr:      ErrRefreshTokenUnusable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSQL.ExpectBegin()
+			mockSQL.ExpectExec(`UPDATE \"refresh_tokens\" SET (.+)\"used_at\" = (.+) WHERE (.+)used_at IS NULL AND revoked_at IS NULL AND expires_at > (.+)`).
+				WillReturnResult(sqlmock.NewResult(0, tt.rowsAffected))
+			mockSQL.ExpectCommit()
+			mockSQL.ExpectQuery(`SELECT \* FROM \"refresh_tokens\" WHERE (.+)\"refresh_tokens\"\.\"token_hash\" = (.+) LIMIT 1`).
+				WillReturnRows(sqlmock.NewRows([]string{"token_hash", "username"}).AddRow("hash", "user123"))
+
+			got, err := tt.dbHandler.ConsumeRefreshToken("hash", time.Now())
+			if err != tt.wantErr {
+				t.Errorf("dbHandler.ConsumeRefreshToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err = mockSQL.ExpectationsWereMet(); err != nil {
+				t.Errorf("mockSQL.ExpectationsWereMet() error = %v", err)
+			}
+			if got == nil || got.Username != "user123" {
+				t.Errorf("dbHandler.ConsumeRefreshToken() = %v, want token of user123", got)
+			}
+		})
+	}
+}
+
+func Test_dbHandler_IncrementQuotaUsage(t *testing.T) {
+	beforeEach()
+	defer afterEach()
+
+	mockSQL.ExpectQuery(`INSERT INTO quota_usages (.+) ON CONFLICT \(subject, day\) DO UPDATE SET count = quota_usages\.count \+ EXCLUDED\.count(.+) RETURNING count`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "user123", "2020-06-01", 5).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(12))
+
+	got, err := (&dbHandler{database: gormDB}).IncrementQuotaUsage("user123", time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), 5)
+	if err != nil {
+		t.Errorf("dbHandler.IncrementQuotaUsage() error = %v", err)
+	}
+	if got != 12 {
+		t.Errorf("dbHandler.IncrementQuotaUsage() = %v, want 12", got)
+	}
+	if err = mockSQL.ExpectationsWereMet(); err != nil {
+		t.Errorf("mockSQL.ExpectationsWereMet() error = %v", err)
+	}
+}
+
+func Test_dbHandler_QuarantineDay(t *testing.T) {
+	beforeEach()
+	defer afterEach()
+
+	mockSQL.ExpectExec(`INSERT INTO quarantined_days (.+) ON CONFLICT DO NOTHING`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 3, "ecb", "European Central Bank", "2020-06-02", `[{"currency":"USD","rate":-1}]`, "invalid rate -1 of USD").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := (&dbHandler{database: gormDB}).QuarantineDay(&dbdata.QuarantinedDay{IngestionRunID: 3, Source: "ecb", SenderName: "European Central Bank",
+		CubeTime: "2020-06-02", Rates: `[{"currency":"USD","rate":-1}]`, Reasons: "invalid rate -1 of USD"})
+	if err != nil {
+		t.Errorf("dbHandler.QuarantineDay() error = %v, want an already quarantined day skipped", err)
+	}
+	if err = mockSQL.ExpectationsWereMet(); err != nil {
+		t.Errorf("mockSQL.ExpectationsWereMet() error = %v", err)
+	}
+}
+
+func Test_dbHandler_BatchUpsert(t *testing.T) {
+	beforeEach()
+	defer afterEach()
+
+	tests := []struct {
+		name        string
+		dbHandler   *dbHandler
+		envelope    dbdata.Envelope
+		expectation func()
+		want        *UpsertSummary
+		wantErr     bool
+	}{
+		struct {
+			name        string
+			dbHandler   *dbHandler
+			envelope    dbdata.Envelope
+			expectation func()
+			want        *UpsertSummary
+			wantErr     bool
+		}{
+			name:      "Batch upsert - New day",
+			dbHandler: &dbHandler{database: gormDB},
+			envelope: dbdata.Envelope{Source: "ecb", CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+				dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1136")},
+			}},
+			expectation: func() {
+				mockSQL.ExpectBegin()
+				mockSQL.ExpectQuery(`SELECT \* FROM \"envelopes\" WHERE (.+)\"envelopes\"\.\"source\" = (.+)\"envelopes\"\.\"cube_time\" = (.+) LIMIT 1`).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mockSQL.ExpectQuery(`INSERT INTO \"envelopes\" (.+) RETURNING`).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mockSQL.ExpectQuery(`INSERT INTO \"cubes\" (.+) RETURNING`).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mockSQL.ExpectCommit()
+			},
+			want: &UpsertSummary{DaysCreated: 1, CubesCreated: 1},
+		},
+		struct {
+			name        string
+			dbHandler   *dbHandler
+			envelope    dbdata.Envelope
+			expectation func()
+			want        *UpsertSummary
+			wantErr     bool
+		}{
+			name:      "Batch upsert - Stored day is corrected and completed",
+			dbHandler: &dbHandler{database: gormDB},
+			envelope: dbdata.Envelope{Source: "ecb", CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+				dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1137")},
+				dbdata.Cube{Currency: "JPY", Rate: decimal.RequireFromString("120.270000001")},
+				dbdata.Cube{Currency: "GBP", Rate: decimal.RequireFromString("0.8972")},
+			}},
+			expectation: func() {
+				mockSQL.ExpectBegin()
+				mockSQL.ExpectQuery(`SELECT \* FROM \"envelopes\" WHERE (.+) LIMIT 1`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "source", "cube_time"}).AddRow(7, "ecb", "2020-06-01"))
+				mockSQL.ExpectQuery(`SELECT \* FROM \"cubes\" WHERE (.+)\"envelope_id\" IN (.+)superseded_at IS NULL`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "envelope_id", "currency", "rate"}).
+						AddRow(1, 7, "USD", 1.1136).AddRow(2, 7, "JPY", 120.27))
+				mockSQL.ExpectExec(`UPDATE \"cubes\" SET \"superseded_at\" = (.+) WHERE (.+)\"id\" = (.+)`).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mockSQL.ExpectQuery(`INSERT INTO \"cubes\" (.+) RETURNING`).
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 7, "USD", "1.1137", sqlmock.AnyArg(), nil).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+				mockSQL.ExpectQuery(`INSERT INTO \"rate_changes\" (.+) RETURNING`).
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "ecb", "2020-06-01", "USD", "1.1136", "1.1137").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mockSQL.ExpectQuery(`INSERT INTO \"cubes\" (.+) RETURNING`).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+				mockSQL.ExpectQuery(`INSERT INTO \"rate_changes\" (.+) RETURNING`).
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "ecb", "2020-06-01", "GBP", nil, "0.8972").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				mockSQL.ExpectCommit()
+			},
+			want: &UpsertSummary{DaysUpdated: 1, CubesCreated: 1, CubesUpdated: 1},
+		},
+		struct {
+			name        string
+			dbHandler   *dbHandler
+			envelope    dbdata.Envelope
+			expectation func()
+			want        *UpsertSummary
+			wantErr     bool
+		}{
+			name:      "Batch upsert - Day stored concurrently is retried as an update",
+			dbHandler: &dbHandler{database: gormDB},
+			envelope: dbdata.Envelope{Source: "ecb", CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+				dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1136")},
+			}},
+			expectation: func() {
+				mockSQL.ExpectBegin()
+				mockSQL.ExpectQuery(`SELECT \* FROM \"envelopes\" WHERE (.+) LIMIT 1`).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mockSQL.ExpectQuery(`INSERT INTO \"envelopes\" (.+) RETURNING`).
+					WillReturnError(&pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint"})
+				mockSQL.ExpectRollback()
+				mockSQL.ExpectBegin()
+				mockSQL.ExpectQuery(`SELECT \* FROM \"envelopes\" WHERE (.+) LIMIT 1`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "source", "cube_time"}).AddRow(7, "ecb", "2020-06-01"))
+				mockSQL.ExpectQuery(`SELECT \* FROM \"cubes\" WHERE (.+)superseded_at IS NULL`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "envelope_id", "currency", "rate"}).AddRow(1, 7, "USD", 1.1136))
+				mockSQL.ExpectCommit()
+			},
+			want: &UpsertSummary{},
+		},
+		struct {
+			name        string
+			dbHandler   *dbHandler
+			envelope    dbdata.Envelope
+			expectation func()
+			want        *UpsertSummary
+			wantErr     bool
+		}{
+			name:      "Batch upsert - Failure rolls the day back",
+			dbHandler: &dbHandler{database: gormDB},
+			envelope:  dbdata.Envelope{Source: "ecb", CubeTime: "2020-06-01"},
+			expectation: func() {
+				mockSQL.ExpectBegin()
+				mockSQL.ExpectQuery(`SELECT \* FROM \"envelopes\" WHERE (.+) LIMIT 1`).
+					WillReturnError(errors.New("relation does not exist"))
+				mockSQL.ExpectRollback()
+			},
+			want:    &UpsertSummary{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.expectation()
+
+			got, err := tt.dbHandler.BatchUpsert(&[]dbdata.Envelope{tt.envelope})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("dbHandler.BatchUpsert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dbHandler.BatchUpsert() = %v, want %v", got, tt.want)
+			}
+			if err = mockSQL.ExpectationsWereMet(); err != nil {
+				t.Errorf("mockSQL.ExpectationsWereMet() error = %v", err)
+			}
+		})
+	}
+}
+
+func Test_dbHandler_ListIngestionRuns(t *testing.T) {
+	beforeEach()
+	defer afterEach()
+
+	tests := []struct {
+		name          string
+		source        string
+		expectedQuery string
+	}{
+		struct {
+			name          string
+			source        string
+			expectedQuery string
+		}{
+			name:          "List ingestion runs - Every source",
+			source:        "",
+			expectedQuery: `SELECT \* FROM \"ingestion_runs\" WHERE \"ingestion_runs\"\."deleted_at\" IS NULL ORDER BY started_at desc LIMIT 20`,
+		},
+		struct {
+			name          string
+			source        string
+			expectedQuery string
+		}{
+			name:          "List ingestion runs - One source",
+			source:        "ecb",
+			expectedQuery: `SELECT \* FROM \"ingestion_runs\" WHERE (.+)\"ingestion_runs\"\.\"source\" = (.+) ORDER BY started_at desc LIMIT 20`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSQL.ExpectQuery(tt.expectedQuery).
+				WillReturnRows(sqlmock.NewRows([]string{"source", "status"}).AddRow("ecb", "succeeded"))
+
+			got, err := (&dbHandler{database: gormDB}).ListIngestionRuns(tt.source, 20)
+			if err != nil {
+				t.Errorf("dbHandler.ListIngestionRuns() error = %v", err)
+				return
+			}
+			if err = mockSQL.ExpectationsWereMet(); err != nil {
+				t.Errorf("mockSQL.ExpectationsWereMet() error = %v", err)
+			}
+			if len(got) != 1 || got[0].Status != "succeeded" {
+				t.Errorf("dbHandler.ListIngestionRuns() = %v, want one succeeded run", got)
+			}
+		})
+	}
+}
+
+func Test_dbHandler_CountIngestionRuns(t *testing.T) {
+	beforeEach()
+	defer afterEach()
+
+	mockSQL.ExpectQuery(`SELECT count\(\*\) FROM \"ingestion_runs\" WHERE (.+)\"ingestion_runs\"\.\"source\" = (.+)\"ingestion_runs\"\.\"trigger\" = (.+)\"ingestion_runs\"\.\"status\" = (.+)`).
+		WithArgs("ecb", "import", "succeeded").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	got, err := (&dbHandler{database: gormDB}).CountIngestionRuns(&dbdata.IngestionRun{Source: "ecb", Trigger: "import", Status: "succeeded"})
+	if err != nil || got != 1 {
+		t.Errorf("dbHandler.CountIngestionRuns() = %v, %v, want 1", got, err)
+	}
+	if err = mockSQL.ExpectationsWereMet(); err != nil {
+		t.Errorf("mockSQL.ExpectationsWereMet() error = %v", err)
+	}
+}
+
+func Test_dbHandler_ListQuarantinedDays(t *testing.T) {
+	beforeEach()
+	defer afterEach()
+
+	tests := []struct {
+		name          string
+		source        string
+		expectedQuery string
+	}{
+		struct {
+			name          string
+			source        string
+			expectedQuery string
+		}{
+			name:          "List quarantined days - Every source",
+			source:        "",
+			expectedQuery: `SELECT \* FROM \"quarantined_days\" WHERE \"quarantined_days\"\."deleted_at\" IS NULL ORDER BY created_at desc LIMIT 20`,
+		},
+		struct {
+			name          string
+			source        string
+			expectedQuery string
+		}{
+			name:          "List quarantined days - One source",
+			source:        "ecb",
+			expectedQuery: `SELECT \* FROM \"quarantined_days\" WHERE (.+)\"quarantined_days\"\.\"source\" = (.+) ORDER BY created_at desc LIMIT 20`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSQL.ExpectQuery(tt.expectedQuery).
+				WillReturnRows(sqlmock.NewRows([]string{"source", "cube_time"}).AddRow("ecb", "2020-06-01"))
+
+			got, err := (&dbHandler{database: gormDB}).ListQuarantinedDays(tt.source, 20)
+			if err != nil {
+				t.Errorf("dbHandler.ListQuarantinedDays() error = %v", err)
+				return
+			}
+			if err = mockSQL.ExpectationsWereMet(); err != nil {
+				t.Errorf("mockSQL.ExpectationsWereMet() error = %v", err)
+			}
+			if len(got) != 1 || got[0].CubeTime != "2020-06-01" {
+				t.Errorf("dbHandler.ListQuarantinedDays() = %v, want one quarantined day", got)
+			}
+		})
+	}
+}
+
+func Test_dbHandler_ListCurrencies(t *testing.T) {
+	beforeEach()
+	defer afterEach()
+
+	mockSQL.ExpectQuery(`SELECT cubes\.currency, MIN\(envelopes\.cube_time\) AS first_date, MAX\(envelopes\.cube_time\) AS last_date FROM \"cubes\" JOIN envelopes (.+) GROUP BY cubes\.currency ORDER BY \"cubes\"\.\"currency\"`).
+		WithArgs("ecb").
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "first_date", "last_date"}).
+			AddRow("HRK", "2020-06-01", "2022-12-30").
+			AddRow("USD", "2020-06-01", "2023-01-02"))
+
+	got, err := (&dbHandler{database: gormDB}).ListCurrencies("ecb")
+	if err != nil {
+		t.Errorf("dbHandler.ListCurrencies() error = %v", err)
+		return
+	}
+	if err = mockSQL.ExpectationsWereMet(); err != nil {
+		t.Errorf("mockSQL.ExpectationsWereMet() error = %v", err)
+	}
+
+	want := []CurrencyRange{
+		CurrencyRange{Currency: "HRK", FirstDate: "2020-06-01", LastDate: "2022-12-30"},
+		CurrencyRange{Currency: "USD", FirstDate: "2020-06-01", LastDate: "2023-01-02"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dbHandler.ListCurrencies() = %v, want %v", got, want)
+	}
+}
+
+func Test_wrapError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantKind apperror.Kind
+		wantCode string
+	}{
+		struct {
+			name     string
+			err      error
+			wantKind apperror.Kind
+			wantCode string
+		}{
+			name:     "Record not found",
+			err:      gorm.ErrRecordNotFound,
+			wantKind: apperror.NotFound,
+			wantCode: "not_found",
+		},
+		struct {
+			name     string
+			err      error
+			wantKind apperror.Kind
+			wantCode string
+		}{
+			name:     "Connection refused",
+			err:      &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+			wantKind: apperror.Unavailable,
+			wantCode: "database_unavailable",
+		},
+		struct {
+			name     string
+			err      error
+			wantKind apperror.Kind
+			wantCode string
+		}{
+			name:     "Database shutting down",
+			err:      &pq.Error{Code: "57P01", Message: "terminating connection due to administrator command"},
+			wantKind: apperror.Unavailable,
+			wantCode: "database_unavailable",
+		},
+		struct {
+			name     string
+			err      error
+			wantKind apperror.Kind
+			wantCode string
+		}{
+			name:     "Query error",
+			err:      &pq.Error{Code: "42P01", Message: "relation does not exist"},
+			wantKind: apperror.Internal,
+			wantCode: "database_error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := apperror.As(wrapError(tt.err, ""))
+			if got.Kind != tt.wantKind || got.Code != tt.wantCode {
+				t.Errorf("wrapError() = %v %v, want %v %v", got.Kind, got.Code, tt.wantKind, tt.wantCode)
+			}
+			if !errors.Is(got, tt.err) {
+				t.Errorf("wrapError() lost the cause %v", tt.err)
 			}
 		})
 	}
@@ -0,0 +1,309 @@
+package decimal
+
+import (
+	"database/sql/driver"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RoundingMode chooses how Round resolves the digits it drops
+type RoundingMode int
+
+const (
+	// RoundHalfEven rounds ties to the even neighbour, the default
+	RoundHalfEven RoundingMode = iota
+	// RoundHalfUp rounds ties away from zero
+	RoundHalfUp
+	// RoundDown truncates toward zero
+	RoundDown
+)
+
+const (
+	// maxStringPlaces bounds the digits String prints of a value without a finite decimal expansion, e.g. 1/3
+	maxStringPlaces = 20
+	// maxExponent keeps parsed values such as 1e999999999 from allocating huge numbers
+	maxExponent = 1000
+	// sqrtPrecision is the binary precision Sqrt computes with before rounding
+	sqrtPrecision = 256
+)
+
+var (
+	decimalPattern = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)([eE]([+-]?\d+))?$`)
+	ten            = big.NewInt(10)
+)
+
+// Decimal is an exact rational number, immutable and comparable with == as it is always kept in lowest terms.
+// The zero value is 0.
+type Decimal struct {
+	fraction string
+}
+
+func New(value int64) Decimal {
+	return fromRat(new(big.Rat).SetInt64(value))
+}
+
+// NewFromString parses a decimal number with an optional exponent, e.g. "0.90088" or "1.5e-3"
+func NewFromString(value string) (Decimal, error) {
+	value = strings.TrimSpace(value)
+	match := decimalPattern.FindStringSubmatch(value)
+	if match == nil {
+		return Decimal{}, fmt.Errorf("Invalid decimal: %q", value)
+	}
+	if match[4] != "" {
+		if exponent, err := strconv.Atoi(match[4]); err != nil || exponent > maxExponent || exponent < -maxExponent {
+			return Decimal{}, fmt.Errorf("Decimal exponent out of range: %q", value)
+		}
+	}
+
+	r, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return Decimal{}, fmt.Errorf("Invalid decimal: %q", value)
+	}
+
+	return fromRat(r), nil
+}
+
+// RequireFromString is NewFromString for constants, it panics on invalid input
+func RequireFromString(value string) Decimal {
+	d, err := NewFromString(value)
+	if err != nil {
+		panic(err)
+	}
+
+	return d
+}
+
+// ParseRoundingMode accepts half-even, half-up and down
+func ParseRoundingMode(value string) (RoundingMode, error) {
+	switch strings.ToLower(value) {
+	case "half-even":
+		return RoundHalfEven, nil
+	case "half-up":
+		return RoundHalfUp, nil
+	case "down":
+		return RoundDown, nil
+	}
+
+	return RoundHalfEven, fmt.Errorf("Invalid rounding mode: %q", value)
+}
+
+func fromRat(r *big.Rat) Decimal {
+	if r.Sign() == 0 {
+		return Decimal{}
+	}
+
+	return Decimal{fraction: r.RatString()}
+}
+
+func (d Decimal) rat() *big.Rat {
+	r := new(big.Rat)
+	if d.fraction != "" {
+		r.SetString(d.fraction)
+	}
+
+	return r
+}
+
+func (d Decimal) Add(other Decimal) Decimal {
+	return fromRat(new(big.Rat).Add(d.rat(), other.rat()))
+}
+
+func (d Decimal) Sub(other Decimal) Decimal {
+	return fromRat(new(big.Rat).Sub(d.rat(), other.rat()))
+}
+
+func (d Decimal) Mul(other Decimal) Decimal {
+	return fromRat(new(big.Rat).Mul(d.rat(), other.rat()))
+}
+
+// Div is exact, it panics when other is zero
+func (d Decimal) Div(other Decimal) Decimal {
+	if other.IsZero() {
+		panic("decimal: division by zero")
+	}
+
+	return fromRat(new(big.Rat).Quo(d.rat(), other.rat()))
+}
+
+func (d Decimal) Neg() Decimal {
+	return fromRat(new(big.Rat).Neg(d.rat()))
+}
+
+func (d Decimal) Abs() Decimal {
+	return fromRat(new(big.Rat).Abs(d.rat()))
+}
+
+// Cmp returns -1, 0 or +1 as d is less than, equal to or greater than other
+func (d Decimal) Cmp(other Decimal) int {
+	return d.rat().Cmp(other.rat())
+}
+
+func (d Decimal) Sign() int {
+	return d.rat().Sign()
+}
+
+func (d Decimal) IsZero() bool {
+	return d.fraction == ""
+}
+
+// Float64 is the nearest float64, for callers that only need an approximation
+func (d Decimal) Float64() float64 {
+	f, _ := d.rat().Float64()
+	return f
+}
+
+// Round keeps places digits after the decimal point, negative places round to an integer
+func (d Decimal) Round(places int32, mode RoundingMode) Decimal {
+	if places < 0 {
+		places = 0
+	}
+
+	r := d.rat()
+	scale := new(big.Int).Exp(ten, big.NewInt(int64(places)), nil)
+	scaled := new(big.Int).Mul(r.Num(), scale)
+	quotient, remainder := new(big.Int).QuoRem(scaled, r.Denom(), new(big.Int))
+
+	if remainder.Sign() != 0 {
+		// Compare the dropped remainder with half the denominator
+		half := new(big.Int).Abs(remainder)
+		half.Lsh(half, 1)
+		tie := half.Cmp(r.Denom())
+
+		away := false
+		switch mode {
+		case RoundHalfUp:
+			away = tie >= 0
+		case RoundHalfEven:
+			away = tie > 0 || (tie == 0 && quotient.Bit(0) == 1)
+		}
+		if away {
+			quotient.Add(quotient, big.NewInt(int64(r.Sign())))
+		}
+	}
+
+	return fromRat(new(big.Rat).SetFrac(quotient, scale))
+}
+
+// Sqrt returns the square root rounded to places, it panics when d is negative
+func (d Decimal) Sqrt(places int32, mode RoundingMode) Decimal {
+	if d.Sign() < 0 {
+		panic("decimal: square root of a negative number")
+	}
+
+	f := new(big.Float).SetPrec(sqrtPrecision).SetRat(d.rat())
+	r, _ := f.Sqrt(f).Rat(nil)
+
+	return fromRat(r).Round(places, mode)
+}
+
+// String prints the exact value without exponent and trailing zeros,
+// a value without a finite decimal expansion is rounded half-even to 20 places
+func (d Decimal) String() string {
+	r := d.rat()
+	places, ok := decimalPlaces(r.Denom())
+	if !ok {
+		r = d.Round(maxStringPlaces, RoundHalfEven).rat()
+		places, _ = decimalPlaces(r.Denom())
+	}
+
+	value := r.FloatString(places)
+	if strings.Contains(value, ".") {
+		value = strings.TrimRight(strings.TrimRight(value, "0"), ".")
+	}
+
+	return value
+}
+
+// decimalPlaces is the number of digits after the decimal point of a fraction with denominator denom,
+// false when denom has other prime factors than 2 and 5
+func decimalPlaces(denom *big.Int) (int, bool) {
+	rest := new(big.Int).Set(denom)
+	twos, fives := 0, 0
+	for rest.Bit(0) == 0 {
+		rest.Rsh(rest, 1)
+		twos++
+	}
+
+	five, remainder := big.NewInt(5), new(big.Int)
+	for {
+		quotient, _ := new(big.Int).QuoRem(rest, five, remainder)
+		if remainder.Sign() != 0 {
+			break
+		}
+		rest = quotient
+		fives++
+	}
+
+	if twos > fives {
+		return twos, rest.IsInt64() && rest.Int64() == 1
+	}
+
+	return fives, rest.IsInt64() && rest.Int64() == 1
+}
+
+// MarshalJSON writes the exact value as a JSON number
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a string holding one
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	value := string(data)
+	if value == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+
+	parsed, err := NewFromString(value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+
+	return nil
+}
+
+func (d *Decimal) UnmarshalXMLAttr(attr xml.Attr) error {
+	parsed, err := NewFromString(attr.Value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+
+	return nil
+}
+
+// Scan reads numeric columns, which drivers return as text, integers or floats
+func (d *Decimal) Scan(value interface{}) error {
+	var err error
+	switch v := value.(type) {
+	case nil:
+		*d = Decimal{}
+	case []byte:
+		*d, err = NewFromString(string(v))
+	case string:
+		*d, err = NewFromString(v)
+	case int64:
+		*d = New(v)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("Invalid decimal: %v", v)
+		}
+		*d, err = NewFromString(strconv.FormatFloat(v, 'g', -1, 64))
+	default:
+		return fmt.Errorf("Unable to scan %T into a decimal", value)
+	}
+
+	return err
+}
+
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
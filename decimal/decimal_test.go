@@ -0,0 +1,289 @@
+package decimal
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+)
+
+func TestNewFromString(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		struct {
+			name    string
+			value   string
+			want    string
+			wantErr bool
+		}{
+			name:  "Rate",
+			value: "0.90088",
+			want:  "0.90088",
+		},
+		struct {
+			name    string
+			value   string
+			want    string
+			wantErr bool
+		}{
+			name:  "Trailing zeros and sign",
+			value: " -120.2700 ",
+			want:  "-120.27",
+		},
+		struct {
+			name    string
+			value   string
+			want    string
+			wantErr bool
+		}{
+			name:  "Exponent",
+			value: "1.5e-3",
+			want:  "0.0015",
+		},
+		struct {
+			name    string
+			value   string
+			want    string
+			wantErr bool
+		}{
+			name:  "Zero",
+			value: "-0.000",
+			want:  "0",
+		},
+		struct {
+			name    string
+			value   string
+			want    string
+			wantErr bool
+		}{
+			name:    "Fraction",
+			value:   "1/3",
+			wantErr: true,
+		},
+		struct {
+			name    string
+			value   string
+			want    string
+			wantErr bool
+		}{
+			name:    "Not a number",
+			value:   "NaN",
+			wantErr: true,
+		},
+		struct {
+			name    string
+			value   string
+			want    string
+			wantErr bool
+		}{
+			name:    "Exponent out of range",
+			value:   "1e1001",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewFromString(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewFromString() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("NewFromString() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecimal_arithmetic(t *testing.T) {
+	a := RequireFromString("0.1")
+	b := RequireFromString("0.2")
+
+	if got := a.Add(b); got != RequireFromString("0.3") {
+		t.Errorf("Decimal.Add() = %v, want 0.3", got)
+	}
+	if got := a.Sub(b); got.String() != "-0.1" || got.Sign() != -1 || got.Abs() != a {
+		t.Errorf("Decimal.Sub() = %v, want -0.1", got)
+	}
+	if got := a.Mul(b); got.String() != "0.02" {
+		t.Errorf("Decimal.Mul() = %v, want 0.02", got)
+	}
+	if got := New(1).Div(New(3)); got.String() != "0.33333333333333333333" || got.Mul(New(3)) != New(1) {
+		t.Errorf("Decimal.Div() = %v, want an exact third", got)
+	}
+	if got := New(1).Div(RequireFromString("0.90088")); got.Mul(RequireFromString("0.90088")) != New(1) {
+		t.Errorf("Decimal.Div() = %v, not exact", got)
+	}
+	if (Decimal{}) != New(0) || !New(0).IsZero() || a.Cmp(b) != -1 {
+		t.Errorf("Decimal zero value or Cmp() mismatch")
+	}
+}
+
+func TestDecimal_Round(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		places int32
+		mode   RoundingMode
+		want   string
+	}{
+		struct {
+			name   string
+			value  string
+			places int32
+			mode   RoundingMode
+			want   string
+		}{
+			name:   "Half even tie to even",
+			value:  "2.345",
+			places: 2,
+			mode:   RoundHalfEven,
+			want:   "2.34",
+		},
+		struct {
+			name   string
+			value  string
+			places int32
+			mode   RoundingMode
+			want   string
+		}{
+			name:   "Half even above the tie",
+			value:  "2.3451",
+			places: 2,
+			mode:   RoundHalfEven,
+			want:   "2.35",
+		},
+		struct {
+			name   string
+			value  string
+			places int32
+			mode   RoundingMode
+			want   string
+		}{
+			name:   "Half even negative tie to even",
+			value:  "-2.355",
+			places: 2,
+			mode:   RoundHalfEven,
+			want:   "-2.36",
+		},
+		struct {
+			name   string
+			value  string
+			places int32
+			mode   RoundingMode
+			want   string
+		}{
+			name:   "Half up tie away from zero",
+			value:  "-2.345",
+			places: 2,
+			mode:   RoundHalfUp,
+			want:   "-2.35",
+		},
+		struct {
+			name   string
+			value  string
+			places int32
+			mode   RoundingMode
+			want   string
+		}{
+			name:   "Down truncates toward zero",
+			value:  "-2.349",
+			places: 2,
+			mode:   RoundDown,
+			want:   "-2.34",
+		},
+		struct {
+			name   string
+			value  string
+			places int32
+			mode   RoundingMode
+			want   string
+		}{
+			name:   "Fewer digits than places",
+			value:  "0.90088",
+			places: 8,
+			mode:   RoundHalfEven,
+			want:   "0.90088",
+		},
+		struct {
+			name   string
+			value  string
+			places int32
+			mode   RoundingMode
+			want   string
+		}{
+			name:   "Integer",
+			value:  "2.5",
+			places: 0,
+			mode:   RoundHalfEven,
+			want:   "2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RequireFromString(tt.value).Round(tt.places, tt.mode); got.String() != tt.want {
+				t.Errorf("Decimal.Round() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecimal_Sqrt(t *testing.T) {
+	if got := New(2).Sqrt(10, RoundHalfEven); got.String() != "1.4142135624" {
+		t.Errorf("Decimal.Sqrt() = %v, want 1.4142135624", got)
+	}
+	if got := RequireFromString("0.0004").Sqrt(10, RoundHalfEven); got.String() != "0.02" {
+		t.Errorf("Decimal.Sqrt() = %v, want 0.02", got)
+	}
+}
+
+func TestParseRoundingMode(t *testing.T) {
+	for value, want := range map[string]RoundingMode{"half-even": RoundHalfEven, "HALF-UP": RoundHalfUp, "down": RoundDown} {
+		if got, err := ParseRoundingMode(value); err != nil || got != want {
+			t.Errorf("ParseRoundingMode(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	if _, err := ParseRoundingMode("ceiling"); err == nil {
+		t.Errorf("ParseRoundingMode() accepted ceiling")
+	}
+}
+
+func TestDecimal_encoding(t *testing.T) {
+	data, err := json.Marshal(map[string]Decimal{"rate": RequireFromString("0.90088")})
+	if err != nil || string(data) != `{"rate":0.90088}` {
+		t.Errorf("json.Marshal() = %s, %v", data, err)
+	}
+
+	rates := map[string]Decimal{}
+	if err := json.Unmarshal([]byte(`{"USD": 1.1136, "JPY": "120.27"}`), &rates); err != nil ||
+		rates["USD"] != RequireFromString("1.1136") || rates["JPY"] != RequireFromString("120.27") {
+		t.Errorf("json.Unmarshal() = %v, %v", rates, err)
+	}
+
+	cube := struct {
+		Rate Decimal `xml:"rate,attr"`
+	}{}
+	if err := xml.Unmarshal([]byte(`<Cube rate="0.90088"/>`), &cube); err != nil || cube.Rate.String() != "0.90088" {
+		t.Errorf("xml.Unmarshal() = %v, %v", cube.Rate, err)
+	}
+	if err := xml.Unmarshal([]byte(`<Cube rate="n/a"/>`), &cube); err == nil {
+		t.Errorf("xml.Unmarshal() accepted an invalid rate")
+	}
+}
+
+func TestDecimal_Scan(t *testing.T) {
+	for _, value := range []interface{}{[]byte("1.11360000"), "1.1136", 1.1136} {
+		d := Decimal{}
+		if err := d.Scan(value); err != nil || d != RequireFromString("1.1136") {
+			t.Errorf("Decimal.Scan(%v) = %v, %v", value, d, err)
+		}
+	}
+
+	value, err := RequireFromString("1.1136").Value()
+	if err != nil || value != "1.1136" {
+		t.Errorf("Decimal.Value() = %v, %v", value, err)
+	}
+}
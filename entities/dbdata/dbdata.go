@@ -1,34 +1,122 @@
 package dbdata
 
 import (
+	"time"
+
+	"github.com/emanpicar/currency-api/decimal"
 	"github.com/jinzhu/gorm"
 )
 
 type (
+	// Envelope is one day of rates, Source names the provider so days of different providers are kept apart.
+	// A source has at most one envelope per day.
 	Envelope struct {
 		gorm.Model
+		Source     string `gorm:"type:varchar(50);default:'ecb';unique_index:uix_envelopes_source_cube_time"`
 		SenderName string `gorm:"type:varchar(255)"`
-		CubeTime   string `gorm:"type:varchar(100)"`
+		CubeTime   string `gorm:"type:varchar(100);unique_index:uix_envelopes_source_cube_time"`
 		Cube       []Cube `gorm:"foreignkey:EnvelopeID"`
+		// Malformed lists the rates a decoder could not parse, such a day is never stored
+		Malformed []string `gorm:"-"`
 	}
 
+	// Cube is one revision of a rate, valid on the CubeTime of its envelope and known from RecordedAt.
+	// A correction sets SupersededAt on the previous revision instead of overwriting it.
 	Cube struct {
 		gorm.Model
-		EnvelopeID uint
-		Currency   string  `gorm:"type:varchar(10)"`
-		Rate       float64 `gorm:"type:decimal(20,8)"`
+		EnvelopeID   uint
+		Currency     string          `gorm:"type:varchar(10)"`
+		Rate         decimal.Decimal `gorm:"type:decimal(20,8)"`
+		RecordedAt   time.Time
+		SupersededAt *time.Time `gorm:"index"`
+	}
+
+	User struct {
+		gorm.Model
+		Username     string `gorm:"type:varchar(100);unique_index"`
+		PasswordHash string `gorm:"type:varchar(255)"`
+		Scopes       string `gorm:"type:varchar(255);default:'rates:read'"`
+		Enabled      bool
+	}
+
+	RefreshToken struct {
+		gorm.Model
+		TokenHash string `gorm:"type:varchar(64);unique_index"`
+		Username  string `gorm:"type:varchar(100);index"`
+		ExpiresAt time.Time
+		UsedAt    *time.Time
+		RevokedAt *time.Time
 	}
 
-	QuantitativeExchangeRate struct {
-		Base         string
-		RatesAnalyze []RatesAnalyze
+	// APIKey is identified by its public Prefix, only the SHA-256 hash of the full key is stored
+	APIKey struct {
+		gorm.Model
+		Name       string `gorm:"type:varchar(100)"`
+		Prefix     string `gorm:"type:varchar(32);unique_index"`
+		KeyHash    string `gorm:"type:varchar(64)"`
+		Username   string `gorm:"type:varchar(100);index"`
+		Scopes     string `gorm:"type:varchar(255)"`
+		LastUsedAt *time.Time
+		ExpiresAt  *time.Time
+		RevokedAt  *time.Time
 	}
 
-	RatesAnalyze struct {
-		Currency string
-		Min      float64
-		Max      float64
-		Avg      float64
+	// RevokedToken is a denylisted access token, kept until the token would have expired anyway
+	RevokedToken struct {
+		gorm.Model
+		JTI       string `gorm:"type:varchar(64);unique_index"`
+		ExpiresAt time.Time
+	}
+
+	// QuotaUsage counts the weighted requests of a subject on a UTC day, so daily quotas survive restarts
+	QuotaUsage struct {
+		gorm.Model
+		Subject string    `gorm:"type:varchar(100);unique_index:idx_quota_usages_subject_day"`
+		Day     time.Time `gorm:"type:date;unique_index:idx_quota_usages_subject_day"`
+		Count   int
+	}
+
+	// RateChange records a currency added to or a rate corrected on an already stored day, OldRate is nil when added
+	RateChange struct {
+		gorm.Model
+		Source   string           `gorm:"type:varchar(50);index:idx_rate_changes_source_cube_time"`
+		CubeTime string           `gorm:"type:varchar(100);index:idx_rate_changes_source_cube_time"`
+		Currency string           `gorm:"type:varchar(10)"`
+		OldRate  *decimal.Decimal `gorm:"type:decimal(20,8)"`
+		NewRate  decimal.Decimal  `gorm:"type:decimal(20,8)"`
+	}
+
+	// IngestionRun is one fetch of a provider, saved when it starts and again when it finishes
+	IngestionRun struct {
+		gorm.Model
+		Source        string    `gorm:"type:varchar(50);index"`
+		Trigger       string    `gorm:"type:varchar(20)"`
+		Location      string    `gorm:"type:varchar(1024)"`
+		StartedAt     time.Time `gorm:"index"`
+		FinishedAt    *time.Time
+		Status        string `gorm:"type:varchar(20)"`
+		UsedDemoData  bool
+		UsedSnapshot  bool
+		DaysSeen      int
+		DaysInserted  int
+		DaysUpdated   int
+		CubesInserted int
+		CubesUpdated  int
+		DaysRejected  int
+		Error         string `gorm:"type:text"`
+	}
+
+	// QuarantinedDay is a day that failed validation, kept for review instead of being published.
+	// Rates holds the cubes as received encoded as json, Reasons the failed rules separated by "; ".
+	// A source has at most one quarantined day per day and reasons, see uix_quarantined_days_day.
+	QuarantinedDay struct {
+		gorm.Model
+		IngestionRunID uint   `gorm:"index"`
+		Source         string `gorm:"type:varchar(50);index"`
+		SenderName     string `gorm:"type:varchar(255)"`
+		CubeTime       string `gorm:"type:varchar(100)"`
+		Rates          string `gorm:"type:text"`
+		Reasons        string `gorm:"type:text"`
 	}
 )
 
@@ -39,3 +127,35 @@ func (Envelope) TableName() string {
 func (Cube) TableName() string {
 	return "cubes"
 }
+
+func (User) TableName() string {
+	return "users"
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+func (QuotaUsage) TableName() string {
+	return "quota_usages"
+}
+
+func (RateChange) TableName() string {
+	return "rate_changes"
+}
+
+func (IngestionRun) TableName() string {
+	return "ingestion_runs"
+}
+
+func (QuarantinedDay) TableName() string {
+	return "quarantined_days"
+}
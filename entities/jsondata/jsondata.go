@@ -1,18 +1,139 @@
 package jsondata
 
+import (
+	"time"
+
+	"github.com/emanpicar/currency-api/decimal"
+)
+
 type (
 	QuantitativeExchangeRate struct {
 		Base         string                  `json:"base"`
+		StartDate    string                  `json:"start_date,omitempty"`
+		EndDate      string                  `json:"end_date,omitempty"`
 		RatesAnalyze map[string]RatesAnalyze `json:"rates_analyze"`
 	}
 
 	RatesAnalyze struct {
-		Min float64 `json:"min"`
-		Max float64 `json:"max"`
-		Avg float64 `json:"avg"`
+		Min           decimal.Decimal `json:"min"`
+		Max           decimal.Decimal `json:"max"`
+		Avg           decimal.Decimal `json:"avg"`
+		Median        decimal.Decimal `json:"median"`
+		StdDev        decimal.Decimal `json:"std_dev"`
+		First         decimal.Decimal `json:"first"`
+		Last          decimal.Decimal `json:"last"`
+		ChangePercent decimal.Decimal `json:"change_percent"`
+		Count         int             `json:"count"`
+	}
+
+	Conversion struct {
+		From   string          `json:"from"`
+		To     string          `json:"to"`
+		Amount decimal.Decimal `json:"amount"`
+		Rate   decimal.Decimal `json:"rate"`
+		Result decimal.Decimal `json:"result"`
+		Date   string          `json:"date"`
+	}
+
+	TimeSeries struct {
+		Base      string                                `json:"base"`
+		StartDate string                                `json:"start_date"`
+		EndDate   string                                `json:"end_date"`
+		Rates     map[string]map[string]decimal.Decimal `json:"rates"`
+	}
+
+	Token struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"`
+	}
+
+	APIKey struct {
+		Name       string     `json:"name"`
+		Prefix     string     `json:"prefix"`
+		Key        string     `json:"key,omitempty"`
+		Username   string     `json:"username"`
+		Scopes     []string   `json:"scopes"`
+		CreatedAt  time.Time  `json:"created_at"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+		ExpiresAt  *time.Time `json:"expires_at"`
+		RevokedAt  *time.Time `json:"revoked_at"`
+	}
+
+	JWKS struct {
+		Keys []JWK `json:"keys"`
+	}
+
+	JWK struct {
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+		N   string `json:"n,omitempty"`
+		E   string `json:"e,omitempty"`
+		Crv string `json:"crv,omitempty"`
+		X   string `json:"x,omitempty"`
+		Y   string `json:"y,omitempty"`
+	}
+
+	IngestionRun struct {
+		ID            uint       `json:"id"`
+		Source        string     `json:"source"`
+		Trigger       string     `json:"trigger"`
+		Location      string     `json:"location"`
+		Status        string     `json:"status"`
+		StartedAt     time.Time  `json:"started_at"`
+		FinishedAt    *time.Time `json:"finished_at"`
+		UsedDemoData  bool       `json:"used_demo_data"`
+		UsedSnapshot  bool       `json:"used_snapshot"`
+		DaysSeen      int        `json:"days_seen"`
+		DaysInserted  int        `json:"days_inserted"`
+		DaysUpdated   int        `json:"days_updated"`
+		RatesInserted int        `json:"rates_inserted"`
+		RatesUpdated  int        `json:"rates_updated"`
+		DaysRejected  int        `json:"days_rejected"`
+		Error         string     `json:"error,omitempty"`
+	}
+
+	QuarantinedDay struct {
+		ID             uint              `json:"id"`
+		IngestionRunID uint              `json:"ingestion_run_id"`
+		Source         string            `json:"source"`
+		SenderName     string            `json:"sender_name"`
+		Date           string            `json:"date"`
+		Rates          []QuarantinedRate `json:"rates"`
+		Reasons        []string          `json:"reasons"`
+		QuarantinedAt  time.Time         `json:"quarantined_at"`
+	}
+
+	QuarantinedRate struct {
+		Currency string          `json:"currency"`
+		Rate     decimal.Decimal `json:"rate"`
+	}
+
+	// Currency describes a stored currency, the ISO 4217 fields are left out for codes missing from the standard
+	Currency struct {
+		Code        string `json:"code"`
+		Name        string `json:"name,omitempty"`
+		NumericCode string `json:"numeric_code,omitempty"`
+		MinorUnits  *int   `json:"minor_units,omitempty"`
+		FirstDate   string `json:"first_date"`
+		LastDate    string `json:"last_date"`
+		Quoted      bool   `json:"quoted"`
+	}
+
+	Health struct {
+		Status      string     `json:"status"`
+		Provenance  string     `json:"provenance"`
+		LastRefresh *time.Time `json:"last_refresh"`
 	}
 
-	ResponseMessage struct {
-		Message string `json:"message"`
+	// Error is the body of every failed request, RequestID matches the X-Request-ID response header
+	Error struct {
+		Code      string                 `json:"code"`
+		Message   string                 `json:"message"`
+		RequestID string                 `json:"request_id"`
+		Details   map[string]interface{} `json:"details,omitempty"`
 	}
 )
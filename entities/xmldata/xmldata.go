@@ -1,6 +1,8 @@
 package xmldata
 
-import "encoding/xml"
+import (
+	"encoding/xml"
+)
 
 type (
 	Envelope struct {
@@ -19,11 +21,21 @@ type (
 				Text string `xml:",chardata"`
 				Time string `xml:"time,attr"`
 				Cube []struct {
-					Text     string  `xml:",chardata"`
-					Currency string  `xml:"currency,attr"`
-					Rate     float64 `xml:"rate,attr"`
+					Text     string `xml:",chardata"`
+					Currency string `xml:"currency,attr"`
+					Rate     string `xml:"rate,attr"`
 				} `xml:"Cube"`
 			} `xml:"Cube"`
 		} `xml:"Cube"`
 	}
+
+	// Day is a single time-stamped Cube, decoded on its own when streaming large history files.
+	// Rates are kept as text so a malformed one only rejects its day.
+	Day struct {
+		Time string `xml:"time,attr"`
+		Cube []struct {
+			Currency string `xml:"currency,attr"`
+			Rate     string `xml:"rate,attr"`
+		} `xml:"Cube"`
+	}
 )
@@ -0,0 +1,122 @@
+package envelope
+
+import (
+	"sort"
+	"time"
+
+	"github.com/emanpicar/currency-api/decimal"
+	"github.com/emanpicar/currency-api/entities/jsondata"
+	"github.com/emanpicar/currency-api/logger"
+	"github.com/emanpicar/currency-api/settings"
+)
+
+// GetAnalyzedRates computes statistics per currency over the stored days between start and end.
+// The range is limited to TIMESERIES_MAX_DAYS like time series, an empty end is today
+// and an empty start opens the longest allowed range ending at end.
+func (e *Envelope) GetAnalyzedRates(start, end string, symbols []string, base, asOf string) (*jsondata.QuantitativeExchangeRate, error) {
+	logger.Log.Infof("Request on getting analyzed rates from %q to %q as of %q started", start, end, asOf)
+
+	maxDays := settings.GetTimeSeriesMaxDays()
+	rangeStart, rangeEnd := start, end
+	if rangeEnd == "" {
+		rangeEnd = time.Now().UTC().Format(dateLayout)
+	}
+	if rangeStart == "" {
+		if endDate, err := time.Parse(dateLayout, rangeEnd); err == nil {
+			rangeStart = endDate.AddDate(0, 0, 1-maxDays).Format(dateLayout)
+		}
+	}
+
+	if err := e.validateDateRange(rangeStart, rangeEnd, maxDays); err != nil {
+		return nil, err
+	}
+
+	query, err := e.rateQuery(asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	base = e.normalizeBase(base)
+	days, err := e.getRebasedRange(query, rangeStart, rangeEnd, symbols, base)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonResult := &jsondata.QuantitativeExchangeRate{
+		Base:         base,
+		RatesAnalyze: make(map[string]jsondata.RatesAnalyze),
+	}
+
+	if len(days) == 0 {
+		logger.Log.Infoln("No rates data available for analysis")
+		return jsonResult, nil
+	}
+
+	jsonResult.StartDate = days[0].cubeTime
+	jsonResult.EndDate = days[len(days)-1].cubeTime
+
+	series := make(map[string][]decimal.Decimal)
+	for _, day := range days {
+		for currency, rate := range day.rates {
+			series[currency] = append(series[currency], rate)
+		}
+	}
+
+	for currency, values := range series {
+		jsonResult.RatesAnalyze[currency] = e.analyzeSeries(values)
+	}
+	logger.Log.Infof("Analyzed rates data available, base: %v, %v days", base, len(days))
+
+	return jsonResult, nil
+}
+
+// analyzeSeries expects values ordered by date and at least one observation.
+// Statistics are computed exactly and rounded once.
+func (e *Envelope) analyzeSeries(values []decimal.Decimal) jsondata.RatesAnalyze {
+	count := len(values)
+	first, last := values[0], values[count-1]
+
+	sorted := append([]decimal.Decimal{}, values...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Cmp(sorted[j]) < 0
+	})
+
+	sum := decimal.New(0)
+	for _, value := range values {
+		sum = sum.Add(value)
+	}
+	avg := sum.Div(decimal.New(int64(count)))
+
+	median := sorted[count/2]
+	if count%2 == 0 {
+		median = sorted[count/2-1].Add(sorted[count/2]).Div(decimal.New(2))
+	}
+
+	// Sample standard deviation, zero for a single observation
+	stdDev := decimal.New(0)
+	if count > 1 {
+		squares := decimal.New(0)
+		for _, value := range values {
+			squares = squares.Add(value.Sub(avg).Mul(value.Sub(avg)))
+		}
+		stdDev = squares.Div(decimal.New(int64(count-1))).Sqrt(e.precision, e.roundingMode)
+	}
+
+	// A first rate rounded to zero has no meaningful change
+	changePercent := decimal.New(0)
+	if !first.IsZero() {
+		changePercent = e.round(last.Sub(first).Div(first).Mul(decimal.New(100)))
+	}
+
+	return jsondata.RatesAnalyze{
+		Min:           sorted[0],
+		Max:           sorted[count-1],
+		Avg:           e.round(avg),
+		Median:        e.round(median),
+		StdDev:        stdDev,
+		First:         first,
+		Last:          last,
+		ChangePercent: changePercent,
+		Count:         count,
+	}
+}
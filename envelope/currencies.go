@@ -0,0 +1,63 @@
+package envelope
+
+import (
+	"sort"
+
+	"github.com/emanpicar/currency-api/entities/jsondata"
+	"github.com/emanpicar/currency-api/iso4217"
+	"github.com/emanpicar/currency-api/logger"
+)
+
+// ListCurrencies describes every currency stored for the served source ordered by code, EUR included as the base of all rates.
+// A currency is still quoted when it has a rate on the latest stored day.
+func (e *Envelope) ListCurrencies() ([]jsondata.Currency, error) {
+	logger.Log.Infoln("Request on listing currencies started")
+
+	ranges, err := e.dbManager.ListCurrencies(e.source)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []jsondata.Currency{}
+	if len(ranges) == 0 {
+		return result, nil
+	}
+
+	firstDate, lastDate := ranges[0].FirstDate, ranges[0].LastDate
+	for _, currencyRange := range ranges {
+		if currencyRange.FirstDate < firstDate {
+			firstDate = currencyRange.FirstDate
+		}
+		if currencyRange.LastDate > lastDate {
+			lastDate = currencyRange.LastDate
+		}
+	}
+
+	hasBase := false
+	for _, currencyRange := range ranges {
+		hasBase = hasBase || currencyRange.Currency == BaseCurrency
+		result = append(result, e.describeCurrency(currencyRange.Currency, currencyRange.FirstDate, currencyRange.LastDate, lastDate))
+	}
+	if !hasBase {
+		result = append(result, e.describeCurrency(BaseCurrency, firstDate, lastDate, lastDate))
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Code < result[j].Code
+	})
+	logger.Log.Infof("Currencies available, %v found", len(result))
+
+	return result, nil
+}
+
+func (e *Envelope) describeCurrency(code, firstDate, lastDate, latestDate string) jsondata.Currency {
+	currency := jsondata.Currency{Code: code, FirstDate: firstDate, LastDate: lastDate, Quoted: lastDate == latestDate}
+	if metadata, ok := iso4217.Lookup(code); ok {
+		minorUnits := metadata.MinorUnits
+		currency.Name = metadata.Name
+		currency.NumericCode = metadata.Numeric
+		currency.MinorUnits = &minorUnits
+	}
+
+	return currency
+}
@@ -0,0 +1,88 @@
+package envelope
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/emanpicar/currency-api/db"
+	"github.com/emanpicar/currency-api/entities/jsondata"
+)
+
+type currenciesDBHandler struct {
+	db.Manager
+	ranges []db.CurrencyRange
+	err    error
+}
+
+func (dbHandler *currenciesDBHandler) ListCurrencies(source string) ([]db.CurrencyRange, error) {
+	return dbHandler.ranges, dbHandler.err
+}
+
+func TestEnvelope_ListCurrencies(t *testing.T) {
+	two, zero := 2, 0
+
+	tests := []struct {
+		name    string
+		ranges  []db.CurrencyRange
+		err     error
+		want    []jsondata.Currency
+		wantErr bool
+	}{
+		struct {
+			name    string
+			ranges  []db.CurrencyRange
+			err     error
+			want    []jsondata.Currency
+			wantErr bool
+		}{
+			name: "Withdrawn and unknown currencies",
+			ranges: []db.CurrencyRange{
+				db.CurrencyRange{Currency: "HRK", FirstDate: "2020-06-01", LastDate: "2022-12-30"},
+				db.CurrencyRange{Currency: "JPY", FirstDate: "2020-06-02", LastDate: "2023-01-02"},
+				db.CurrencyRange{Currency: "HPH", FirstDate: "2020-06-01", LastDate: "2023-01-02"},
+			},
+			want: []jsondata.Currency{
+				jsondata.Currency{Code: "EUR", Name: "Euro", NumericCode: "978", MinorUnits: &two, FirstDate: "2020-06-01", LastDate: "2023-01-02", Quoted: true},
+				jsondata.Currency{Code: "HPH", FirstDate: "2020-06-01", LastDate: "2023-01-02", Quoted: true},
+				jsondata.Currency{Code: "HRK", Name: "Kuna", NumericCode: "191", MinorUnits: &two, FirstDate: "2020-06-01", LastDate: "2022-12-30", Quoted: false},
+				jsondata.Currency{Code: "JPY", Name: "Yen", NumericCode: "392", MinorUnits: &zero, FirstDate: "2020-06-02", LastDate: "2023-01-02", Quoted: true},
+			},
+		},
+		struct {
+			name    string
+			ranges  []db.CurrencyRange
+			err     error
+			want    []jsondata.Currency
+			wantErr bool
+		}{
+			name:   "Nothing stored",
+			ranges: []db.CurrencyRange{},
+			want:   []jsondata.Currency{},
+		},
+		struct {
+			name    string
+			ranges  []db.CurrencyRange
+			err     error
+			want    []jsondata.Currency
+			wantErr bool
+		}{
+			name:    "Database error",
+			err:     errors.New("Database error"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Envelope{dbManager: &currenciesDBHandler{ranges: tt.ranges, err: tt.err}, source: ECBSource}
+			got, err := e.ListCurrencies()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Envelope.ListCurrencies() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Envelope.ListCurrencies() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
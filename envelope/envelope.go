@@ -1,197 +1,613 @@
 package envelope
 
 import (
-	"encoding/xml"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"io"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/emanpicar/currency-api/apperror"
 	"github.com/emanpicar/currency-api/db"
+	"github.com/emanpicar/currency-api/decimal"
 	"github.com/emanpicar/currency-api/entities/dbdata"
 	"github.com/emanpicar/currency-api/entities/jsondata"
 	"github.com/emanpicar/currency-api/entities/xmldata"
+	"github.com/emanpicar/currency-api/fetcher"
 	"github.com/emanpicar/currency-api/logger"
 	"github.com/emanpicar/currency-api/settings"
 )
 
+const (
+	// BaseCurrency is the currency all ECB reference rates are quoted against
+	BaseCurrency = "EUR"
+	dateLayout   = "2006-01-02"
+
+	// FallbackNone, FallbackPrevious and FallbackNext choose how a date without rates is resolved
+	FallbackNone     = "none"
+	FallbackPrevious = "previous"
+	FallbackNext     = "next"
+)
+
+var (
+	errInvalidDate         = apperror.New(apperror.Invalid, "invalid_date", "Invalid date")
+	errInvalidDateRange    = apperror.New(apperror.Invalid, "invalid_date_range", "Invalid date range")
+	errUnsupportedCurrency = apperror.New(apperror.Invalid, "unsupported_currency", "Unsupported currency")
+	errInvalidFallback     = apperror.New(apperror.Invalid, "invalid_fallback", "Invalid fallback")
+	errInvalidAsOf         = apperror.New(apperror.Invalid, "invalid_as_of", "Invalid as_of")
+	errRefreshFailed       = apperror.New(apperror.Unavailable, "refresh_failed", "Refresh failed")
+	errRefreshInProgress   = apperror.New(apperror.Conflict, "refresh_in_progress", "A refresh or upload is already running")
+)
+
 type (
 	Manager interface {
 		UpsertInitialData()
-		GetLatestRates() (string, error)
-		GetRatesByDate(cubeTime string) (string, error)
-		GetAnalyzedRates() (*jsondata.QuantitativeExchangeRate, error)
+		RefreshRates() error
+		RefreshNow() ([]jsondata.IngestionRun, error)
+		UploadRates(reader io.Reader, format, source string) (*jsondata.IngestionRun, error)
+		ImportHistory(source string) (int, error)
+		ListIngestionRuns(source string, limit int) ([]jsondata.IngestionRun, error)
+		ListQuarantinedDays(source string, limit int) ([]jsondata.QuarantinedDay, error)
+		ListCurrencies() ([]jsondata.Currency, error)
+		GetLastRefresh() time.Time
+		GetProvenance() string
+		GetLatestRates(base, asOf string) (string, error)
+		GetRatesByDate(cubeTime, base, fallback, asOf string) (string, error)
+		GetAnalyzedRates(start, end string, symbols []string, base, asOf string) (*jsondata.QuantitativeExchangeRate, error)
+		ConvertAmount(from, to string, amount decimal.Decimal, cubeTime, asOf string) (*jsondata.Conversion, error)
+		GetTimeSeries(start, end string, symbols []string, base, asOf string) (*jsondata.TimeSeries, error)
+	}
+
+	dailyRates struct {
+		cubeTime string
+		rates    map[string]decimal.Decimal
 	}
 
 	Envelope struct {
-		dbManager db.Manager
+		dbManager    db.Manager
+		fetchManager fetcher.Manager
+		providers    []Provider
+		source       string
+		snapshotDir  string
+		demoDataPath string
+		lastRefresh  time.Time
+		provenance   string
+		mutex        sync.RWMutex
+		// ingestMutex runs one ingestion at a time, so refreshes, imports and uploads never store the same day concurrently
+		ingestMutex sync.Mutex
+		// refreshing counts the ingestions running or waiting for ingestMutex, admin requests are refused while it is not 0
+		refreshing int32
+		// precision and roundingMode cut computed rates, conversion results and statistics
+		precision    int32
+		roundingMode decimal.RoundingMode
 	}
 )
 
-func NewManager(dbManager db.Manager) Manager {
-	return &Envelope{dbManager}
+func NewManager(dbManager db.Manager, fetchManager fetcher.Manager) Manager {
+	precision, roundingMode := rateRounding()
+
+	return &Envelope{
+		dbManager:    dbManager,
+		fetchManager: fetchManager,
+		providers:    newProviders(fetchManager, settings.GetSnapshotDir()),
+		source:       settings.GetRatesSource(),
+		snapshotDir:  settings.GetSnapshotDir(),
+		demoDataPath: settings.GetXMLDataFilePath(),
+		precision:    precision,
+		roundingMode: roundingMode,
+	}
 }
 
+// UpsertInitialData fetches every provider once, the last ECB snapshot or else the demo data is stored
+// when the ECB download fails
 func (e *Envelope) UpsertInitialData() {
+	atomic.AddInt32(&e.refreshing, 1)
+	defer atomic.AddInt32(&e.refreshing, -1)
+
 	logger.Log.Infoln("Upserting initial data started")
-	env, err := e.downloadXMLData()
+
+	for _, provider := range e.providers {
+		if _, err := e.fetchProvider(provider, TriggerStartup); err != nil {
+			logger.Log.Warnf("Unable to download %v rates %v", provider.Name(), err)
+			if provider.Name() == ECBSource {
+				e.useFallbackData()
+			}
+		}
+	}
+
+	logger.Log.Infoln("Upserting initial data completed")
+}
+
+// RefreshRates re-fetches every provider without falling back to demo data, one failing provider does not stop the others.
+// It waits for a running upload or manual refresh instead of skipping the scheduled run.
+func (e *Envelope) RefreshRates() error {
+	atomic.AddInt32(&e.refreshing, 1)
+	defer atomic.AddInt32(&e.refreshing, -1)
+
+	_, err := e.refreshProviders(TriggerRefresh)
+
+	return err
+}
+
+// RefreshNow refreshes every provider on request of an operator and returns the recorded runs,
+// also listed in the details of the error when a provider failed. It is refused while another ingestion runs.
+func (e *Envelope) RefreshNow() ([]jsondata.IngestionRun, error) {
+	var runs []dbdata.IngestionRun
+	var err error
+	if guardErr := e.singleFlight(func() error {
+		runs, err = e.refreshProviders(TriggerManual)
+		return nil
+	}); guardErr != nil {
+		return nil, guardErr
+	}
+
+	result := []jsondata.IngestionRun{}
+	for _, run := range runs {
+		result = append(result, toJSONIngestionRun(run))
+	}
+
 	if err != nil {
-		logger.Log.Warnf("Unable to download xml data %v", err)
-		env = e.useDemoData()
+		return nil, errRefreshFailed.WithMessage(err.Error()).WithDetails(map[string]interface{}{"runs": result})
 	}
 
-	dbEnvelopeList := e.convertXMLtoDBEntities(env)
-	e.dbManager.BatchFirstOrCreate(&dbEnvelopeList)
+	return result, nil
+}
 
-	logger.Log.Infoln("Upserting initial data completed")
+// singleFlight runs an admin job unless another ingestion is running or waiting, then it fails with a conflict
+func (e *Envelope) singleFlight(job func() error) error {
+	if !atomic.CompareAndSwapInt32(&e.refreshing, 0, 1) {
+		return errRefreshInProgress
+	}
+	defer atomic.AddInt32(&e.refreshing, -1)
+
+	return job()
+}
+
+func (e *Envelope) refreshProviders(trigger string) ([]dbdata.IngestionRun, error) {
+	logger.Log.Infof("Refreshing rates started by %v", trigger)
+
+	runs := []dbdata.IngestionRun{}
+	failed := []string{}
+	for _, provider := range e.providers {
+		run, err := e.fetchProvider(provider, trigger)
+		runs = append(runs, *run)
+		if err != nil {
+			logger.Log.Errorf("Unable to refresh %v rates: %v", provider.Name(), err)
+			failed = append(failed, provider.Name())
+		}
+	}
+
+	if len(failed) > 0 {
+		return runs, fmt.Errorf("Unable to refresh rates of %v", strings.Join(failed, ", "))
+	}
+
+	logger.Log.Infoln("Refreshing rates completed")
+
+	return runs, nil
+}
+
+// fetchProvider stores the days of provider as they are fetched, the served source also updates the last refresh
+func (e *Envelope) fetchProvider(provider Provider, trigger string) (*dbdata.IngestionRun, error) {
+	logger.Log.Infof("Fetching %v rates started", provider.Name())
+
+	run := &dbdata.IngestionRun{Source: provider.Name(), Trigger: trigger, Location: provider.Location()}
+	if err := e.ingest(run, provider.Fetch); err != nil {
+		return run, err
+	}
+
+	if provider.Name() == e.source {
+		e.setLastRefresh(time.Now())
+		e.setProvenance(ProvenanceLive)
+	}
+
+	logger.Log.Infof("Fetching %v rates completed, %v days processed", provider.Name(), run.DaysSeen)
+
+	return run, nil
+}
+
+// GetLastRefresh returns the time of the last successful download, zero if rates only came from demo data
+func (e *Envelope) GetLastRefresh() time.Time {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	return e.lastRefresh
 }
 
-func (e *Envelope) GetLatestRates() (string, error) {
-	logger.Log.Infoln("Request on getting latest rates started")
+func (e *Envelope) setLastRefresh(refreshTime time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
 
-	envelope, err := e.dbManager.GetLatestRates()
+	e.lastRefresh = refreshTime
+}
+
+// GetProvenance tells where the served rates were last loaded from since startup, ProvenanceNone in degraded mode
+func (e *Envelope) GetProvenance() string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	if e.provenance == "" {
+		return ProvenanceNone
+	}
+
+	return e.provenance
+}
+
+func (e *Envelope) setProvenance(provenance string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.provenance = provenance
+}
+
+// GetLatestRates expresses the rates against base, EUR when base is empty
+func (e *Envelope) GetLatestRates(base, asOf string) (string, error) {
+	logger.Log.Infof("Request on getting latest rates with base %q as of %q started", base, asOf)
+
+	query, err := e.rateQuery(asOf)
+	if err != nil {
+		return "", err
+	}
+
+	envelope, err := e.dbManager.GetLatestRates(query)
 	if err != nil {
 		return "", err
 	}
 
-	jsonResult := e.sortRatesThenToString(envelope)
+	jsonResult, err := e.rebaseThenToString(envelope, base, "")
+	if err != nil {
+		return "", err
+	}
 	logger.Log.Infof("Latest rates data available, sender name: %v", envelope.SenderName)
 
 	return jsonResult, nil
 }
 
-func (e *Envelope) GetRatesByDate(cubeTime string) (string, error) {
-	logger.Log.Infof("Request on getting rates by date: %v started", cubeTime)
+// GetRatesByDate expresses the rates against base, EUR when base is empty.
+// The response carries the requested date and the date the rates are effective on, which differ after a fallback.
+func (e *Envelope) GetRatesByDate(cubeTime, base, fallback, asOf string) (string, error) {
+	logger.Log.Infof("Request on getting rates by date: %v with base %q and fallback %q as of %q started", cubeTime, base, fallback, asOf)
 
-	envelope, err := e.dbManager.GetRatesByDate(cubeTime)
+	query, err := e.rateQuery(asOf)
 	if err != nil {
 		return "", err
 	}
 
-	jsonResult := e.sortRatesThenToString(envelope)
-	logger.Log.Infof("%v - rates data available, sender name: %v", cubeTime, envelope.SenderName)
+	envelope, err := e.getRatesWithFallback(query, cubeTime, fallback)
+	if err != nil {
+		return "", err
+	}
+
+	jsonResult, err := e.rebaseThenToString(envelope, base, cubeTime)
+	if err != nil {
+		return "", err
+	}
+	logger.Log.Infof("%v - rates data available on %v, sender name: %v", cubeTime, envelope.CubeTime, envelope.SenderName)
 
 	return jsonResult, nil
 }
 
-func (e *Envelope) GetAnalyzedRates() (*jsondata.QuantitativeExchangeRate, error) {
-	logger.Log.Infoln("Request on getting analyzed rates started")
+// getRatesWithFallback resolves a day without rates, such as a weekend or TARGET holiday, to the closest stored day
+// in the fallback direction, at most settings.GetFallbackMaxDays() away
+func (e *Envelope) getRatesWithFallback(query db.RateQuery, cubeTime, fallback string) (*dbdata.Envelope, error) {
+	date, err := parseDate(cubeTime)
+	if err != nil {
+		return nil, err
+	}
+
+	maxDays := settings.GetFallbackMaxDays()
+	switch strings.ToLower(fallback) {
+	case "", FallbackNone:
+		return e.dbManager.GetRatesByDate(query, cubeTime)
+	case FallbackPrevious:
+		return e.dbManager.GetRatesOnOrBefore(query, cubeTime, date.AddDate(0, 0, -maxDays).Format(dateLayout))
+	case FallbackNext:
+		return e.dbManager.GetRatesOnOrAfter(query, cubeTime, date.AddDate(0, 0, maxDays).Format(dateLayout))
+	}
+
+	return nil, errInvalidFallback.WithMessage(fmt.Sprintf("Invalid fallback: %q", fallback)).
+		WithDetails(map[string]interface{}{"allowed": []string{FallbackPrevious, FallbackNext, FallbackNone}})
+}
 
-	analyzedResult, err := e.dbManager.GetAnalyzedRates()
+// parseDate reads a YYYY-MM-DD date parameter
+func parseDate(cubeTime string) (time.Time, error) {
+	date, err := time.Parse(dateLayout, cubeTime)
+	if err != nil {
+		return date, errInvalidDate.WithMessage(fmt.Sprintf("Invalid date: %q", cubeTime)).
+			WithDetails(map[string]interface{}{"date": cubeTime, "format": "YYYY-MM-DD"})
+	}
+
+	return date, nil
+}
+
+// ConvertAmount triangulates through EUR, uses the latest rates when cubeTime is empty.
+// The result is computed from the exact rate and rounded once.
+func (e *Envelope) ConvertAmount(from, to string, amount decimal.Decimal, cubeTime, asOf string) (*jsondata.Conversion, error) {
+	logger.Log.Infof("Request on converting %v %v to %v on %q as of %q started", amount, from, to, cubeTime, asOf)
+
+	query, err := e.rateQuery(asOf)
 	if err != nil {
 		return nil, err
 	}
 
-	jsonResult := &jsondata.QuantitativeExchangeRate{
-		Base:         analyzedResult.Base,
-		RatesAnalyze: make(map[string]jsondata.RatesAnalyze),
+	var envelope *dbdata.Envelope
+	if cubeTime == "" {
+		envelope, err = e.dbManager.GetLatestRates(query)
+	} else if _, err = parseDate(cubeTime); err == nil {
+		envelope, err = e.dbManager.GetRatesByDate(query, cubeTime)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	for _, cube := range analyzedResult.RatesAnalyze {
-		jsonResult.RatesAnalyze[cube.Currency] = jsondata.RatesAnalyze{
-			Min: cube.Min,
-			Max: cube.Max,
-			Avg: cube.Avg,
-		}
+	rates := e.ratesToEUR(envelope)
+	fromRate, ok := rates[strings.ToUpper(from)]
+	if !ok || fromRate.Sign() <= 0 {
+		return nil, e.unsupportedCurrency("Unsupported currency", from, envelope.CubeTime)
 	}
-	logger.Log.Infof("Analyzed rates data available, sender name: %v", analyzedResult.Base)
 
-	return jsonResult, nil
+	toRate, ok := rates[strings.ToUpper(to)]
+	if !ok {
+		return nil, e.unsupportedCurrency("Unsupported currency", to, envelope.CubeTime)
+	}
+
+	rate := toRate.Div(fromRate)
+	logger.Log.Infof("Conversion rate %v to %v on %v is %v", from, to, envelope.CubeTime, rate)
+
+	return &jsondata.Conversion{
+		From:   strings.ToUpper(from),
+		To:     strings.ToUpper(to),
+		Amount: amount,
+		Rate:   e.round(rate),
+		Result: e.round(amount.Mul(rate)),
+		Date:   envelope.CubeTime,
+	}, nil
 }
 
-// Json objects won't maintain order, to preserve order use Arrays
-// Solution is to build the string data manually
-func (e *Envelope) sortRatesThenToString(envelope *dbdata.Envelope) string {
-	result := ""
-	initialFormat := `{"base": "%v", "rates": %v}`
-	baseFormat := "{%v}"
-	delimiterFormat := ", "
-	dataFormat := `"%v": "%v"`
-	ratesHolder := ""
+// GetTimeSeries returns rates keyed by date for every stored day between start and end inclusive
+func (e *Envelope) GetTimeSeries(start, end string, symbols []string, base, asOf string) (*jsondata.TimeSeries, error) {
+	logger.Log.Infof("Request on getting time series from %v to %v as of %q started", start, end, asOf)
 
-	sort.Slice(envelope.Cube, func(i, j int) bool {
-		return envelope.Cube[i].Rate < envelope.Cube[j].Rate
-	})
+	if err := e.validateDateRange(start, end, settings.GetTimeSeriesMaxDays()); err != nil {
+		return nil, err
+	}
 
-	for index, cube := range envelope.Cube {
-		if index+1 >= len(envelope.Cube) {
-			ratesHolder += fmt.Sprintf(dataFormat, cube.Currency, cube.Rate)
-		} else {
-			ratesHolder += fmt.Sprintf(dataFormat, cube.Currency, cube.Rate) + delimiterFormat
-		}
+	query, err := e.rateQuery(asOf)
+	if err != nil {
+		return nil, err
 	}
 
-	result = fmt.Sprintf(initialFormat, envelope.SenderName, fmt.Sprintf(baseFormat, ratesHolder))
+	base = e.normalizeBase(base)
+	days, err := e.getRebasedRange(query, start, end, symbols, base)
+	if err != nil {
+		return nil, err
+	}
 
-	return result
+	result := &jsondata.TimeSeries{
+		Base:      base,
+		StartDate: start,
+		EndDate:   end,
+		Rates:     make(map[string]map[string]decimal.Decimal),
+	}
+
+	for _, day := range days {
+		result.Rates[day.cubeTime] = day.rates
+	}
+	logger.Log.Infof("Time series data available, %v days found", len(result.Rates))
+
+	return result, nil
 }
 
-func (e *Envelope) downloadXMLData() (*xmldata.Envelope, error) {
-	logger.Log.Infoln("Starting to download xml data")
+// getRebasedRange loads the stored days in range ordered by date, rebased and filtered to symbols unless empty.
+// Days without a rate of base are left out, it fails only when no stored day in range has one.
+func (e *Envelope) getRebasedRange(query db.RateQuery, start, end string, symbols []string, base string) ([]dailyRates, error) {
+	symbolFilter := make(map[string]bool)
+	currencies := []string{}
+	for _, symbol := range symbols {
+		symbolFilter[strings.ToUpper(symbol)] = true
+		currencies = append(currencies, strings.ToUpper(symbol))
+	}
+	if len(currencies) > 0 && base != BaseCurrency {
+		currencies = append(currencies, base)
+	}
 
-	resp, err := http.Get(settings.GetXMLDataURLPath())
+	envelopes, err := e.dbManager.GetRatesByDateRange(query, start, end, currencies)
 	if err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
+	days := []dailyRates{}
+	for index := range envelopes {
+		if _, ok := e.ratesToEUR(&envelopes[index])[base]; !ok {
+			continue
+		}
+
+		cubes, err := e.rebaseRates(&envelopes[index], base)
+		if err != nil {
+			return nil, err
+		}
+
+		day := dailyRates{cubeTime: envelopes[index].CubeTime, rates: make(map[string]decimal.Decimal)}
+		for _, cube := range cubes {
+			if len(symbolFilter) == 0 || symbolFilter[cube.Currency] {
+				day.rates[cube.Currency] = cube.Rate
+			}
+		}
+		days = append(days, day)
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Invalid response status: %v", resp.StatusCode)
+	if len(envelopes) > 0 && len(days) == 0 {
+		return nil, errUnsupportedCurrency.WithMessage(fmt.Sprintf("Unsupported base currency: %v between %v and %v", base, start, end)).
+			WithDetails(map[string]interface{}{"currency": base, "start": start, "end": end})
 	}
 
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil && data == nil {
-		return nil, fmt.Errorf("Unable to read xml data: %v", err.Error())
+	return days, nil
+}
+
+// rateQuery selects the served source as recorded at asOf, an RFC 3339 time, or the current rates when asOf is empty
+func (e *Envelope) rateQuery(asOf string) (db.RateQuery, error) {
+	query := db.RateQuery{Source: e.source}
+	if asOf == "" {
+		return query, nil
 	}
 
-	env := &xmldata.Envelope{}
-	err = xml.Unmarshal(data, env)
+	asOfTime, err := time.Parse(time.RFC3339, asOf)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to parse xml data: %v", err.Error())
+		return query, errInvalidAsOf.WithMessage(fmt.Sprintf("Invalid as_of: %q", asOf)).
+			WithDetails(map[string]interface{}{"as_of": asOf, "format": "RFC 3339, e.g. 2020-06-01T16:00:00Z"})
 	}
+	query.AsOf = asOfTime
+
+	return query, nil
+}
 
-	logger.Log.Infof("Successfully downloaded xml data. Status code: %v", resp.StatusCode)
+func (e *Envelope) validateDateRange(start, end string, maxDays int) error {
+	startDate, err := time.Parse(dateLayout, start)
+	if err != nil {
+		return errInvalidDate.WithMessage(fmt.Sprintf("Invalid start date: %q", start)).
+			WithDetails(map[string]interface{}{"start": start, "format": "YYYY-MM-DD"})
+	}
+
+	endDate, err := time.Parse(dateLayout, end)
+	if err != nil {
+		return errInvalidDate.WithMessage(fmt.Sprintf("Invalid end date: %q", end)).
+			WithDetails(map[string]interface{}{"end": end, "format": "YYYY-MM-DD"})
+	}
+
+	if endDate.Before(startDate) {
+		return errInvalidDateRange.WithMessage(fmt.Sprintf("End date %v is before start date %v", end, start)).
+			WithDetails(map[string]interface{}{"start": start, "end": end})
+	}
+
+	if days := int(endDate.Sub(startDate).Hours()/24) + 1; days > maxDays {
+		return errInvalidDateRange.WithMessage(fmt.Sprintf("Date range of %v days exceeds the maximum of %v days", days, maxDays)).
+			WithDetails(map[string]interface{}{"days": days, "max_days": maxDays})
+	}
+
+	return nil
+}
+
+// ratesToEUR maps every stored currency to its EUR rate, including EUR itself
+func (e *Envelope) ratesToEUR(envelope *dbdata.Envelope) map[string]decimal.Decimal {
+	rates := map[string]decimal.Decimal{BaseCurrency: decimal.New(1)}
+	for _, cube := range envelope.Cube {
+		rates[cube.Currency] = cube.Rate
+	}
 
-	return env, nil
+	return rates
 }
 
-func (e *Envelope) useDemoData() *xmldata.Envelope {
-	logger.Log.Warnf("Starting insertion of xml demo data")
-	env := &xmldata.Envelope{}
+// rebaseThenToString adds the requested and effective dates when requestedDate is set
+func (e *Envelope) rebaseThenToString(envelope *dbdata.Envelope, base, requestedDate string) (string, error) {
+	base = e.normalizeBase(base)
 
-	data, err := ioutil.ReadFile(settings.GetXMLDataFilePath())
+	cubes, err := e.rebaseRates(envelope, base)
 	if err != nil {
-		logger.Log.Fatalln("Unable to read demo data: %v", err)
+		return "", err
+	}
+
+	dates := ""
+	if requestedDate != "" {
+		dates = fmt.Sprintf(`"requested_date": "%v", "date": "%v", `, requestedDate, envelope.CubeTime)
+	}
+
+	return e.sortRatesThenToString(base, dates, cubes), nil
+}
+
+// rebaseRates re-expresses every rate against base, EUR included as a row and base itself left out
+func (e *Envelope) rebaseRates(envelope *dbdata.Envelope, base string) ([]dbdata.Cube, error) {
+	rates := e.ratesToEUR(envelope)
+	baseRate, ok := rates[base]
+	if !ok || baseRate.Sign() <= 0 {
+		return nil, e.unsupportedCurrency("Unsupported base currency", base, envelope.CubeTime)
 	}
 
-	if err = xml.Unmarshal(data, env); err != nil {
-		logger.Log.Fatalln("Unable to parse demo data: %v", err)
+	cubes := []dbdata.Cube{}
+	for currency, rate := range rates {
+		if currency == base {
+			continue
+		}
+
+		cubes = append(cubes, dbdata.Cube{Currency: currency, Rate: e.round(rate.Div(baseRate))})
 	}
 
-	logger.Log.Warnf("Currently using xml demo data")
+	return cubes, nil
+}
+
+func (e *Envelope) normalizeBase(base string) string {
+	if base == "" {
+		return BaseCurrency
+	}
 
-	return env
+	return strings.ToUpper(base)
 }
 
-func (e *Envelope) convertXMLtoDBEntities(xmlEnvelope *xmldata.Envelope) []dbdata.Envelope {
+// Json objects won't maintain order, to preserve order use Arrays
+// Solution is to build the string data manually
+func (e *Envelope) sortRatesThenToString(base, dates string, cubes []dbdata.Cube) string {
+	result := ""
+	initialFormat := `{"base": "%v", %v"rates": %v}`
+	baseFormat := "{%v}"
+	delimiterFormat := ", "
+	dataFormat := `"%v": "%v"`
+	ratesHolder := ""
+
+	sort.Slice(cubes, func(i, j int) bool {
+		if cmp := cubes[i].Rate.Cmp(cubes[j].Rate); cmp != 0 {
+			return cmp < 0
+		}
+		return cubes[i].Currency < cubes[j].Currency
+	})
+
+	for index, cube := range cubes {
+		if index+1 >= len(cubes) {
+			ratesHolder += fmt.Sprintf(dataFormat, cube.Currency, cube.Rate)
+		} else {
+			ratesHolder += fmt.Sprintf(dataFormat, cube.Currency, cube.Rate) + delimiterFormat
+		}
+	}
+
+	result = fmt.Sprintf(initialFormat, base, dates, fmt.Sprintf(baseFormat, ratesHolder))
+
+	return result
+}
+
+// round cuts a computed value to the configured precision and rounding mode
+func (e *Envelope) round(value decimal.Decimal) decimal.Decimal {
+	return value.Round(e.precision, e.roundingMode)
+}
+
+// rateRounding reads RATE_PRECISION and RATE_ROUNDING once, an invalid mode is reported and rounds half-even
+func rateRounding() (int32, decimal.RoundingMode) {
+	mode, err := decimal.ParseRoundingMode(settings.GetRateRounding())
+	if err != nil {
+		logger.Log.Warnf("%v, rounding half-even", err)
+	}
+
+	return int32(settings.GetRatePrecision()), mode
+}
+
+func (e *Envelope) unsupportedCurrency(message, currency, cubeTime string) error {
+	return errUnsupportedCurrency.WithMessage(fmt.Sprintf("%v: %v on %v", message, currency, cubeTime)).
+		WithDetails(map[string]interface{}{"currency": currency, "date": cubeTime})
+}
+
+func convertXMLtoDBEntities(xmlEnvelope *xmldata.Envelope) []dbdata.Envelope {
 	dbEnvelopeList := []dbdata.Envelope{}
 
 	for _, cube1 := range xmlEnvelope.Cube.Cube {
-		dbCubeList := []dbdata.Cube{}
+		envelope := dbdata.Envelope{
+			SenderName: xmlEnvelope.Sender.Name,
+			CubeTime:   cube1.Time,
+			Cube:       []dbdata.Cube{},
+		}
 
 		for _, cube2 := range cube1.Cube {
-			dbCubeList = append(dbCubeList, dbdata.Cube{
-				Currency: cube2.Currency,
-				Rate:     cube2.Rate,
-			})
+			appendRate(&envelope, cube2.Currency, cube2.Rate)
 		}
 
-		dbEnvelopeList = append(dbEnvelopeList, dbdata.Envelope{
-			SenderName: xmlEnvelope.Sender.Name,
-			CubeTime:   cube1.Time,
-			Cube:       dbCubeList,
-		})
+		dbEnvelopeList = append(dbEnvelopeList, envelope)
 	}
 
 	return dbEnvelopeList
@@ -2,82 +2,170 @@ package envelope
 
 import (
 	"errors"
+	"os"
 	"reflect"
 	"testing"
 
+	"github.com/emanpicar/currency-api/apperror"
+	"github.com/emanpicar/currency-api/db"
+	"github.com/emanpicar/currency-api/decimal"
 	"github.com/emanpicar/currency-api/entities/dbdata"
 	"github.com/emanpicar/currency-api/entities/jsondata"
 )
 
 var (
-	throwErrorInGetLatestRate, throwErrorInGetRateByDate, throwErrorInAnalyzedRate bool
-	mockEnvelopeExpectedResult                                                     string          = `{"base": "Mock Sender", "rates": {"PHP": "50.999", "HPH": "999.5"}}`
-	mockEnvelopeResult                                                             dbdata.Envelope = dbdata.Envelope{SenderName: "Mock Sender", Cube: []dbdata.Cube{
-		dbdata.Cube{Currency: "PHP", Rate: 50.999},
-		dbdata.Cube{Currency: "HPH", Rate: 999.50},
+	throwErrorInGetLatestRate, throwErrorInGetRateByDate, throwErrorInGetRatesByDateRange bool
+	mockEnvelopeExpectedResult                                                            string          = `{"base": "EUR", "rates": {"PHP": "50.999", "HPH": "999.5"}}`
+	mockRebasedExpectedResult                                                             string          = `{"base": "PHP", "rates": {"EUR": "0.0196082276", "HPH": "19.5984234985"}}`
+	mockStoredDays                                                                        []string        = []string{"2020-05-29", "2020-06-01", "2020-06-02"}
+	mockEnvelopeResult                                                                    dbdata.Envelope = dbdata.Envelope{SenderName: "Mock Sender", Cube: []dbdata.Cube{
+		dbdata.Cube{Currency: "PHP", Rate: decimal.RequireFromString("50.999")},
+		dbdata.Cube{Currency: "HPH", Rate: decimal.RequireFromString("999.50")},
 	}}
 	mockAnalyzedResult jsondata.QuantitativeExchangeRate = jsondata.QuantitativeExchangeRate{
-		Base:         "Mock Sender",
-		RatesAnalyze: map[string]jsondata.RatesAnalyze{"PHP": jsondata.RatesAnalyze{50.555, 60.666, 55.555}},
+		Base:      "EUR",
+		StartDate: "2020-06-01",
+		EndDate:   "2020-06-02",
+		RatesAnalyze: map[string]jsondata.RatesAnalyze{
+			"PHP": jsondata.RatesAnalyze{Min: decimal.RequireFromString("40"), Max: decimal.RequireFromString("50"), Avg: decimal.RequireFromString("45"), Median: decimal.RequireFromString("45"), StdDev: decimal.RequireFromString("7.0710678119"), First: decimal.RequireFromString("50"), Last: decimal.RequireFromString("40"), ChangePercent: decimal.RequireFromString("-20"), Count: 2},
+		},
 	}
 )
 
 type (
-	MockDBHandler struct{}
+	// MockDBHandler embeds db.Manager so only the methods used by envelope need stubs
+	MockDBHandler struct {
+		db.Manager
+	}
 )
 
-func (m MockDBHandler) BatchFirstOrCreate(dbEnvelopeList *[]dbdata.Envelope) {}
-func (m MockDBHandler) GetLatestRates() (*dbdata.Envelope, error) {
+func (m MockDBHandler) BatchUpsert(dbEnvelopeList *[]dbdata.Envelope) (*db.UpsertSummary, error) {
+	return &db.UpsertSummary{DaysCreated: len(*dbEnvelopeList)}, nil
+}
+func (m MockDBHandler) GetLatestRates(query db.RateQuery) (*dbdata.Envelope, error) {
 	if throwErrorInGetLatestRate {
 		return nil, errors.New("Record not found")
 	}
 
 	return &mockEnvelopeResult, nil
 }
-func (m MockDBHandler) GetRatesByDate(cubeTime string) (*dbdata.Envelope, error) {
+func (m MockDBHandler) GetRatesByDate(query db.RateQuery, cubeTime string) (*dbdata.Envelope, error) {
 	if throwErrorInGetRateByDate {
 		return nil, errors.New("Record not found")
 	}
+	// Rates of a day are not recorded before that day
+	if !query.AsOf.IsZero() && query.AsOf.Format(dateLayout) < cubeTime {
+		return nil, db.ErrNotFound
+	}
 
-	return &mockEnvelopeResult, nil
+	return m.storedDay(cubeTime)
 }
-func (m MockDBHandler) GetAnalyzedRates() (*dbdata.QuantitativeExchangeRate, error) {
-	if throwErrorInAnalyzedRate {
-		return nil, errors.New("Record not found")
+func (m MockDBHandler) GetRatesOnOrBefore(query db.RateQuery, cubeTime, earliest string) (*dbdata.Envelope, error) {
+	for index := len(mockStoredDays) - 1; index >= 0; index-- {
+		if mockStoredDays[index] <= cubeTime && mockStoredDays[index] >= earliest {
+			return m.storedDay(mockStoredDays[index])
+		}
 	}
 
-	return &dbdata.QuantitativeExchangeRate{
-		Base:         "Mock Sender",
-		RatesAnalyze: []dbdata.RatesAnalyze{dbdata.RatesAnalyze{"PHP", 50.555, 60.666, 55.555}},
-	}, nil
+	return nil, db.ErrNotFound
+}
+func (m MockDBHandler) GetRatesOnOrAfter(query db.RateQuery, cubeTime, latest string) (*dbdata.Envelope, error) {
+	for _, storedDay := range mockStoredDays {
+		if storedDay >= cubeTime && storedDay <= latest {
+			return m.storedDay(storedDay)
+		}
+	}
+
+	return nil, db.ErrNotFound
+}
+func (m MockDBHandler) storedDay(cubeTime string) (*dbdata.Envelope, error) {
+	for _, storedDay := range mockStoredDays {
+		if storedDay == cubeTime {
+			envelope := mockEnvelopeResult
+			envelope.CubeTime = cubeTime
+			return &envelope, nil
+		}
+	}
+
+	return nil, db.ErrNotFound
 }
+func (m MockDBHandler) GetRatesByDateRange(query db.RateQuery, start, end string, currencies []string) ([]dbdata.Envelope, error) {
+	if throwErrorInGetRatesByDateRange {
+		return nil, errors.New("Connection refused")
+	}
 
+	return []dbdata.Envelope{
+		dbdata.Envelope{CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+			dbdata.Cube{Currency: "PHP", Rate: decimal.RequireFromString("50")},
+			dbdata.Cube{Currency: "HPH", Rate: decimal.RequireFromString("1000")},
+		}},
+		dbdata.Envelope{CubeTime: "2020-06-02", Cube: []dbdata.Cube{
+			dbdata.Cube{Currency: "PHP", Rate: decimal.RequireFromString("40")},
+			dbdata.Cube{Currency: "HPH", Rate: decimal.RequireFromString("1000")},
+		}},
+	}, nil
+}
 func TestEnvelope_GetLatestRates(t *testing.T) {
+	type args struct {
+		base string
+		asOf string
+	}
 	tests := []struct {
 		name    string
 		e       *Envelope
+		args    args
 		want    string
 		wantErr bool
 	}{
 		struct {
 			name    string
 			e       *Envelope
+			args    args
 			want    string
 			wantErr bool
 		}{
 			name:    "Records found",
-			e:       &Envelope{dbManager: &MockDBHandler{}},
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{base: ""},
 			want:    mockEnvelopeExpectedResult,
 			wantErr: false,
 		},
 		struct {
 			name    string
 			e       *Envelope
+			args    args
+			want    string
+			wantErr bool
+		}{
+			name:    "Records found with base currency",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{base: "php"},
+			want:    mockRebasedExpectedResult,
+			wantErr: false,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
 			want    string
 			wantErr bool
 		}{
 			name:    "Records not found",
-			e:       &Envelope{dbManager: &MockDBHandler{}},
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{base: ""},
+			want:    "",
+			wantErr: true,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    string
+			wantErr bool
+		}{
+			name:    "Invalid as_of",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{asOf: "2020-06-01"},
 			want:    "",
 			wantErr: true,
 		},
@@ -85,7 +173,7 @@ func TestEnvelope_GetLatestRates(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			throwErrorInGetLatestRate = tt.wantErr
-			got, err := tt.e.GetLatestRates()
+			got, err := tt.e.GetLatestRates(tt.args.base, tt.args.asOf)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Envelope.GetLatestRates() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -100,6 +188,9 @@ func TestEnvelope_GetLatestRates(t *testing.T) {
 func TestEnvelope_GetRatesByDate(t *testing.T) {
 	type args struct {
 		cubeTime string
+		base     string
+		fallback string
+		asOf     string
 	}
 	tests := []struct {
 		name    string
@@ -116,11 +207,102 @@ func TestEnvelope_GetRatesByDate(t *testing.T) {
 			wantErr bool
 		}{
 			name:    "Records found",
-			e:       &Envelope{dbManager: &MockDBHandler{}},
-			args:    args{cubeTime: "2020-06-01"},
-			want:    mockEnvelopeExpectedResult,
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{cubeTime: "2020-06-01", base: "EUR"},
+			want:    `{"base": "EUR", "requested_date": "2020-06-01", "date": "2020-06-01", "rates": {"PHP": "50.999", "HPH": "999.5"}}`,
+			wantErr: false,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    string
+			wantErr bool
+		}{
+			name:    "Records found with base currency",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{cubeTime: "2020-06-01", base: "PHP"},
+			want:    `{"base": "PHP", "requested_date": "2020-06-01", "date": "2020-06-01", "rates": {"EUR": "0.0196082276", "HPH": "19.5984234985"}}`,
+			wantErr: false,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    string
+			wantErr bool
+		}{
+			name:    "Unsupported base currency",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{cubeTime: "2020-06-01", base: "XXX"},
+			want:    "",
+			wantErr: true,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    string
+			wantErr bool
+		}{
+			name:    "Weekend without fallback",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{cubeTime: "2020-05-30", fallback: "none"},
+			want:    "",
+			wantErr: true,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    string
+			wantErr bool
+		}{
+			name:    "Weekend falls back to previous business day",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{cubeTime: "2020-05-30", fallback: "previous"},
+			want:    `{"base": "EUR", "requested_date": "2020-05-30", "date": "2020-05-29", "rates": {"PHP": "50.999", "HPH": "999.5"}}`,
+			wantErr: false,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    string
+			wantErr bool
+		}{
+			name:    "Weekend falls back to next business day",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{cubeTime: "2020-05-31", fallback: "NEXT"},
+			want:    `{"base": "EUR", "requested_date": "2020-05-31", "date": "2020-06-01", "rates": {"PHP": "50.999", "HPH": "999.5"}}`,
 			wantErr: false,
 		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    string
+			wantErr bool
+		}{
+			name:    "Fallback beyond the maximum distance",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{cubeTime: "2020-07-01", fallback: "previous"},
+			want:    "",
+			wantErr: true,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    string
+			wantErr bool
+		}{
+			name:    "Invalid fallback",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{cubeTime: "2020-05-30", fallback: "closest"},
+			want:    "",
+			wantErr: true,
+		},
 		struct {
 			name    string
 			e       *Envelope
@@ -129,16 +311,55 @@ func TestEnvelope_GetRatesByDate(t *testing.T) {
 			wantErr bool
 		}{
 			name:    "Records not found",
-			e:       &Envelope{dbManager: &MockDBHandler{}},
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
 			args:    args{cubeTime: "9999-66-11"},
 			want:    "",
 			wantErr: true,
 		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    string
+			wantErr bool
+		}{
+			name:    "Records recorded as of",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{cubeTime: "2020-06-01", asOf: "2020-06-01T16:00:00+02:00"},
+			want:    `{"base": "EUR", "requested_date": "2020-06-01", "date": "2020-06-01", "rates": {"PHP": "50.999", "HPH": "999.5"}}`,
+			wantErr: false,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    string
+			wantErr bool
+		}{
+			name:    "Records not yet recorded as of",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{cubeTime: "2020-06-01", asOf: "2020-05-31T23:59:59Z"},
+			want:    "",
+			wantErr: true,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    string
+			wantErr bool
+		}{
+			name:    "Invalid as_of",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{cubeTime: "2020-06-01", asOf: "yesterday"},
+			want:    "",
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			throwErrorInGetRateByDate = tt.wantErr
-			got, err := tt.e.GetRatesByDate(tt.args.cubeTime)
+			throwErrorInGetRateByDate = tt.name == "Records not found"
+			got, err := tt.e.GetRatesByDate(tt.args.cubeTime, tt.args.base, tt.args.fallback, tt.args.asOf)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Envelope.GetRatesByDate() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -151,39 +372,96 @@ func TestEnvelope_GetRatesByDate(t *testing.T) {
 }
 
 func TestEnvelope_GetAnalyzedRates(t *testing.T) {
+	type args struct {
+		start   string
+		end     string
+		symbols []string
+		base    string
+	}
 	tests := []struct {
 		name    string
 		e       *Envelope
+		args    args
 		want    *jsondata.QuantitativeExchangeRate
 		wantErr bool
 	}{
 		struct {
 			name    string
 			e       *Envelope
+			args    args
 			want    *jsondata.QuantitativeExchangeRate
 			wantErr bool
 		}{
 			name:    "Records found",
-			e:       &Envelope{dbManager: &MockDBHandler{}},
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{symbols: []string{"PHP"}},
 			want:    &mockAnalyzedResult,
 			wantErr: false,
 		},
 		struct {
 			name    string
 			e       *Envelope
+			args    args
+			want    *jsondata.QuantitativeExchangeRate
+			wantErr bool
+		}{
+			name: "Records found with base currency",
+			e:    &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args: args{start: "2020-06-01", end: "2020-06-30", symbols: []string{"HPH"}, base: "PHP"},
+			want: &jsondata.QuantitativeExchangeRate{
+				Base:      "PHP",
+				StartDate: "2020-06-01",
+				EndDate:   "2020-06-02",
+				RatesAnalyze: map[string]jsondata.RatesAnalyze{
+					"HPH": jsondata.RatesAnalyze{Min: decimal.RequireFromString("20"), Max: decimal.RequireFromString("25"), Avg: decimal.RequireFromString("22.5"), Median: decimal.RequireFromString("22.5"), StdDev: decimal.RequireFromString("3.5355339059"), First: decimal.RequireFromString("20"), Last: decimal.RequireFromString("25"), ChangePercent: decimal.RequireFromString("25"), Count: 2},
+				},
+			},
+			wantErr: false,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    *jsondata.QuantitativeExchangeRate
+			wantErr bool
+		}{
+			name:    "Invalid date range",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{start: "2020-06-30", end: "2020-06-01"},
+			want:    nil,
+			wantErr: true,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    *jsondata.QuantitativeExchangeRate
+			wantErr bool
+		}{
+			name:    "Range up to today longer than the maximum",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{start: "2000-01-01"},
+			want:    nil,
+			wantErr: true,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
 			want    *jsondata.QuantitativeExchangeRate
 			wantErr bool
 		}{
 			name:    "Records not found",
-			e:       &Envelope{dbManager: &MockDBHandler{}},
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{},
 			want:    nil,
 			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			throwErrorInAnalyzedRate = tt.wantErr
-			got, err := tt.e.GetAnalyzedRates()
+			throwErrorInGetRatesByDateRange = tt.name == "Records not found"
+			got, err := tt.e.GetAnalyzedRates(tt.args.start, tt.args.end, tt.args.symbols, tt.args.base, "")
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Envelope.GetAnalyzedRates() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -194,3 +472,323 @@ func TestEnvelope_GetAnalyzedRates(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvelope_ConvertAmount(t *testing.T) {
+	type args struct {
+		from     string
+		to       string
+		amount   decimal.Decimal
+		cubeTime string
+	}
+	tests := []struct {
+		name    string
+		e       *Envelope
+		args    args
+		want    *jsondata.Conversion
+		wantErr bool
+	}{
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    *jsondata.Conversion
+			wantErr bool
+		}{
+			name:    "EUR to stored currency",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{from: "eur", to: "PHP", amount: decimal.New(10)},
+			want:    &jsondata.Conversion{From: "EUR", To: "PHP", Amount: decimal.New(10), Rate: decimal.RequireFromString("50.999"), Result: decimal.RequireFromString("509.99")},
+			wantErr: false,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    *jsondata.Conversion
+			wantErr bool
+		}{
+			name:    "Cross rate through EUR",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{from: "PHP", to: "HPH", amount: decimal.RequireFromString("125.5"), cubeTime: "2020-06-01"},
+			want:    &jsondata.Conversion{From: "PHP", To: "HPH", Amount: decimal.RequireFromString("125.5"), Rate: decimal.RequireFromString("19.5984234985"), Result: decimal.RequireFromString("2459.6021490617"), Date: "2020-06-01"},
+			wantErr: false,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    *jsondata.Conversion
+			wantErr bool
+		}{
+			name:    "Unsupported currency",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{from: "XXX", to: "PHP", amount: decimal.New(1)},
+			want:    nil,
+			wantErr: true,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    *jsondata.Conversion
+			wantErr bool
+		}{
+			name:    "Invalid date",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{from: "PHP", to: "HPH", amount: decimal.New(1), cubeTime: "foo"},
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			throwErrorInGetLatestRate, throwErrorInGetRateByDate = false, false
+			got, err := tt.e.ConvertAmount(tt.args.from, tt.args.to, tt.args.amount, tt.args.cubeTime, "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Envelope.ConvertAmount() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.args.cubeTime == "foo" && apperror.As(err).Code != "invalid_date" {
+				t.Errorf("Envelope.ConvertAmount() error = %v, want invalid_date", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Envelope.ConvertAmount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvelope_ConvertAmount_rounding(t *testing.T) {
+	tests := []struct {
+		name       string
+		precision  string
+		rounding   string
+		wantRate   string
+		wantResult string
+	}{
+		struct {
+			name       string
+			precision  string
+			rounding   string
+			wantRate   string
+			wantResult string
+		}{
+			name:       "Half even",
+			precision:  "4",
+			rounding:   "half-even",
+			wantRate:   "19.5984",
+			wantResult: "0.0196",
+		},
+		struct {
+			name       string
+			precision  string
+			rounding   string
+			wantRate   string
+			wantResult string
+		}{
+			name:       "Half up",
+			precision:  "2",
+			rounding:   "half-up",
+			wantRate:   "19.6",
+			wantResult: "0.02",
+		},
+		struct {
+			name       string
+			precision  string
+			rounding   string
+			wantRate   string
+			wantResult string
+		}{
+			name:       "Down",
+			precision:  "2",
+			rounding:   "down",
+			wantRate:   "19.59",
+			wantResult: "0.01",
+		},
+	}
+	defer os.Unsetenv("RATE_PRECISION")
+	defer os.Unsetenv("RATE_ROUNDING")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			throwErrorInGetLatestRate, throwErrorInGetRateByDate = false, false
+			os.Setenv("RATE_PRECISION", tt.precision)
+			os.Setenv("RATE_ROUNDING", tt.rounding)
+			precision, roundingMode := rateRounding()
+			e := &Envelope{dbManager: &MockDBHandler{}, precision: precision, roundingMode: roundingMode}
+
+			got, err := e.ConvertAmount("PHP", "HPH", decimal.RequireFromString("0.001"), "", "")
+			if err != nil {
+				t.Fatalf("Envelope.ConvertAmount() error = %v", err)
+			}
+			if got.Rate.String() != tt.wantRate || got.Result.String() != tt.wantResult {
+				t.Errorf("Envelope.ConvertAmount() = %v, %v, want %v, %v", got.Rate, got.Result, tt.wantRate, tt.wantResult)
+			}
+		})
+	}
+}
+
+func TestEnvelope_GetTimeSeries(t *testing.T) {
+	type args struct {
+		start   string
+		end     string
+		symbols []string
+		base    string
+	}
+	tests := []struct {
+		name    string
+		e       *Envelope
+		args    args
+		want    *jsondata.TimeSeries
+		wantErr bool
+	}{
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    *jsondata.TimeSeries
+			wantErr bool
+		}{
+			name: "All symbols",
+			e:    &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args: args{start: "2020-06-01", end: "2020-06-02"},
+			want: &jsondata.TimeSeries{Base: "EUR", StartDate: "2020-06-01", EndDate: "2020-06-02", Rates: map[string]map[string]decimal.Decimal{
+				"2020-06-01": map[string]decimal.Decimal{"PHP": decimal.RequireFromString("50"), "HPH": decimal.RequireFromString("1000")},
+				"2020-06-02": map[string]decimal.Decimal{"PHP": decimal.RequireFromString("40"), "HPH": decimal.RequireFromString("1000")},
+			}},
+			wantErr: false,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    *jsondata.TimeSeries
+			wantErr bool
+		}{
+			name: "Filtered symbols with base currency",
+			e:    &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args: args{start: "2020-06-01", end: "2020-06-02", symbols: []string{"eur", "HPH"}, base: "PHP"},
+			want: &jsondata.TimeSeries{Base: "PHP", StartDate: "2020-06-01", EndDate: "2020-06-02", Rates: map[string]map[string]decimal.Decimal{
+				"2020-06-01": map[string]decimal.Decimal{"EUR": decimal.RequireFromString("0.02"), "HPH": decimal.RequireFromString("20")},
+				"2020-06-02": map[string]decimal.Decimal{"EUR": decimal.RequireFromString("0.025"), "HPH": decimal.RequireFromString("25")},
+			}},
+			wantErr: false,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    *jsondata.TimeSeries
+			wantErr bool
+		}{
+			name:    "End before start",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{start: "2020-06-02", end: "2020-06-01"},
+			want:    nil,
+			wantErr: true,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    *jsondata.TimeSeries
+			wantErr bool
+		}{
+			name:    "Range too large",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{start: "2000-01-01", end: "2020-06-01"},
+			want:    nil,
+			wantErr: true,
+		},
+		struct {
+			name    string
+			e       *Envelope
+			args    args
+			want    *jsondata.TimeSeries
+			wantErr bool
+		}{
+			name:    "Invalid date",
+			e:       &Envelope{dbManager: &MockDBHandler{}, precision: 10},
+			args:    args{start: "2020-6-1", end: "2020-06-01"},
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			throwErrorInGetRatesByDateRange = false
+			got, err := tt.e.GetTimeSeries(tt.args.start, tt.args.end, tt.args.symbols, tt.args.base, "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Envelope.GetTimeSeries() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Envelope.GetTimeSeries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// rangeDBHandler serves envelopes as the stored days of every range
+type rangeDBHandler struct {
+	MockDBHandler
+	envelopes []dbdata.Envelope
+}
+
+func (m *rangeDBHandler) GetRatesByDateRange(query db.RateQuery, start, end string, currencies []string) ([]dbdata.Envelope, error) {
+	return m.envelopes, nil
+}
+
+func TestEnvelope_GetTimeSeries_withdrawnBase(t *testing.T) {
+	envelopes := []dbdata.Envelope{
+		dbdata.Envelope{CubeTime: "2022-12-30", Cube: []dbdata.Cube{
+			dbdata.Cube{Currency: "HRK", Rate: decimal.RequireFromString("7.5")},
+			dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.5")},
+		}},
+		dbdata.Envelope{CubeTime: "2023-01-02", Cube: []dbdata.Cube{
+			dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.0666")},
+		}},
+	}
+
+	tests := []struct {
+		name    string
+		base    string
+		want    map[string]map[string]decimal.Decimal
+		wantErr bool
+	}{
+		struct {
+			name    string
+			base    string
+			want    map[string]map[string]decimal.Decimal
+			wantErr bool
+		}{
+			name: "Days without the base are left out",
+			base: "HRK",
+			want: map[string]map[string]decimal.Decimal{
+				"2022-12-30": map[string]decimal.Decimal{"EUR": decimal.RequireFromString("0.1333333333"), "USD": decimal.RequireFromString("0.2")},
+			},
+		},
+		struct {
+			name    string
+			base    string
+			want    map[string]map[string]decimal.Decimal
+			wantErr bool
+		}{
+			name:    "No day with the base",
+			base:    "GBP",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Envelope{dbManager: &rangeDBHandler{envelopes: envelopes}, precision: 10}
+			got, err := e.GetTimeSeries("2022-12-30", "2023-01-02", nil, tt.base, "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Envelope.GetTimeSeries() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got.Rates, tt.want) {
+				t.Errorf("Envelope.GetTimeSeries() = %v, want %v", got.Rates, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package envelope
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/fetcher"
+	"github.com/emanpicar/currency-api/logger"
+)
+
+// ecbSenderName is stored for ECB CSV files, which do not name their sender
+const ecbSenderName = "European Central Bank"
+
+// feedDateLayouts covers ISO dates, eurofxref-hist.csv and the single day eurofxref.csv
+var feedDateLayouts = []string{dateLayout, "02 January 2006"}
+
+// ImportHistory streams every day of source into the database in batches and returns the number of days read.
+// source is an http(s) URL or a local path to an ECB XML or CSV file, or a ZIP archive holding them.
+// A source imported successfully before is skipped, so restarts do not download the history again.
+func (e *Envelope) ImportHistory(source string) (int, error) {
+	atomic.AddInt32(&e.refreshing, 1)
+	defer atomic.AddInt32(&e.refreshing, -1)
+
+	imported, err := e.dbManager.CountIngestionRuns(&dbdata.IngestionRun{
+		Source: ECBSource, Trigger: TriggerImport, Location: source, Status: IngestionSucceeded,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if imported > 0 {
+		logger.Log.Infof("Rate history from %v is already imported, skipping", source)
+		return 0, nil
+	}
+
+	logger.Log.Infof("Importing rate history from %v started", source)
+
+	run := &dbdata.IngestionRun{Source: ECBSource, Trigger: TriggerImport, Location: source}
+	err = e.ingest(run, func(emit func(dbdata.Envelope) error, flush func() error) error {
+		return readSource(e.fetchManager, source, "", ecbSenderName, emit, flush)
+	})
+	if err != nil {
+		return run.DaysSeen, err
+	}
+
+	logger.Log.Infof("Importing rate history from %v completed, %v days processed", source, run.DaysSeen)
+
+	return run.DaysSeen, nil
+}
+
+// readSource emits every day of source, a local path or a URL downloaded with fetchManager.
+// senderName is stored for formats that do not name their sender. A download is flushed before fetchManager
+// considers it consumed, so its validators are never kept for days that were not stored.
+func readSource(fetchManager fetcher.Manager, source, format, senderName string, emit func(dbdata.Envelope) error, flush func() error) error {
+	sourceURL, err := url.Parse(source)
+	if err != nil || (sourceURL.Scheme != "http" && sourceURL.Scheme != "https") {
+		file, err := os.Open(source)
+		if err != nil {
+			return fmt.Errorf("Unable to open rate source: %v", err)
+		}
+		defer file.Close()
+
+		return decodeFile(file, source, format, senderName, emit)
+	}
+
+	return fetchManager.Fetch(source, func(body io.Reader, size int64) error {
+		file, err := downloadFile(body, sourceURL.Path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if err := decodeFile(file, sourceURL.Path, format, senderName, emit); err != nil {
+			return err
+		}
+		return flush()
+	})
+}
+
+// decodeFile parses file as format (xml, csv, json or zip), or by the extension of name when format is empty
+func decodeFile(file *os.File, name, format, senderName string, emit func(dbdata.Envelope) error) error {
+	if format == "" {
+		format = strings.TrimPrefix(path.Ext(name), ".")
+	}
+
+	switch strings.ToLower(format) {
+	case "zip":
+		return decodeZIP(file, senderName, emit)
+	case "csv":
+		return decodeCSVStream(file, senderName, emit)
+	case "json":
+		return decodeJSONStream(file, senderName, emit)
+	}
+
+	return decodeXMLStream(file, emit)
+}
+
+// downloadFile copies body into a temporary file since ZIP archives need random access.
+// The returned file removes its temporary copy when closed.
+func downloadFile(body io.Reader, name string) (*os.File, error) {
+	file, err := ioutil.TempFile("", "currency-api-rates-*"+path.Ext(name))
+	if err != nil {
+		return nil, err
+	}
+	// Unlinking right away keeps the open file readable and leaves nothing behind
+	os.Remove(file.Name())
+
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Unable to download rate source: %v", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// decodeZIP reads every XML, CSV and JSON entry of the archive, other entries are skipped
+func decodeZIP(file *os.File, senderName string, emit func(dbdata.Envelope) error) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	archive, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return fmt.Errorf("Unable to open zip archive: %v", err)
+	}
+
+	entries := 0
+	for _, entry := range archive.File {
+		extension := strings.ToLower(path.Ext(entry.Name))
+		if extension != ".xml" && extension != ".csv" && extension != ".json" {
+			continue
+		}
+
+		reader, err := entry.Open()
+		if err != nil {
+			return fmt.Errorf("Unable to open %v: %v", entry.Name, err)
+		}
+
+		switch extension {
+		case ".csv":
+			err = decodeCSVStream(reader, senderName, emit)
+		case ".json":
+			err = decodeJSONStream(reader, senderName, emit)
+		default:
+			err = decodeXMLStream(reader, emit)
+		}
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("%v: %v", entry.Name, err)
+		}
+		entries++
+	}
+
+	if entries == 0 {
+		return errors.New("Zip archive holds no xml, csv or json file")
+	}
+
+	return nil
+}
+
+// decodeCSVStream reads the ECB layout: a Date column followed by one column per currency, N/A when not quoted
+func decodeCSVStream(reader io.Reader, senderName string, emit func(dbdata.Envelope) error) error {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return fmt.Errorf("Unable to read csv header: %v", err)
+	}
+	if strings.TrimSpace(strings.TrimPrefix(header[0], "\ufeff")) != "Date" {
+		return fmt.Errorf("Unexpected csv header: %v", header[0])
+	}
+
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to read csv data: %v", err)
+		}
+
+		envelope := dbdata.Envelope{SenderName: senderName, CubeTime: normalizeFeedDate(record[0]), Cube: []dbdata.Cube{}}
+		for index := 1; index < len(record) && index < len(header); index++ {
+			currency, value := strings.TrimSpace(header[index]), strings.TrimSpace(record[index])
+			if currency == "" || value == "" || value == "N/A" {
+				continue
+			}
+
+			appendRate(&envelope, currency, value)
+		}
+
+		if err := emit(envelope); err != nil {
+			return err
+		}
+	}
+}
+
+// normalizeFeedDate formats value as YYYY-MM-DD, an unknown date is kept as is for validation to reject its day
+func normalizeFeedDate(value string) string {
+	value = strings.TrimSpace(value)
+	for _, layout := range feedDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date.Format(dateLayout)
+		}
+	}
+
+	return value
+}
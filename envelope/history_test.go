@@ -0,0 +1,380 @@
+package envelope
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emanpicar/currency-api/db"
+	"github.com/emanpicar/currency-api/decimal"
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/fetcher"
+)
+
+const (
+	mockHistoryXMLPath = "../xmlfile/eurofxref-hist-90d.xml"
+	mockHistoryCSV     = "\ufeffDate,USD,JPY,CYP,\n2020-06-02,1.1174,120.78,N/A,\n01 June 2020,1.1136,120.27,,\n"
+)
+
+var mockValidDay = dbdata.Envelope{CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+	dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1136")},
+}}
+
+type (
+	// historyDBHandler records what ImportHistory writes, or fails every write with err
+	historyDBHandler struct {
+		MockDBHandler
+		days        []dbdata.Envelope
+		batches     int
+		runs        []dbdata.IngestionRun
+		quarantined []dbdata.QuarantinedDay
+		err         error
+	}
+)
+
+func (m *historyDBHandler) BatchUpsert(dbEnvelopeList *[]dbdata.Envelope) (*db.UpsertSummary, error) {
+	m.batches++
+	if m.err != nil {
+		return &db.UpsertSummary{}, m.err
+	}
+
+	m.days = append(m.days, *dbEnvelopeList...)
+	return &db.UpsertSummary{DaysCreated: len(*dbEnvelopeList)}, nil
+}
+
+// SaveIngestionRun keeps a copy of every save, so both the running and the finished state are recorded
+func (m *historyDBHandler) SaveIngestionRun(run *dbdata.IngestionRun) error {
+	m.runs = append(m.runs, *run)
+	return nil
+}
+
+func (m *historyDBHandler) CountIngestionRuns(filter *dbdata.IngestionRun) (int, error) {
+	count := 0
+	for _, run := range m.runs {
+		if run.Source == filter.Source && run.Trigger == filter.Trigger && run.Location == filter.Location && run.Status == filter.Status {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (m *historyDBHandler) ListIngestionRuns(source string, limit int) ([]dbdata.IngestionRun, error) {
+	runs := []dbdata.IngestionRun{}
+	for _, run := range m.runs {
+		if (source == "" || run.Source == source) && len(runs) < limit {
+			runs = append(runs, run)
+		}
+	}
+
+	return runs, nil
+}
+
+func (m *historyDBHandler) QuarantineDay(day *dbdata.QuarantinedDay) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	m.quarantined = append(m.quarantined, *day)
+	return nil
+}
+
+func (m *historyDBHandler) ListQuarantinedDays(source string, limit int) ([]dbdata.QuarantinedDay, error) {
+	return m.quarantined, nil
+}
+
+func writeHistoryZIP(t *testing.T, dir string, entries map[string]string) string {
+	file, err := os.Create(filepath.Join(dir, "eurofxref-hist.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	for name, content := range entries {
+		writer, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return file.Name()
+}
+
+func Test_decodeCSVStream(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []dbdata.Envelope
+		wantErr bool
+	}{
+		struct {
+			name    string
+			data    string
+			want    []dbdata.Envelope
+			wantErr bool
+		}{
+			name: "History layout with missing rates",
+			data: mockHistoryCSV,
+			want: []dbdata.Envelope{
+				dbdata.Envelope{SenderName: ecbSenderName, CubeTime: "2020-06-02", Cube: []dbdata.Cube{
+					dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1174")},
+					dbdata.Cube{Currency: "JPY", Rate: decimal.RequireFromString("120.78")},
+				}},
+				dbdata.Envelope{SenderName: ecbSenderName, CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+					dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1136")},
+					dbdata.Cube{Currency: "JPY", Rate: decimal.RequireFromString("120.27")},
+				}},
+			},
+		},
+		struct {
+			name    string
+			data    string
+			want    []dbdata.Envelope
+			wantErr bool
+		}{
+			name:    "Missing Date header",
+			data:    "USD,JPY\n1.1174,120.78\n",
+			wantErr: true,
+		},
+		struct {
+			name    string
+			data    string
+			want    []dbdata.Envelope
+			wantErr bool
+		}{
+			name: "Invalid date is kept for validation",
+			data: "Date,USD\n2020/06/02,1.1174\n2020-06-01,1.1136\n",
+			want: []dbdata.Envelope{
+				dbdata.Envelope{SenderName: ecbSenderName, CubeTime: "2020/06/02", Cube: []dbdata.Cube{
+					dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1174")},
+				}},
+				dbdata.Envelope{SenderName: ecbSenderName, CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+					dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1136")},
+				}},
+			},
+		},
+		struct {
+			name    string
+			data    string
+			want    []dbdata.Envelope
+			wantErr bool
+		}{
+			name: "Invalid rate only marks its day",
+			data: "Date,USD,JPY\n2020-06-02,abc,120.78\n2020-06-01,1.1136,120.27\n",
+			want: []dbdata.Envelope{
+				dbdata.Envelope{SenderName: ecbSenderName, CubeTime: "2020-06-02", Cube: []dbdata.Cube{
+					dbdata.Cube{Currency: "JPY", Rate: decimal.RequireFromString("120.78")},
+				}, Malformed: []string{`invalid rate "abc" of USD`}},
+				dbdata.Envelope{SenderName: ecbSenderName, CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+					dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1136")},
+					dbdata.Cube{Currency: "JPY", Rate: decimal.RequireFromString("120.27")},
+				}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []dbdata.Envelope
+			err := decodeCSVStream(strings.NewReader(tt.data), ecbSenderName, func(envelope dbdata.Envelope) error {
+				got = append(got, envelope)
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeCSVStream() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeCSVStream() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvelope_ImportHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "currency-api-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	xmlData, err := ioutil.ReadFile(mockHistoryXMLPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xmlDays := strings.Count(string(xmlData), "time=")
+
+	csvPath := filepath.Join(dir, "eurofxref-hist.csv")
+	if err := ioutil.WriteFile(csvPath, []byte(mockHistoryCSV), 0600); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := writeHistoryZIP(t, dir, map[string]string{"eurofxref-hist.csv": mockHistoryCSV, "README.txt": "skipped"})
+	zipData, err := ioutil.ReadFile(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyZIPDir := filepath.Join(dir, "empty")
+	if err := os.Mkdir(emptyZIPDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	emptyZIPPath := writeHistoryZIP(t, emptyZIPDir, map[string]string{"README.txt": "skipped"})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/eurofxref-hist.zip":
+			w.Write(zipData)
+		case "/eurofxref-hist.xml":
+			w.Write(xmlData)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		source      string
+		want        int
+		wantBatches int
+		wantErr     bool
+	}{
+		struct {
+			name        string
+			source      string
+			want        int
+			wantBatches int
+			wantErr     bool
+		}{
+			name:        "Local xml file",
+			source:      mockHistoryXMLPath,
+			want:        xmlDays,
+			wantBatches: 1,
+		},
+		struct {
+			name        string
+			source      string
+			want        int
+			wantBatches int
+			wantErr     bool
+		}{
+			name:        "Local csv file",
+			source:      csvPath,
+			want:        2,
+			wantBatches: 1,
+		},
+		struct {
+			name        string
+			source      string
+			want        int
+			wantBatches int
+			wantErr     bool
+		}{
+			name:        "Local zip archive",
+			source:      zipPath,
+			want:        2,
+			wantBatches: 1,
+		},
+		struct {
+			name        string
+			source      string
+			want        int
+			wantBatches int
+			wantErr     bool
+		}{
+			name:        "Downloaded zip archive",
+			source:      server.URL + "/eurofxref-hist.zip",
+			want:        2,
+			wantBatches: 1,
+		},
+		struct {
+			name        string
+			source      string
+			want        int
+			wantBatches int
+			wantErr     bool
+		}{
+			name:        "Downloaded xml file",
+			source:      server.URL + "/eurofxref-hist.xml",
+			want:        xmlDays,
+			wantBatches: 1,
+		},
+		struct {
+			name        string
+			source      string
+			want        int
+			wantBatches int
+			wantErr     bool
+		}{
+			name:    "Download not found",
+			source:  server.URL + "/missing.xml",
+			wantErr: true,
+		},
+		struct {
+			name        string
+			source      string
+			want        int
+			wantBatches int
+			wantErr     bool
+		}{
+			name:    "Missing local file",
+			source:  filepath.Join(dir, "missing.xml"),
+			wantErr: true,
+		},
+		struct {
+			name        string
+			source      string
+			want        int
+			wantBatches int
+			wantErr     bool
+		}{
+			name:    "Zip archive without rates",
+			source:  emptyZIPPath,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbManager := &historyDBHandler{}
+			e := &Envelope{dbManager: dbManager, fetchManager: fetcher.NewManager()}
+			got, err := e.ImportHistory(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Envelope.ImportHistory() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want || len(dbManager.days) != tt.want {
+				t.Errorf("Envelope.ImportHistory() = %v, stored %v, want %v", got, len(dbManager.days), tt.want)
+			}
+			if dbManager.batches != tt.wantBatches {
+				t.Errorf("Envelope.ImportHistory() batches = %v, want %v", dbManager.batches, tt.wantBatches)
+			}
+		})
+	}
+}
+
+func TestEnvelope_ImportHistory_alreadyImported(t *testing.T) {
+	dbManager := &historyDBHandler{}
+	e := &Envelope{dbManager: dbManager, fetchManager: fetcher.NewManager()}
+	if _, err := e.ImportHistory(mockHistoryXMLPath); err != nil {
+		t.Fatalf("Envelope.ImportHistory() error = %v", err)
+	}
+
+	got, err := e.ImportHistory(mockHistoryXMLPath)
+	if err != nil || got != 0 {
+		t.Errorf("Envelope.ImportHistory() = %v, %v, want the imported source skipped", got, err)
+	}
+	if dbManager.batches != 1 || len(dbManager.runs) != 2 {
+		t.Errorf("Envelope.ImportHistory() stored %v batches in %v run saves, want 1 batch of the first import", dbManager.batches, len(dbManager.runs))
+	}
+}
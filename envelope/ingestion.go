@@ -0,0 +1,109 @@
+package envelope
+
+import (
+	"errors"
+	"time"
+
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/entities/jsondata"
+	"github.com/emanpicar/currency-api/fetcher"
+	"github.com/emanpicar/currency-api/logger"
+)
+
+const (
+	// TriggerStartup, TriggerRefresh, TriggerImport, TriggerManual and TriggerUpload record what started an ingestion run
+	TriggerStartup = "startup"
+	TriggerRefresh = "refresh"
+	TriggerImport  = "import"
+	TriggerManual  = "manual"
+	TriggerUpload  = "upload"
+
+	// IngestionRunning, IngestionSucceeded, IngestionNotModified and IngestionFailed are the states of an ingestion run
+	IngestionRunning     = "running"
+	IngestionSucceeded   = "succeeded"
+	IngestionNotModified = "not_modified"
+	IngestionFailed      = "failed"
+
+	defaultIngestionRunLimit = 50
+	maxIngestionRunLimit     = 500
+)
+
+// ingest stores the days emitted by decode under run.Source and records run in the ingestion history.
+// An upstream without changes is a successful run. Failing to record the run is only logged, it never fails the ingestion itself.
+// Ingestions run one at a time, a second one waits for the first to finish.
+func (e *Envelope) ingest(run *dbdata.IngestionRun, decode func(emit func(dbdata.Envelope) error, flush func() error) error) error {
+	e.ingestMutex.Lock()
+	defer e.ingestMutex.Unlock()
+
+	run.StartedAt = time.Now()
+	run.Status = IngestionRunning
+	e.saveIngestionRun(run)
+
+	err := e.storeDays(run, decode)
+
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	switch {
+	case errors.Is(err, fetcher.ErrNotModified):
+		run.Status = IngestionNotModified
+		err = nil
+	case err != nil:
+		run.Status = IngestionFailed
+		run.Error = err.Error()
+	default:
+		run.Status = IngestionSucceeded
+	}
+	e.saveIngestionRun(run)
+
+	return err
+}
+
+func (e *Envelope) saveIngestionRun(run *dbdata.IngestionRun) {
+	if err := e.dbManager.SaveIngestionRun(run); err != nil {
+		logger.Log.Errorf("Unable to save ingestion run of %v: %v", run.Source, err)
+	}
+}
+
+// ListIngestionRuns returns the latest runs first, of every source when source is empty.
+// limit defaults to 50 and is capped at 500.
+func (e *Envelope) ListIngestionRuns(source string, limit int) ([]jsondata.IngestionRun, error) {
+	if limit <= 0 {
+		limit = defaultIngestionRunLimit
+	}
+	if limit > maxIngestionRunLimit {
+		limit = maxIngestionRunLimit
+	}
+
+	runs, err := e.dbManager.ListIngestionRuns(source, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []jsondata.IngestionRun{}
+	for _, run := range runs {
+		result = append(result, toJSONIngestionRun(run))
+	}
+
+	return result, nil
+}
+
+func toJSONIngestionRun(run dbdata.IngestionRun) jsondata.IngestionRun {
+	return jsondata.IngestionRun{
+		ID:            run.ID,
+		Source:        run.Source,
+		Trigger:       run.Trigger,
+		Location:      run.Location,
+		Status:        run.Status,
+		StartedAt:     run.StartedAt,
+		FinishedAt:    run.FinishedAt,
+		UsedDemoData:  run.UsedDemoData,
+		UsedSnapshot:  run.UsedSnapshot,
+		DaysSeen:      run.DaysSeen,
+		DaysInserted:  run.DaysInserted,
+		DaysUpdated:   run.DaysUpdated,
+		RatesInserted: run.CubesInserted,
+		RatesUpdated:  run.CubesUpdated,
+		DaysRejected:  run.DaysRejected,
+		Error:         run.Error,
+	}
+}
@@ -0,0 +1,211 @@
+package envelope
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emanpicar/currency-api/apperror"
+	"github.com/emanpicar/currency-api/decimal"
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/entities/jsondata"
+)
+
+func TestEnvelope_ingest(t *testing.T) {
+	decodeErr := errors.New("unexpected EOF")
+
+	tests := []struct {
+		name       string
+		decodeErr  error
+		wantStatus string
+		wantError  string
+	}{
+		struct {
+			name       string
+			decodeErr  error
+			wantStatus string
+			wantError  string
+		}{
+			name:       "Successful run",
+			wantStatus: IngestionSucceeded,
+		},
+		struct {
+			name       string
+			decodeErr  error
+			wantStatus string
+			wantError  string
+		}{
+			name:       "Failed run",
+			decodeErr:  decodeErr,
+			wantStatus: IngestionFailed,
+			wantError:  decodeErr.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbManager := &historyDBHandler{}
+			e := &Envelope{dbManager: dbManager}
+			run := &dbdata.IngestionRun{Source: ECBSource, Trigger: TriggerRefresh, Location: "eurofxref-daily.xml"}
+
+			err := e.ingest(run, func(emit func(dbdata.Envelope) error, flush func() error) error {
+				emit(mockValidDay)
+				return tt.decodeErr
+			})
+			if err != tt.decodeErr {
+				t.Errorf("Envelope.ingest() error = %v, want %v", err, tt.decodeErr)
+			}
+
+			if len(dbManager.runs) != 2 {
+				t.Fatalf("Envelope.ingest() saved %v runs, want 2", len(dbManager.runs))
+			}
+			if started := dbManager.runs[0]; started.Status != IngestionRunning || started.StartedAt.IsZero() || started.FinishedAt != nil {
+				t.Errorf("Envelope.ingest() started run = %+v, want running without finish time", started)
+			}
+			finished := dbManager.runs[1]
+			if finished.Status != tt.wantStatus || finished.Error != tt.wantError || finished.FinishedAt == nil {
+				t.Errorf("Envelope.ingest() finished run status = %v, error = %v, want %v, %v", finished.Status, finished.Error, tt.wantStatus, tt.wantError)
+			}
+			if finished.DaysSeen != 1 || finished.DaysInserted != 1 {
+				t.Errorf("Envelope.ingest() finished run saw %v, inserted %v days, want 1, 1", finished.DaysSeen, finished.DaysInserted)
+			}
+		})
+	}
+}
+
+func TestEnvelope_ListIngestionRuns(t *testing.T) {
+	runs := []dbdata.IngestionRun{}
+	for index := 0; index < maxIngestionRunLimit+1; index++ {
+		runs = append(runs, dbdata.IngestionRun{Source: ECBSource, Status: IngestionSucceeded, CubesInserted: index})
+	}
+	runs = append(runs, dbdata.IngestionRun{Source: "boc", Status: IngestionFailed, Error: "connection refused"})
+
+	tests := []struct {
+		name   string
+		source string
+		limit  int
+		want   int
+	}{
+		struct {
+			name   string
+			source string
+			limit  int
+			want   int
+		}{
+			name:  "Default limit",
+			limit: 0,
+			want:  defaultIngestionRunLimit,
+		},
+		struct {
+			name   string
+			source string
+			limit  int
+			want   int
+		}{
+			name:  "Capped limit",
+			limit: maxIngestionRunLimit + 100,
+			want:  maxIngestionRunLimit,
+		},
+		struct {
+			name   string
+			source string
+			limit  int
+			want   int
+		}{
+			name:   "Single source",
+			source: "boc",
+			limit:  10,
+			want:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Envelope{dbManager: &historyDBHandler{runs: runs}}
+			got, err := e.ListIngestionRuns(tt.source, tt.limit)
+			if err != nil {
+				t.Fatalf("Envelope.ListIngestionRuns() error = %v", err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("Envelope.ListIngestionRuns() = %v runs, want %v", len(got), tt.want)
+			}
+			for _, run := range got {
+				if tt.source != "" && run.Source != tt.source {
+					t.Errorf("Envelope.ListIngestionRuns() source = %v, want %v", run.Source, tt.source)
+				}
+			}
+		})
+	}
+}
+
+func TestEnvelope_RefreshNow(t *testing.T) {
+	ecbDay := dbdata.Envelope{SenderName: ecbSenderName, CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+		dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1136")},
+	}}
+
+	dbManager := &historyDBHandler{}
+	e := &Envelope{dbManager: dbManager, source: ECBSource, providers: []Provider{
+		&providerMock{name: ECBSource, days: []dbdata.Envelope{ecbDay}},
+		&providerMock{name: "feed", err: errors.New("connection refused")},
+	}}
+
+	got, err := e.RefreshNow()
+	appErr := apperror.As(err)
+	if got != nil || appErr.Code != "refresh_failed" {
+		t.Fatalf("Envelope.RefreshNow() = %v, error = %v, want refresh_failed", got, err)
+	}
+
+	runs, ok := appErr.Details["runs"].([]jsondata.IngestionRun)
+	if !ok || len(runs) != 2 {
+		t.Fatalf("Envelope.RefreshNow() details = %v, want 2 runs", appErr.Details)
+	}
+	if runs[0].Trigger != TriggerManual || runs[0].Status != IngestionSucceeded || runs[0].DaysInserted != 1 {
+		t.Errorf("Envelope.RefreshNow() ecb run = %+v, want succeeded manual run with 1 day", runs[0])
+	}
+	if runs[1].Status != IngestionFailed || runs[1].Error != "connection refused" {
+		t.Errorf("Envelope.RefreshNow() feed run = %+v, want failed with connection refused", runs[1])
+	}
+	if e.GetLastRefresh().IsZero() {
+		t.Errorf("Envelope.RefreshNow() last refresh not set")
+	}
+}
+
+func TestEnvelope_RefreshNow_inProgress(t *testing.T) {
+	ecbDay := dbdata.Envelope{SenderName: ecbSenderName, CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+		dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1136")},
+	}}
+
+	dbManager := &historyDBHandler{}
+	provider := &providerMock{name: ECBSource, days: []dbdata.Envelope{ecbDay}}
+	e := &Envelope{dbManager: dbManager, source: ECBSource, providers: []Provider{provider}}
+
+	// The scheduled refresh waits for the ingestion holding the mutex, admin requests meanwhile are refused
+	e.ingestMutex.Lock()
+	refreshed := make(chan error)
+	go func() {
+		refreshed <- e.RefreshRates()
+	}()
+	for atomic.LoadInt32(&e.refreshing) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	got, err := e.RefreshNow()
+	if appErr := apperror.As(err); got != nil || appErr.Kind != apperror.Conflict {
+		t.Fatalf("Envelope.RefreshNow() = %v, error = %v, want a conflict", got, err)
+	}
+	if _, err := e.UploadRates(strings.NewReader(mockUploadXML), "xml", ""); apperror.As(err).Kind != apperror.Conflict {
+		t.Errorf("Envelope.UploadRates() error = %v, want a conflict", err)
+	}
+
+	e.ingestMutex.Unlock()
+	if err := <-refreshed; err != nil {
+		t.Errorf("Envelope.RefreshRates() error = %v, want the scheduled refresh run once the mutex is free", err)
+	}
+	if len(dbManager.runs) != 2 || dbManager.runs[1].Trigger != TriggerRefresh {
+		t.Errorf("Envelope.RefreshRates() recorded %+v, want only the scheduled run", dbManager.runs)
+	}
+
+	if _, err := e.RefreshNow(); err != nil {
+		t.Errorf("Envelope.RefreshNow() error = %v once the running refresh completed", err)
+	}
+}
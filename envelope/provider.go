@@ -0,0 +1,227 @@
+package envelope
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/emanpicar/currency-api/decimal"
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/fetcher"
+	"github.com/emanpicar/currency-api/logger"
+	"github.com/emanpicar/currency-api/settings"
+)
+
+// ECBSource is the name of the European Central Bank provider and the default source of served rates
+const ECBSource = "ecb"
+
+type (
+	// Provider fetches the rates of one upstream source
+	Provider interface {
+		// Name is stored as the source of every day the provider fetches
+		Name() string
+		// Location is the URL or file fetched, recorded in the ingestion history
+		Location() string
+		// Fetch emits the upstream days one at a time, normalized to rates quoted against EUR, and flushes them
+		// before the download counts as consumed. It returns fetcher.ErrNotModified when the upstream has nothing new.
+		Fetch(emit func(dbdata.Envelope) error, flush func() error) error
+	}
+
+	// ecbProvider reads the ECB eurofxref xml feed and keeps the last stored download at snapshotPath
+	ecbProvider struct {
+		url          string
+		snapshotPath string
+		fetchManager fetcher.Manager
+	}
+
+	// feedProvider reads an xml, csv, json or zip feed from a URL or a local file
+	feedProvider struct {
+		name         string
+		source       string
+		format       string
+		fetchManager fetcher.Manager
+	}
+
+	// jsonDay is one day of a json feed, rates are quoted against Base, EUR when empty
+	jsonDay struct {
+		Date  string                     `json:"date"`
+		Base  string                     `json:"base"`
+		Rates map[string]json.RawMessage `json:"rates"`
+	}
+)
+
+// newProviders builds the providers listed in RATE_PROVIDERS, skipping those without a configured URL
+func newProviders(fetchManager fetcher.Manager, snapshotDir string) []Provider {
+	providers := []Provider{}
+
+	for _, name := range settings.GetRateProviders() {
+		name = strings.ToLower(name)
+		if name == ECBSource {
+			providers = append(providers, &ecbProvider{url: settings.GetXMLDataURLPath(), snapshotPath: snapshotPath(snapshotDir, ECBSource), fetchManager: fetchManager})
+			continue
+		}
+
+		source := settings.GetProviderURL(name)
+		if source == "" {
+			logger.Log.Warnf("Rate provider %v has no URL configured and is skipped", name)
+			continue
+		}
+		providers = append(providers, &feedProvider{name: name, source: source, format: settings.GetProviderFormat(name), fetchManager: fetchManager})
+	}
+
+	return providers
+}
+
+func (p *ecbProvider) Name() string {
+	return ECBSource
+}
+
+func (p *ecbProvider) Location() string {
+	return p.url
+}
+
+// Fetch replaces the snapshot once the whole download is decoded and stored, failing to do so is only logged
+func (p *ecbProvider) Fetch(emit func(dbdata.Envelope) error, flush func() error) error {
+	return p.fetchManager.Fetch(p.url, func(body io.Reader, size int64) error {
+		if p.snapshotPath == "" {
+			return decodeAndFlush(body, size, emit, flush)
+		}
+
+		snapshot, err := newSnapshotWriter(p.snapshotPath)
+		if err != nil {
+			logger.Log.Warnf("Unable to create snapshot %v: %v", p.snapshotPath, err)
+			return decodeAndFlush(body, size, emit, flush)
+		}
+		defer snapshot.discard()
+
+		reader := io.TeeReader(body, snapshot)
+		if err := decodeXML(reader, size, emit); err != nil {
+			return err
+		}
+		// The decoder may stop before the closing tags
+		if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+			return err
+		}
+
+		// A download that could not be stored never replaces the last known good snapshot
+		if err := flush(); err != nil {
+			return err
+		}
+		if err := snapshot.commit(); err != nil {
+			logger.Log.Warnf("Unable to save snapshot %v: %v", p.snapshotPath, err)
+		}
+		return nil
+	})
+}
+
+func decodeAndFlush(body io.Reader, size int64, emit func(dbdata.Envelope) error, flush func() error) error {
+	if err := decodeXML(body, size, emit); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+func (p *feedProvider) Name() string {
+	return p.name
+}
+
+func (p *feedProvider) Location() string {
+	return p.source
+}
+
+func (p *feedProvider) Fetch(emit func(dbdata.Envelope) error, flush func() error) error {
+	return readSource(p.fetchManager, p.source, p.format, p.name, emit, flush)
+}
+
+// decodeJSONStream reads a single jsonDay or an array of them, decoding one day at a time
+func decodeJSONStream(reader io.Reader, senderName string, emit func(dbdata.Envelope) error) error {
+	buffered := bufio.NewReader(reader)
+	first, err := buffered.ReadByte()
+	for err == nil && strings.TrimSpace(string(first)) == "" {
+		first, err = buffered.ReadByte()
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to read json data: %v", err)
+	}
+	buffered.UnreadByte()
+
+	decoder := json.NewDecoder(buffered)
+	emitDay := func() error {
+		day := jsonDay{}
+		if err := decoder.Decode(&day); err != nil {
+			return fmt.Errorf("Unable to parse json data: %v", err)
+		}
+
+		envelope, err := normalizeJSONDay(day, senderName)
+		if err != nil {
+			return err
+		}
+
+		return emit(envelope)
+	}
+
+	if first != '[' {
+		return emitDay()
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("Unable to parse json data: %v", err)
+	}
+	for decoder.More() {
+		if err := emitDay(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// normalizeJSONDay rebases the rates of day to EUR, which then needs a rate itself unless it is the base
+func normalizeJSONDay(day jsonDay, senderName string) (dbdata.Envelope, error) {
+	cubeTime := normalizeFeedDate(day.Date)
+	base := strings.ToUpper(day.Base)
+
+	// Rates are parsed one by one so a malformed rate only rejects its day
+	parsed := dbdata.Envelope{}
+	for currency, raw := range day.Rates {
+		value := string(raw)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		appendRate(&parsed, strings.ToUpper(currency), value)
+	}
+	rates := ratesOf(parsed)
+
+	if base != "" && base != BaseCurrency {
+		eurRate, ok := rates[BaseCurrency]
+		if !ok || eurRate.Sign() <= 0 {
+			return dbdata.Envelope{}, fmt.Errorf("Unable to normalize %v rates on %v without an %v rate", base, cubeTime, BaseCurrency)
+		}
+
+		delete(rates, BaseCurrency)
+		for currency, rate := range rates {
+			rates[currency] = rate.Div(eurRate)
+		}
+		rates[base] = decimal.New(1).Div(eurRate)
+	}
+
+	currencies := []string{}
+	for currency := range rates {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	envelope := dbdata.Envelope{SenderName: senderName, CubeTime: cubeTime, Cube: []dbdata.Cube{}, Malformed: parsed.Malformed}
+	sort.Strings(envelope.Malformed)
+	for _, currency := range currencies {
+		envelope.Cube = append(envelope.Cube, dbdata.Cube{Currency: currency, Rate: rates[currency]})
+	}
+
+	return envelope, nil
+}
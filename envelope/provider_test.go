@@ -0,0 +1,325 @@
+package envelope
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emanpicar/currency-api/decimal"
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/fetcher"
+)
+
+type (
+	// providerMock emits its days or fails with err
+	providerMock struct {
+		name string
+		days []dbdata.Envelope
+		err  error
+	}
+)
+
+func (p *providerMock) Name() string {
+	return p.name
+}
+
+func (p *providerMock) Location() string {
+	return "mock://" + p.name
+}
+
+func (p *providerMock) Fetch(emit func(dbdata.Envelope) error, flush func() error) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	for _, day := range p.days {
+		if err := emit(day); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func Test_decodeJSONStream(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []dbdata.Envelope
+		wantErr bool
+	}{
+		struct {
+			name    string
+			data    string
+			want    []dbdata.Envelope
+			wantErr bool
+		}{
+			name: "Single EUR day",
+			data: ` {"date": "2020-06-01", "rates": {"usd": 1.1136, "JPY": 120.27}}`,
+			want: []dbdata.Envelope{
+				dbdata.Envelope{SenderName: "feed", CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+					dbdata.Cube{Currency: "JPY", Rate: decimal.RequireFromString("120.27")},
+					dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1136")},
+				}},
+			},
+		},
+		struct {
+			name    string
+			data    string
+			want    []dbdata.Envelope
+			wantErr bool
+		}{
+			name: "Array of days rebased to EUR",
+			data: `[
+				{"date": "2020-06-01", "base": "USD", "rates": {"EUR": 0.5, "GBP": 0.25}},
+				{"date": "2020-06-02", "base": "EUR", "rates": {"USD": 2}}
+			]`,
+			want: []dbdata.Envelope{
+				dbdata.Envelope{SenderName: "feed", CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+					dbdata.Cube{Currency: "GBP", Rate: decimal.RequireFromString("0.5")},
+					dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("2")},
+				}},
+				dbdata.Envelope{SenderName: "feed", CubeTime: "2020-06-02", Cube: []dbdata.Cube{
+					dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("2")},
+				}},
+			},
+		},
+		struct {
+			name    string
+			data    string
+			want    []dbdata.Envelope
+			wantErr bool
+		}{
+			name:    "Other base without an EUR rate",
+			data:    `{"date": "2020-06-01", "base": "USD", "rates": {"GBP": 0.25}}`,
+			wantErr: true,
+		},
+		struct {
+			name    string
+			data    string
+			want    []dbdata.Envelope
+			wantErr bool
+		}{
+			name: "Invalid date and rate are kept for validation",
+			data: `[{"date": "June 1st", "rates": {"USD": 1.1}}, {"date": "2020-06-02", "rates": {"USD": "n/a", "JPY": "120.78"}}]`,
+			want: []dbdata.Envelope{
+				dbdata.Envelope{SenderName: "feed", CubeTime: "June 1st", Cube: []dbdata.Cube{
+					dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1")},
+				}},
+				dbdata.Envelope{SenderName: "feed", CubeTime: "2020-06-02", Cube: []dbdata.Cube{
+					dbdata.Cube{Currency: "JPY", Rate: decimal.RequireFromString("120.78")},
+				}, Malformed: []string{`invalid rate "n/a" of USD`}},
+			},
+		},
+		struct {
+			name    string
+			data    string
+			want    []dbdata.Envelope
+			wantErr bool
+		}{
+			name:    "Empty document",
+			data:    "  ",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []dbdata.Envelope
+			err := decodeJSONStream(strings.NewReader(tt.data), "feed", func(envelope dbdata.Envelope) error {
+				got = append(got, envelope)
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeJSONStream() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeJSONStream() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvelope_RefreshRates(t *testing.T) {
+	file, err := ioutil.TempFile("", "currency-api-feed-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`[{"date": "2020-06-01", "rates": {"USD": 1.1136}}]`)
+	file.Close()
+
+	ecbDay := dbdata.Envelope{SenderName: ecbSenderName, CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+		dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1136")},
+	}}
+
+	tests := []struct {
+		name            string
+		providers       []Provider
+		wantSources     []string
+		wantLastRefresh bool
+		wantErr         bool
+	}{
+		struct {
+			name            string
+			providers       []Provider
+			wantSources     []string
+			wantLastRefresh bool
+			wantErr         bool
+		}{
+			name: "Every provider stores under its own source",
+			providers: []Provider{
+				&providerMock{name: ECBSource, days: []dbdata.Envelope{ecbDay}},
+				&feedProvider{name: "feed", source: file.Name()},
+			},
+			wantSources:     []string{ECBSource, "feed"},
+			wantLastRefresh: true,
+		},
+		struct {
+			name            string
+			providers       []Provider
+			wantSources     []string
+			wantLastRefresh bool
+			wantErr         bool
+		}{
+			name: "Failing provider does not stop the others",
+			providers: []Provider{
+				&providerMock{name: "feed", err: errors.New("connection refused")},
+				&providerMock{name: ECBSource, days: []dbdata.Envelope{ecbDay}},
+			},
+			wantSources:     []string{ECBSource},
+			wantLastRefresh: true,
+			wantErr:         true,
+		},
+		struct {
+			name            string
+			providers       []Provider
+			wantSources     []string
+			wantLastRefresh bool
+			wantErr         bool
+		}{
+			name: "Failing served provider keeps the last refresh",
+			providers: []Provider{
+				&providerMock{name: ECBSource, err: errors.New("connection refused")},
+				&feedProvider{name: "feed", source: file.Name()},
+			},
+			wantSources: []string{"feed"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbManager := &historyDBHandler{}
+			e := &Envelope{dbManager: dbManager, providers: tt.providers, source: ECBSource}
+			err := e.RefreshRates()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Envelope.RefreshRates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			sources := []string{}
+			for _, day := range dbManager.days {
+				sources = append(sources, day.Source)
+			}
+			if !reflect.DeepEqual(sources, tt.wantSources) {
+				t.Errorf("Envelope.RefreshRates() sources = %v, want %v", sources, tt.wantSources)
+			}
+			if e.GetLastRefresh().IsZero() == tt.wantLastRefresh {
+				t.Errorf("Envelope.RefreshRates() last refresh = %v, want set %v", e.GetLastRefresh(), tt.wantLastRefresh)
+			}
+		})
+	}
+}
+
+func TestEnvelope_RefreshRates_notModified(t *testing.T) {
+	data, err := ioutil.ReadFile(mockHistoryXMLPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stand-in for the ECB server, answering 304 once the client knows the current version
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"2020-06-02"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"2020-06-02"`)
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	dbManager := &historyDBHandler{}
+	fetchManager := fetcher.NewManager()
+	e := &Envelope{dbManager: dbManager, fetchManager: fetchManager, source: ECBSource, providers: []Provider{
+		&ecbProvider{url: server.URL + "/eurofxref-hist-90d.xml", fetchManager: fetchManager},
+	}}
+
+	for refresh := 0; refresh < 2; refresh++ {
+		if err := e.RefreshRates(); err != nil {
+			t.Fatalf("Envelope.RefreshRates() error = %v", err)
+		}
+	}
+
+	statuses := []string{}
+	for _, run := range dbManager.runs {
+		if run.Status != IngestionRunning {
+			statuses = append(statuses, run.Status)
+		}
+	}
+	if !reflect.DeepEqual(statuses, []string{IngestionSucceeded, IngestionNotModified}) {
+		t.Errorf("Envelope.RefreshRates() statuses = %v, want %v", statuses, []string{IngestionSucceeded, IngestionNotModified})
+	}
+	if dbManager.batches != 1 || dbManager.runs[len(dbManager.runs)-1].DaysSeen != 0 {
+		t.Errorf("Envelope.RefreshRates() stored %v batches, want only the first download stored", dbManager.batches)
+	}
+	if e.GetLastRefresh().IsZero() {
+		t.Errorf("Envelope.RefreshRates() last refresh not set")
+	}
+}
+
+func TestEnvelope_RefreshRates_failedFlush(t *testing.T) {
+	data, err := ioutil.ReadFile(mockHistoryXMLPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"2020-06-02"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"2020-06-02"`)
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	fetchManager := fetcher.NewManager()
+	providers := []Provider{
+		&ecbProvider{url: server.URL + "/eurofxref-hist-90d.xml", fetchManager: fetchManager},
+		&feedProvider{name: "feed", source: server.URL + "/feed.xml", format: "xml", fetchManager: fetchManager},
+	}
+	for _, provider := range providers {
+		t.Run(provider.Name(), func(t *testing.T) {
+			// The whole download fits one batch, flushed only after it is decoded
+			dbManager := &historyDBHandler{err: errors.New("connection reset")}
+			e := &Envelope{dbManager: dbManager, fetchManager: fetchManager, source: ECBSource, providers: []Provider{provider}}
+			if err := e.RefreshRates(); err == nil {
+				t.Fatalf("Envelope.RefreshRates() error = nil, want the failed flush")
+			}
+
+			dbManager.err = nil
+			if err := e.RefreshRates(); err != nil {
+				t.Fatalf("Envelope.RefreshRates() error = %v", err)
+			}
+
+			last := dbManager.runs[len(dbManager.runs)-1]
+			if last.Status != IngestionSucceeded || len(dbManager.days) == 0 {
+				t.Errorf("Envelope.RefreshRates() status = %v with %v days stored, want the download fetched again", last.Status, len(dbManager.days))
+			}
+		})
+	}
+}
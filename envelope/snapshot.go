@@ -0,0 +1,130 @@
+package envelope
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/logger"
+)
+
+const (
+	// ProvenanceLive, ProvenanceSnapshot and ProvenanceDemo tell where the served rates were last loaded from.
+	// ProvenanceNone is degraded mode: nothing could be loaded since startup and only previously stored rates are served.
+	ProvenanceLive     = "live"
+	ProvenanceSnapshot = "snapshot"
+	ProvenanceDemo     = "demo"
+	ProvenanceNone     = "none"
+)
+
+type (
+	// snapshotWriter copies a download into a temporary file next to path, moved onto path by commit.
+	// Write errors are kept for commit so a failing snapshot never fails the download itself.
+	snapshotWriter struct {
+		file *os.File
+		path string
+		err  error
+	}
+)
+
+func snapshotPath(snapshotDir, source string) string {
+	return filepath.Join(snapshotDir, source+".xml")
+}
+
+func newSnapshotWriter(path string) (*snapshotWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshotWriter{file: file, path: path}, nil
+}
+
+func (s *snapshotWriter) Write(p []byte) (int, error) {
+	if s.err == nil {
+		_, s.err = s.file.Write(p)
+	}
+
+	return len(p), nil
+}
+
+// commit replaces the previous snapshot, a failed write leaves it untouched
+func (s *snapshotWriter) commit() error {
+	if err := s.file.Close(); err != nil && s.err == nil {
+		s.err = err
+	}
+	if s.err != nil {
+		os.Remove(s.file.Name())
+		return s.err
+	}
+
+	return os.Rename(s.file.Name(), s.path)
+}
+
+// discard removes the temporary file unless it was committed
+func (s *snapshotWriter) discard() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// useFallbackData loads the ECB snapshot, or the demo data when there is none.
+// Without either the service keeps serving the stored rates in degraded mode.
+func (e *Envelope) useFallbackData() {
+	fallbacks := []struct {
+		provenance string
+		path       string
+	}{
+		{provenance: ProvenanceSnapshot, path: snapshotPath(e.snapshotDir, ECBSource)},
+		{provenance: ProvenanceDemo, path: e.demoDataPath},
+	}
+
+	for _, fallback := range fallbacks {
+		if err := e.loadFallback(fallback.provenance, fallback.path); err != nil {
+			logger.Log.Warnf("Unable to load %v data from %v: %v", fallback.provenance, fallback.path, err)
+			continue
+		}
+
+		logger.Log.Warnf("Currently using %v data from %v", fallback.provenance, fallback.path)
+		return
+	}
+
+	logger.Log.Errorf("No snapshot or demo data available, serving stored rates in degraded mode")
+}
+
+func (e *Envelope) loadFallback(provenance, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	size := int64(-1)
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+
+	run := &dbdata.IngestionRun{
+		Source:       ECBSource,
+		Trigger:      TriggerStartup,
+		Location:     path,
+		UsedDemoData: provenance == ProvenanceDemo,
+		UsedSnapshot: provenance == ProvenanceSnapshot,
+	}
+	err = e.ingest(run, func(emit func(dbdata.Envelope) error, flush func() error) error {
+		return decodeXML(file, size, emit)
+	})
+	if err != nil {
+		return err
+	}
+
+	if e.source == ECBSource {
+		e.setProvenance(provenance)
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package envelope
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/fetcher"
+)
+
+func TestEnvelope_UpsertInitialData(t *testing.T) {
+	data, err := ioutil.ReadFile(mockHistoryXMLPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed := []byte("<gesmes:Envelope><Cube>")
+
+	tests := []struct {
+		name           string
+		status         int
+		body           []byte
+		snapshot       []byte
+		demoData       bool
+		wantProvenance string
+		wantSnapshot   []byte
+		wantDays       bool
+	}{
+		struct {
+			name           string
+			status         int
+			body           []byte
+			snapshot       []byte
+			demoData       bool
+			wantProvenance string
+			wantSnapshot   []byte
+			wantDays       bool
+		}{
+			name:           "Live download replaces the snapshot",
+			status:         http.StatusOK,
+			body:           data,
+			snapshot:       []byte("previous"),
+			demoData:       true,
+			wantProvenance: ProvenanceLive,
+			wantSnapshot:   data,
+			wantDays:       true,
+		},
+		struct {
+			name           string
+			status         int
+			body           []byte
+			snapshot       []byte
+			demoData       bool
+			wantProvenance string
+			wantSnapshot   []byte
+			wantDays       bool
+		}{
+			name:           "Malformed download keeps and loads the snapshot",
+			status:         http.StatusOK,
+			body:           malformed,
+			snapshot:       data,
+			demoData:       true,
+			wantProvenance: ProvenanceSnapshot,
+			wantSnapshot:   data,
+			wantDays:       true,
+		},
+		struct {
+			name           string
+			status         int
+			body           []byte
+			snapshot       []byte
+			demoData       bool
+			wantProvenance string
+			wantSnapshot   []byte
+			wantDays       bool
+		}{
+			name:           "Failed download without snapshot loads demo data",
+			status:         http.StatusNotFound,
+			demoData:       true,
+			wantProvenance: ProvenanceDemo,
+			wantDays:       true,
+		},
+		struct {
+			name           string
+			status         int
+			body           []byte
+			snapshot       []byte
+			demoData       bool
+			wantProvenance string
+			wantSnapshot   []byte
+			wantDays       bool
+		}{
+			name:           "Nothing available serves in degraded mode",
+			status:         http.StatusNotFound,
+			wantProvenance: ProvenanceNone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write(tt.body)
+			}))
+			defer server.Close()
+
+			dir, err := ioutil.TempDir("", "currency-api-snapshots")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := snapshotPath(dir, ECBSource)
+			if tt.snapshot != nil {
+				ioutil.WriteFile(path, tt.snapshot, 0644)
+			}
+			demoDataPath := filepath.Join(dir, "missing-demo.xml")
+			if tt.demoData {
+				demoDataPath = mockHistoryXMLPath
+			}
+
+			dbManager := &historyDBHandler{}
+			fetchManager := fetcher.NewManager()
+			e := &Envelope{
+				dbManager:    dbManager,
+				fetchManager: fetchManager,
+				providers:    []Provider{&ecbProvider{url: server.URL, snapshotPath: path, fetchManager: fetchManager}},
+				source:       ECBSource,
+				snapshotDir:  dir,
+				demoDataPath: demoDataPath,
+			}
+			e.UpsertInitialData()
+
+			if got := e.GetProvenance(); got != tt.wantProvenance {
+				t.Errorf("Envelope.UpsertInitialData() provenance = %v, want %v", got, tt.wantProvenance)
+			}
+			if (len(dbManager.days) > 0) != tt.wantDays {
+				t.Errorf("Envelope.UpsertInitialData() stored %v days, want days %v", len(dbManager.days), tt.wantDays)
+			}
+
+			snapshot, _ := ioutil.ReadFile(path)
+			if !bytes.Equal(snapshot, tt.wantSnapshot) {
+				t.Errorf("Envelope.UpsertInitialData() snapshot of %v bytes, want %v bytes", len(snapshot), len(tt.wantSnapshot))
+			}
+			if files, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(files) > 0 {
+				t.Errorf("Envelope.UpsertInitialData() left temporary files %v", files)
+			}
+		})
+	}
+}
+
+func Test_ecbProvider_Fetch_failedFlush(t *testing.T) {
+	data, err := ioutil.ReadFile(mockHistoryXMLPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "currency-api-snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := snapshotPath(dir, ECBSource)
+	lastKnownGood := []byte("<gesmes:Envelope/>")
+	ioutil.WriteFile(path, lastKnownGood, 0644)
+
+	flushErr := errors.New("connection reset")
+	provider := &ecbProvider{url: server.URL, snapshotPath: path, fetchManager: fetcher.NewManager()}
+	err = provider.Fetch(func(dbdata.Envelope) error {
+		return nil
+	}, func() error {
+		return flushErr
+	})
+	if err != flushErr {
+		t.Errorf("ecbProvider.Fetch() error = %v, want %v", err, flushErr)
+	}
+
+	if snapshot, _ := ioutil.ReadFile(path); !bytes.Equal(snapshot, lastKnownGood) {
+		t.Errorf("ecbProvider.Fetch() replaced the snapshot with %v bytes after a failed flush", len(snapshot))
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(files) > 0 {
+		t.Errorf("ecbProvider.Fetch() left temporary files %v", files)
+	}
+}
@@ -0,0 +1,162 @@
+package envelope
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/emanpicar/currency-api/decimal"
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/entities/xmldata"
+	"github.com/emanpicar/currency-api/logger"
+	"github.com/emanpicar/currency-api/settings"
+)
+
+// ingestBatchSize bounds how many days are held in memory before being written
+const ingestBatchSize = 250
+
+// storeDays upserts the days emitted by decode under the source of run in batches and counts them in run.
+// Days failing validation are quarantined or rejected instead. Batches written before an error are kept,
+// a database error stops decoding. decode may flush the days emitted so far, e.g. before reporting a download consumed,
+// the remaining ones are flushed once it returns.
+func (e *Envelope) storeDays(run *dbdata.IngestionRun, decode func(emit func(dbdata.Envelope) error, flush func() error) error) error {
+	batch := []dbdata.Envelope{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		summary, err := e.dbManager.BatchUpsert(&batch)
+		if summary != nil {
+			run.DaysInserted += summary.DaysCreated
+			run.DaysUpdated += summary.DaysUpdated
+			run.CubesInserted += summary.CubesCreated
+			run.CubesUpdated += summary.CubesUpdated
+		}
+		batch = []dbdata.Envelope{}
+		if err != nil {
+			return err
+		}
+
+		logger.Log.Infof("Stored %v days of %v rates: %v new days, %v rates added, %v rates corrected",
+			run.DaysSeen, run.Source, run.DaysInserted, run.CubesInserted, run.CubesUpdated)
+		return nil
+	}
+
+	validator := e.newDayValidator(run.Source)
+	err := decode(func(envelope dbdata.Envelope) error {
+		envelope.Source = run.Source
+		run.DaysSeen++
+		if reasons := validator.validate(envelope); len(reasons) > 0 {
+			return e.rejectDay(run, validator.rules, envelope, reasons)
+		}
+
+		batch = append(batch, envelope)
+		if len(batch) >= ingestBatchSize {
+			return flush()
+		}
+		return nil
+	}, flush)
+	if flushErr := flush(); err == nil {
+		err = flushErr
+	}
+	if err == nil && run.DaysSeen == 0 && validator.rules.enabled {
+		err = errEmptyDocument
+	}
+
+	return err
+}
+
+// decodeXML emits the days of an ECB xml document. Documents known to be smaller than
+// XML_STREAMING_THRESHOLD are unmarshalled at once, everything else is streamed. size is -1 when unknown.
+func decodeXML(reader io.Reader, size int64, emit func(dbdata.Envelope) error) error {
+	if size >= 0 && size < int64(settings.GetXMLStreamingThreshold()) {
+		return unmarshalXML(reader, emit)
+	}
+
+	return decodeXMLStream(reader, emit)
+}
+
+func unmarshalXML(reader io.Reader, emit func(dbdata.Envelope) error) error {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("Unable to read xml data: %v", err)
+	}
+
+	env := &xmldata.Envelope{}
+	if err := xml.Unmarshal(data, env); err != nil {
+		return fmt.Errorf("Unable to parse xml data: %v", err)
+	}
+
+	for _, envelope := range convertXMLtoDBEntities(env) {
+		if err := emit(envelope); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeXMLStream decodes one day Cube at a time instead of unmarshalling the whole envelope
+func decodeXMLStream(reader io.Reader, emit func(dbdata.Envelope) error) error {
+	decoder := xml.NewDecoder(reader)
+	senderName := ""
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to parse xml data: %v", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case start.Name.Local == "name":
+			if err := decoder.DecodeElement(&senderName, &start); err != nil {
+				return fmt.Errorf("Unable to parse xml sender: %v", err)
+			}
+		case start.Name.Local == "Cube" && hasAttr(start, "time"):
+			day := xmldata.Day{}
+			if err := decoder.DecodeElement(&day, &start); err != nil {
+				return fmt.Errorf("Unable to parse xml data: %v", err)
+			}
+
+			envelope := dbdata.Envelope{SenderName: senderName, CubeTime: day.Time, Cube: []dbdata.Cube{}}
+			for _, cube := range day.Cube {
+				appendRate(&envelope, cube.Currency, cube.Rate)
+			}
+
+			if err := emit(envelope); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// appendRate adds the rate of currency to envelope, a malformed value is recorded so only its day is rejected
+func appendRate(envelope *dbdata.Envelope, currency, value string) {
+	rate, err := decimal.NewFromString(value)
+	if err != nil {
+		envelope.Malformed = append(envelope.Malformed, fmt.Sprintf("invalid rate %q of %v", value, currency))
+		return
+	}
+
+	envelope.Cube = append(envelope.Cube, dbdata.Cube{Currency: currency, Rate: rate})
+}
+
+func hasAttr(start xml.StartElement, name string) bool {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,174 @@
+package envelope
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/entities/xmldata"
+)
+
+func Test_decodeXMLStream(t *testing.T) {
+	data, err := ioutil.ReadFile(mockHistoryXMLPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &xmldata.Envelope{}
+	if err := xml.Unmarshal(data, env); err != nil {
+		t.Fatal(err)
+	}
+	want := convertXMLtoDBEntities(env)
+
+	got := []dbdata.Envelope{}
+	err = decodeXMLStream(strings.NewReader(string(data)), func(envelope dbdata.Envelope) error {
+		got = append(got, envelope)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("decodeXMLStream() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeXMLStream() decoded %v days, want the %v days of xml.Unmarshal", len(got), len(want))
+	}
+}
+
+func Test_decodeXML(t *testing.T) {
+	data, err := ioutil.ReadFile(mockHistoryXMLPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	days := strings.Count(string(data), "time=")
+
+	tests := []struct {
+		name    string
+		data    string
+		size    int64
+		want    int
+		wantErr bool
+	}{
+		struct {
+			name    string
+			data    string
+			size    int64
+			want    int
+			wantErr bool
+		}{
+			name: "Small document is unmarshalled at once",
+			data: string(data),
+			size: int64(len(data)),
+			want: days,
+		},
+		struct {
+			name    string
+			data    string
+			size    int64
+			want    int
+			wantErr bool
+		}{
+			name: "Unknown size is streamed",
+			data: string(data),
+			size: -1,
+			want: days,
+		},
+		struct {
+			name    string
+			data    string
+			size    int64
+			want    int
+			wantErr bool
+		}{
+			name:    "Invalid small document",
+			data:    "<gesmes:Envelope",
+			size:    16,
+			wantErr: true,
+		},
+		struct {
+			name    string
+			data    string
+			size    int64
+			want    int
+			wantErr bool
+		}{
+			name:    "Invalid streamed document",
+			data:    "<gesmes:Envelope",
+			size:    -1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := 0
+			err := decodeXML(strings.NewReader(tt.data), tt.size, func(envelope dbdata.Envelope) error {
+				got++
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeXML() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("decodeXML() emitted %v days, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvelope_storeDays(t *testing.T) {
+	decodeErr := errors.New("unexpected EOF")
+	dbManager := &historyDBHandler{}
+	e := &Envelope{dbManager: dbManager}
+	run := &dbdata.IngestionRun{Source: "boc"}
+
+	err := e.storeDays(run, func(emit func(dbdata.Envelope) error, flush func() error) error {
+		for index := 0; index < 2*ingestBatchSize+1; index++ {
+			emit(dbdata.Envelope{Source: ECBSource, CubeTime: mockValidDay.CubeTime, Cube: mockValidDay.Cube})
+		}
+		return decodeErr
+	})
+
+	if err != decodeErr {
+		t.Errorf("Envelope.storeDays() error = %v, want %v", err, decodeErr)
+	}
+	if run.DaysSeen != 2*ingestBatchSize+1 || run.DaysInserted != run.DaysSeen || len(dbManager.days) != run.DaysSeen {
+		t.Errorf("Envelope.storeDays() saw %v, inserted %v, stored %v, want %v", run.DaysSeen, run.DaysInserted, len(dbManager.days), 2*ingestBatchSize+1)
+	}
+	if dbManager.batches != 3 {
+		t.Errorf("Envelope.storeDays() batches = %v, want 3", dbManager.batches)
+	}
+	for _, day := range dbManager.days {
+		if day.Source != "boc" {
+			t.Fatalf("Envelope.storeDays() source = %v, want boc", day.Source)
+		}
+	}
+}
+
+func TestEnvelope_storeDays_databaseError(t *testing.T) {
+	dbErr := errors.New("connection refused")
+	dbManager := &historyDBHandler{err: dbErr}
+	e := &Envelope{dbManager: dbManager}
+
+	run := &dbdata.IngestionRun{Source: ECBSource}
+
+	emitted := 0
+	err := e.storeDays(run, func(emit func(dbdata.Envelope) error, flush func() error) error {
+		for index := 0; index < 2*ingestBatchSize; index++ {
+			emitted++
+			if err := emit(mockValidDay); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != dbErr {
+		t.Errorf("Envelope.storeDays() error = %v, want %v", err, dbErr)
+	}
+	if run.DaysInserted != 0 || emitted != ingestBatchSize || dbManager.batches != 1 {
+		t.Errorf("Envelope.storeDays() inserted %v after %v days and %v batches, want 0 after %v days and 1 batch", run.DaysInserted, emitted, dbManager.batches, ingestBatchSize)
+	}
+}
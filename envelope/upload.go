@@ -0,0 +1,78 @@
+package envelope
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/emanpicar/currency-api/apperror"
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/entities/jsondata"
+	"github.com/emanpicar/currency-api/logger"
+)
+
+var (
+	errInvalidUpload     = apperror.New(apperror.Invalid, "invalid_upload", "Invalid upload")
+	errUnsupportedFormat = apperror.New(apperror.Invalid, "unsupported_format", "Unsupported format")
+	errEmptyUpload       = errors.New("No rates found")
+)
+
+// UploadRates stores an ECB xml or csv document under source, ECB when empty, and returns the recorded run.
+// xml is unmarshalled at once, the caller limits the size of reader. Days decoded before an invalid one are kept.
+// An upload is refused while another ingestion runs.
+func (e *Envelope) UploadRates(reader io.Reader, format, source string) (*jsondata.IngestionRun, error) {
+	format = strings.ToLower(format)
+	if format == "" {
+		format = "xml"
+	}
+
+	var decode func(reader io.Reader, emit func(dbdata.Envelope) error) error
+	switch format {
+	case "xml":
+		decode = unmarshalXML
+	case "csv":
+		decode = func(reader io.Reader, emit func(dbdata.Envelope) error) error {
+			return decodeCSVStream(reader, ecbSenderName, emit)
+		}
+	default:
+		return nil, errUnsupportedFormat.WithMessage(fmt.Sprintf("Unsupported format: %q", format)).
+			WithDetails(map[string]interface{}{"format": format, "supported": []string{"xml", "csv"}})
+	}
+
+	source = strings.ToLower(source)
+	if source == "" {
+		source = ECBSource
+	}
+	logger.Log.Infof("Upload of %v rates in %v started", source, format)
+
+	run := &dbdata.IngestionRun{Source: source, Trigger: TriggerUpload, Location: "upload." + format}
+	var storeErr error
+	err := e.singleFlight(func() error {
+		return e.ingest(run, func(emit func(dbdata.Envelope) error, flush func() error) error {
+			err := decode(reader, func(envelope dbdata.Envelope) error {
+				if err := emit(envelope); err != nil {
+					storeErr = err
+					return err
+				}
+				return nil
+			})
+			if err == nil && run.DaysSeen == 0 {
+				err = errEmptyUpload
+			}
+			if err != nil && storeErr == nil {
+				return errInvalidUpload.WithMessage(fmt.Sprintf("Invalid %v upload: %v", format, err)).
+					WithDetails(map[string]interface{}{"format": format})
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log.Infof("Upload of %v rates completed, %v days processed", source, run.DaysSeen)
+	result := toJSONIngestionRun(*run)
+
+	return &result, nil
+}
@@ -0,0 +1,153 @@
+package envelope
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/emanpicar/currency-api/apperror"
+)
+
+const mockUploadXML = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<gesmes:Sender><gesmes:name>European Central Bank</gesmes:name></gesmes:Sender>
+	<Cube>
+		<Cube time="2020-06-02"><Cube currency="USD" rate="1.1174"/><Cube currency="JPY" rate="120.78"/></Cube>
+		<Cube time="2020-06-01"><Cube currency="USD" rate="1.1136"/></Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func TestEnvelope_UploadRates(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		format     string
+		source     string
+		dbErr      error
+		wantSource string
+		wantDays   int
+		wantCode   string
+	}{
+		struct {
+			name       string
+			data       string
+			format     string
+			source     string
+			dbErr      error
+			wantSource string
+			wantDays   int
+			wantCode   string
+		}{
+			name:       "Xml by default",
+			data:       mockUploadXML,
+			wantSource: ECBSource,
+			wantDays:   2,
+		},
+		struct {
+			name       string
+			data       string
+			format     string
+			source     string
+			dbErr      error
+			wantSource string
+			wantDays   int
+			wantCode   string
+		}{
+			name:       "Csv under another source",
+			data:       mockHistoryCSV,
+			format:     "CSV",
+			source:     "Backup",
+			wantSource: "backup",
+			wantDays:   2,
+		},
+		struct {
+			name       string
+			data       string
+			format     string
+			source     string
+			dbErr      error
+			wantSource string
+			wantDays   int
+			wantCode   string
+		}{
+			name:     "Unsupported format",
+			data:     mockUploadXML,
+			format:   "zip",
+			wantCode: "unsupported_format",
+		},
+		struct {
+			name       string
+			data       string
+			format     string
+			source     string
+			dbErr      error
+			wantSource string
+			wantDays   int
+			wantCode   string
+		}{
+			name:     "Malformed xml",
+			data:     "<gesmes:Envelope><Cube>",
+			wantCode: "invalid_upload",
+		},
+		struct {
+			name       string
+			data       string
+			format     string
+			source     string
+			dbErr      error
+			wantSource string
+			wantDays   int
+			wantCode   string
+		}{
+			name:     "Document without rates",
+			data:     "Date,USD\n",
+			format:   "csv",
+			wantCode: "invalid_upload",
+		},
+		struct {
+			name       string
+			data       string
+			format     string
+			source     string
+			dbErr      error
+			wantSource string
+			wantDays   int
+			wantCode   string
+		}{
+			name:     "Database error is not an invalid upload",
+			data:     mockUploadXML,
+			dbErr:    apperror.New(apperror.Unavailable, "database_unavailable", "Database unavailable"),
+			wantCode: "database_unavailable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbManager := &historyDBHandler{err: tt.dbErr}
+			e := &Envelope{dbManager: dbManager}
+			got, err := e.UploadRates(strings.NewReader(tt.data), tt.format, tt.source)
+			if tt.wantCode != "" {
+				var appErr *apperror.Error
+				if !errors.As(err, &appErr) || appErr.Code != tt.wantCode {
+					t.Errorf("Envelope.UploadRates() error = %v, want code %v", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Envelope.UploadRates() error = %v", err)
+			}
+
+			if got.Source != tt.wantSource || got.Trigger != TriggerUpload || got.Status != IngestionSucceeded {
+				t.Errorf("Envelope.UploadRates() = %+v, want succeeded %v upload of %v", got, TriggerUpload, tt.wantSource)
+			}
+			if got.DaysSeen != tt.wantDays || got.DaysInserted != tt.wantDays || len(dbManager.days) != tt.wantDays {
+				t.Errorf("Envelope.UploadRates() saw %v, inserted %v, stored %v days, want %v", got.DaysSeen, got.DaysInserted, len(dbManager.days), tt.wantDays)
+			}
+			for _, day := range dbManager.days {
+				if day.Source != tt.wantSource || day.SenderName != ecbSenderName {
+					t.Errorf("Envelope.UploadRates() stored %v from %v, want %v from %v", day.Source, day.SenderName, tt.wantSource, ecbSenderName)
+				}
+			}
+		})
+	}
+}
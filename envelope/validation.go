@@ -0,0 +1,260 @@
+package envelope
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/emanpicar/currency-api/db"
+	"github.com/emanpicar/currency-api/decimal"
+	"github.com/emanpicar/currency-api/entities/dbdata"
+	"github.com/emanpicar/currency-api/entities/jsondata"
+	"github.com/emanpicar/currency-api/iso4217"
+	"github.com/emanpicar/currency-api/logger"
+	"github.com/emanpicar/currency-api/settings"
+)
+
+const (
+	// ValidationQuarantine keeps days failing validation for review, ValidationReject drops them
+	ValidationQuarantine = "quarantine"
+	ValidationReject     = "reject"
+
+	// previousDayMaxDays bounds how far back the stored day compared with the first day of a run is searched
+	previousDayMaxDays = 7
+)
+
+var (
+	currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+	errEmptyDocument    = errors.New("No days found")
+)
+
+type (
+	// validationRules are the checks every ingested day has to pass before it is published
+	validationRules struct {
+		enabled        bool
+		action         string
+		maxJumpPercent decimal.Decimal
+		minCurrencies  int
+	}
+
+	// dayValidator compares every day with the last accepted day of the run, the first day with the stored day before it.
+	// pending holds the rates of the last rejected day, a move it confirms is accepted.
+	dayValidator struct {
+		rules    validationRules
+		previous map[string]decimal.Decimal
+		pending  map[string]decimal.Decimal
+		lookup   func(cubeTime string) map[string]decimal.Decimal
+	}
+)
+
+func newValidationRules() validationRules {
+	return validationRules{
+		enabled:        settings.GetValidationEnabled(),
+		action:         settings.GetValidationAction(),
+		maxJumpPercent: decimal.New(int64(settings.GetValidationMaxJumpPercent())),
+		minCurrencies:  settings.GetValidationMinCurrencies(),
+	}
+}
+
+func (e *Envelope) newDayValidator(source string) *dayValidator {
+	return &dayValidator{
+		rules: newValidationRules(),
+		lookup: func(cubeTime string) map[string]decimal.Decimal {
+			date, err := time.Parse(dateLayout, cubeTime)
+			if err != nil {
+				return nil
+			}
+
+			envelope, err := e.dbManager.GetRatesOnOrBefore(db.RateQuery{Source: source},
+				date.AddDate(0, 0, -1).Format(dateLayout), date.AddDate(0, 0, -previousDayMaxDays).Format(dateLayout))
+			if err != nil {
+				return nil
+			}
+
+			return ratesOf(*envelope)
+		},
+	}
+}
+
+// validate returns the rules day fails, none when it can be published.
+// A day with malformed rates is rejected even when validation is disabled.
+func (v *dayValidator) validate(day dbdata.Envelope) []string {
+	if !v.rules.enabled {
+		if len(day.Malformed) > 0 {
+			return day.Malformed
+		}
+		return nil
+	}
+
+	reasons := append([]string{}, day.Malformed...)
+	if _, err := time.Parse(dateLayout, day.CubeTime); err != nil {
+		reasons = append(reasons, fmt.Sprintf("invalid date %q", day.CubeTime))
+	}
+	if len(day.Cube) < v.rules.minCurrencies {
+		reasons = append(reasons, fmt.Sprintf("%v rates, at least %v required", len(day.Cube), v.rules.minCurrencies))
+	}
+
+	seen := make(map[string]bool)
+	for _, cube := range day.Cube {
+		if !validCurrencyCode(cube.Currency) {
+			reasons = append(reasons, fmt.Sprintf("invalid currency code %q", cube.Currency))
+		}
+		if seen[cube.Currency] {
+			reasons = append(reasons, fmt.Sprintf("duplicate currency %v", cube.Currency))
+		}
+		seen[cube.Currency] = true
+		if cube.Rate.Sign() <= 0 {
+			reasons = append(reasons, fmt.Sprintf("invalid rate %v of %v", cube.Rate, cube.Currency))
+		}
+	}
+
+	if len(reasons) == 0 {
+		reasons = append(reasons, v.jumps(day)...)
+	}
+	if len(reasons) == 0 {
+		if v.previous == nil {
+			v.previous = make(map[string]decimal.Decimal)
+		}
+		for currency, rate := range ratesOf(day) {
+			v.previous[currency] = rate
+		}
+		v.pending = nil
+	} else {
+		v.pending = validRatesOf(day)
+	}
+
+	return reasons
+}
+
+// jumps reports the rates that changed more than maxJumpPercent from the last accepted day,
+// unless the rejected day in between moved the same way. A single corrupted day is quarantined alone,
+// a genuine move only on the day it happened.
+func (v *dayValidator) jumps(day dbdata.Envelope) []string {
+	if v.rules.maxJumpPercent.Sign() <= 0 {
+		return nil
+	}
+	if v.previous == nil && v.lookup != nil {
+		v.previous = v.lookup(day.CubeTime)
+		v.lookup = nil
+	}
+
+	reasons := []string{}
+	for _, cube := range day.Cube {
+		previous, ok := v.previous[cube.Currency]
+		if !ok || previous.Sign() <= 0 {
+			continue
+		}
+
+		change := v.change(previous, cube.Rate)
+		if pending, ok := v.pending[cube.Currency]; ok && v.change(pending, cube.Rate).Cmp(v.rules.maxJumpPercent) <= 0 {
+			continue
+		}
+		if change.Cmp(v.rules.maxJumpPercent) > 0 {
+			reasons = append(reasons, fmt.Sprintf("%v changed %v%% from %v to %v, at most %v%% allowed",
+				cube.Currency, change.Round(2, decimal.RoundHalfEven), previous, cube.Rate, v.rules.maxJumpPercent))
+		}
+	}
+
+	return reasons
+}
+
+// change is the move from previous to rate in percent
+func (v *dayValidator) change(previous, rate decimal.Decimal) decimal.Decimal {
+	return rate.Sub(previous).Abs().Div(previous).Mul(decimal.New(100))
+}
+
+// rejectDay drops a day failing validation, keeping it for review unless the action is reject.
+// Failing to keep it stops the run so no day disappears unnoticed.
+func (e *Envelope) rejectDay(run *dbdata.IngestionRun, rules validationRules, day dbdata.Envelope, reasons []string) error {
+	run.DaysRejected++
+	logger.Log.Warnf("Day %v of %v rates failed validation: %v", day.CubeTime, run.Source, strings.Join(reasons, "; "))
+
+	if rules.action == ValidationReject {
+		return nil
+	}
+
+	rates := []jsondata.QuarantinedRate{}
+	for _, cube := range day.Cube {
+		rates = append(rates, jsondata.QuarantinedRate{Currency: cube.Currency, Rate: cube.Rate})
+	}
+	data, err := json.Marshal(rates)
+	if err != nil {
+		return err
+	}
+
+	return e.dbManager.QuarantineDay(&dbdata.QuarantinedDay{
+		IngestionRunID: run.ID,
+		Source:         run.Source,
+		SenderName:     day.SenderName,
+		CubeTime:       day.CubeTime,
+		Rates:          string(data),
+		Reasons:        strings.Join(reasons, "; "),
+	})
+}
+
+// ListQuarantinedDays returns the latest quarantined days first, of every source when source is empty.
+// limit defaults to 50 and is capped at 500.
+func (e *Envelope) ListQuarantinedDays(source string, limit int) ([]jsondata.QuarantinedDay, error) {
+	if limit <= 0 {
+		limit = defaultIngestionRunLimit
+	}
+	if limit > maxIngestionRunLimit {
+		limit = maxIngestionRunLimit
+	}
+
+	days, err := e.dbManager.ListQuarantinedDays(source, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []jsondata.QuarantinedDay{}
+	for _, day := range days {
+		rates := []jsondata.QuarantinedRate{}
+		if err := json.Unmarshal([]byte(day.Rates), &rates); err != nil {
+			logger.Log.Warnf("Unable to decode rates of quarantined day %v: %v", day.ID, err)
+		}
+
+		result = append(result, jsondata.QuarantinedDay{
+			ID:             day.ID,
+			IngestionRunID: day.IngestionRunID,
+			Source:         day.Source,
+			SenderName:     day.SenderName,
+			Date:           day.CubeTime,
+			Rates:          rates,
+			Reasons:        strings.Split(day.Reasons, "; "),
+			QuarantinedAt:  day.CreatedAt,
+		})
+	}
+
+	return result, nil
+}
+
+// validCurrencyCode accepts upper case ISO 4217 codes, including the withdrawn ones the ECB used to publish
+func validCurrencyCode(code string) bool {
+	_, ok := iso4217.Lookup(code)
+	return ok && currencyCodePattern.MatchString(code)
+}
+
+// validRatesOf skips the malformed currencies and rates of a rejected day
+func validRatesOf(day dbdata.Envelope) map[string]decimal.Decimal {
+	rates := make(map[string]decimal.Decimal)
+	for _, cube := range day.Cube {
+		if validCurrencyCode(cube.Currency) && cube.Rate.Sign() > 0 {
+			rates[cube.Currency] = cube.Rate
+		}
+	}
+
+	return rates
+}
+
+func ratesOf(day dbdata.Envelope) map[string]decimal.Decimal {
+	rates := make(map[string]decimal.Decimal)
+	for _, cube := range day.Cube {
+		rates[cube.Currency] = cube.Rate
+	}
+
+	return rates
+}
@@ -0,0 +1,350 @@
+package envelope
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emanpicar/currency-api/decimal"
+	"github.com/emanpicar/currency-api/entities/dbdata"
+)
+
+func Test_dayValidator_validate(t *testing.T) {
+	rules := validationRules{enabled: true, action: ValidationQuarantine, maxJumpPercent: decimal.RequireFromString("25"), minCurrencies: 2}
+
+	tests := []struct {
+		name     string
+		rules    validationRules
+		previous map[string]decimal.Decimal
+		day      dbdata.Envelope
+		want     []string
+	}{
+		struct {
+			name     string
+			rules    validationRules
+			previous map[string]decimal.Decimal
+			day      dbdata.Envelope
+			want     []string
+		}{
+			name:     "Valid day",
+			rules:    rules,
+			previous: map[string]decimal.Decimal{"USD": decimal.RequireFromString("1.1"), "JPY": decimal.RequireFromString("120")},
+			day: dbdata.Envelope{CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+				dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1136")},
+				dbdata.Cube{Currency: "JPY", Rate: decimal.RequireFromString("120.27")},
+			}},
+			want: []string{},
+		},
+		struct {
+			name     string
+			rules    validationRules
+			previous map[string]decimal.Decimal
+			day      dbdata.Envelope
+			want     []string
+		}{
+			name:  "Malformed day",
+			rules: rules,
+			day: dbdata.Envelope{CubeTime: "June 1st", Cube: []dbdata.Cube{
+				dbdata.Cube{Currency: "usd", Rate: decimal.RequireFromString("0")},
+				dbdata.Cube{Currency: "JPY", Rate: decimal.RequireFromString("-120.27")},
+				dbdata.Cube{Currency: "JPY", Rate: decimal.RequireFromString("120.27")},
+			}},
+			want: []string{
+				`invalid date "June 1st"`,
+				`invalid currency code "usd"`,
+				"invalid rate 0 of usd",
+				"invalid rate -120.27 of JPY",
+				"duplicate currency JPY",
+			},
+		},
+		struct {
+			name     string
+			rules    validationRules
+			previous map[string]decimal.Decimal
+			day      dbdata.Envelope
+			want     []string
+		}{
+			name:  "Too few currencies",
+			rules: rules,
+			day: dbdata.Envelope{CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+				dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.1136")},
+			}},
+			want: []string{"1 rates, at least 2 required"},
+		},
+		struct {
+			name     string
+			rules    validationRules
+			previous map[string]decimal.Decimal
+			day      dbdata.Envelope
+			want     []string
+		}{
+			name:     "Jump from the previous day",
+			rules:    rules,
+			previous: map[string]decimal.Decimal{"USD": decimal.RequireFromString("1"), "JPY": decimal.RequireFromString("120")},
+			day: dbdata.Envelope{CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+				dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.5")},
+				dbdata.Cube{Currency: "JPY", Rate: decimal.RequireFromString("120.27")},
+				dbdata.Cube{Currency: "GBP", Rate: decimal.RequireFromString("0.9")},
+			}},
+			want: []string{"USD changed 50% from 1 to 1.5, at most 25% allowed"},
+		},
+		struct {
+			name     string
+			rules    validationRules
+			previous map[string]decimal.Decimal
+			day      dbdata.Envelope
+			want     []string
+		}{
+			name:     "Jump rule disabled",
+			rules:    validationRules{enabled: true, minCurrencies: 1},
+			previous: map[string]decimal.Decimal{"USD": decimal.RequireFromString("1")},
+			day: dbdata.Envelope{CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+				dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("1.5")},
+			}},
+			want: []string{},
+		},
+		struct {
+			name     string
+			rules    validationRules
+			previous map[string]decimal.Decimal
+			day      dbdata.Envelope
+			want     []string
+		}{
+			name:  "Validation disabled",
+			rules: validationRules{},
+			day:   dbdata.Envelope{CubeTime: "June 1st"},
+		},
+		struct {
+			name     string
+			rules    validationRules
+			previous map[string]decimal.Decimal
+			day      dbdata.Envelope
+			want     []string
+		}{
+			name:  "Code missing from ISO 4217",
+			rules: rules,
+			day: dbdata.Envelope{CubeTime: "2020-06-01", Cube: []dbdata.Cube{
+				dbdata.Cube{Currency: "ABC", Rate: decimal.RequireFromString("1.5")},
+				dbdata.Cube{Currency: "CYP", Rate: decimal.RequireFromString("0.585274")},
+			}},
+			want: []string{`invalid currency code "ABC"`},
+		},
+		struct {
+			name     string
+			rules    validationRules
+			previous map[string]decimal.Decimal
+			day      dbdata.Envelope
+			want     []string
+		}{
+			name:  "Malformed rates with validation disabled",
+			rules: validationRules{},
+			day:   dbdata.Envelope{CubeTime: "2020-06-01", Malformed: []string{`invalid rate "abc" of USD`}},
+			want:  []string{`invalid rate "abc" of USD`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &dayValidator{rules: tt.rules, previous: tt.previous}
+			if got := v.validate(tt.day); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dayValidator.validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dayValidator_validate_previousDay(t *testing.T) {
+	tests := []struct {
+		name         string
+		currencies   []string
+		rates        []string
+		wantRejected []int
+	}{
+		struct {
+			name         string
+			currencies   []string
+			rates        []string
+			wantRejected []int
+		}{
+			name:         "Spike then recovery",
+			currencies:   []string{"USD", "USD", "USD"},
+			rates:        []string{"1.1", "2", "1.2"},
+			wantRejected: []int{1},
+		},
+		struct {
+			name         string
+			currencies   []string
+			rates        []string
+			wantRejected []int
+		}{
+			name:         "Corrupted day",
+			currencies:   []string{"USD", "USD", "USD"},
+			rates:        []string{"1.1", "11", "1.1"},
+			wantRejected: []int{1},
+		},
+		struct {
+			name         string
+			currencies   []string
+			rates        []string
+			wantRejected []int
+		}{
+			name:         "Genuine move confirmed by the next day",
+			currencies:   []string{"USD", "USD", "USD", "USD"},
+			rates:        []string{"1.1", "2", "2.1", "2.2"},
+			wantRejected: []int{1},
+		},
+		struct {
+			name         string
+			currencies   []string
+			rates        []string
+			wantRejected []int
+		}{
+			name:         "Malformed day in between",
+			currencies:   []string{"USD", "usd", "USD"},
+			rates:        []string{"1.1", "9", "1.2"},
+			wantRejected: []int{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookups := 0
+			v := &dayValidator{
+				rules: validationRules{enabled: true, maxJumpPercent: decimal.RequireFromString("25"), minCurrencies: 1},
+				lookup: func(cubeTime string) map[string]decimal.Decimal {
+					lookups++
+					return map[string]decimal.Decimal{"USD": decimal.RequireFromString("1")}
+				},
+			}
+
+			rejected := []int{}
+			for index, rate := range tt.rates {
+				day := dbdata.Envelope{CubeTime: fmt.Sprintf("2020-06-%02d", index+1), Cube: []dbdata.Cube{
+					dbdata.Cube{Currency: tt.currencies[index], Rate: decimal.RequireFromString(rate)},
+				}}
+				if len(v.validate(day)) > 0 {
+					rejected = append(rejected, index)
+				}
+			}
+
+			if !reflect.DeepEqual(rejected, tt.wantRejected) || lookups != 1 {
+				t.Errorf("dayValidator.validate() rejected %v after %v lookups, want %v after 1", rejected, lookups, tt.wantRejected)
+			}
+		})
+	}
+}
+
+func TestEnvelope_storeDays_validation(t *testing.T) {
+	invalidDay := dbdata.Envelope{SenderName: ecbSenderName, CubeTime: "2020-06-02", Cube: []dbdata.Cube{
+		dbdata.Cube{Currency: "USD", Rate: decimal.RequireFromString("-1")},
+	}}
+
+	tests := []struct {
+		name            string
+		action          string
+		days            []dbdata.Envelope
+		wantErr         error
+		wantQuarantined int
+	}{
+		struct {
+			name            string
+			action          string
+			days            []dbdata.Envelope
+			wantErr         error
+			wantQuarantined int
+		}{
+			name:            "Quarantine",
+			action:          ValidationQuarantine,
+			days:            []dbdata.Envelope{mockValidDay, invalidDay},
+			wantQuarantined: 1,
+		},
+		struct {
+			name            string
+			action          string
+			days            []dbdata.Envelope
+			wantErr         error
+			wantQuarantined int
+		}{
+			name:   "Reject",
+			action: ValidationReject,
+			days:   []dbdata.Envelope{mockValidDay, invalidDay},
+		},
+		struct {
+			name            string
+			action          string
+			days            []dbdata.Envelope
+			wantErr         error
+			wantQuarantined int
+		}{
+			name:    "Empty document",
+			action:  ValidationQuarantine,
+			wantErr: errEmptyDocument,
+		},
+	}
+	defer os.Unsetenv("VALIDATION_ACTION")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("VALIDATION_ACTION", tt.action)
+			dbManager := &historyDBHandler{}
+			e := &Envelope{dbManager: dbManager}
+			run := &dbdata.IngestionRun{Source: ECBSource}
+
+			err := e.storeDays(run, func(emit func(dbdata.Envelope) error, flush func() error) error {
+				for _, day := range tt.days {
+					if err := emit(day); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != tt.wantErr {
+				t.Errorf("Envelope.storeDays() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if len(dbManager.days) != len(tt.days)-run.DaysRejected || run.DaysRejected != len(tt.days)/2 {
+				t.Errorf("Envelope.storeDays() stored %v, rejected %v of %v days", len(dbManager.days), run.DaysRejected, len(tt.days))
+			}
+			if len(dbManager.quarantined) != tt.wantQuarantined {
+				t.Fatalf("Envelope.storeDays() quarantined %v days, want %v", len(dbManager.quarantined), tt.wantQuarantined)
+			}
+			if tt.wantQuarantined > 0 {
+				day := dbManager.quarantined[0]
+				if day.CubeTime != "2020-06-02" || day.Rates != `[{"currency":"USD","rate":-1}]` || day.Reasons != "invalid rate -1 of USD" {
+					t.Errorf("Envelope.storeDays() quarantined %+v", day)
+				}
+			}
+		})
+	}
+}
+
+func TestEnvelope_storeDays_malformedDays(t *testing.T) {
+	document := `<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<Cube>
+		<Cube time="2020-06-03"><Cube currency="USD" rate="1.1213"/></Cube>
+		<Cube time="June 2nd"><Cube currency="USD" rate="1.1174"/></Cube>
+		<Cube time="2020-06-01"><Cube currency="USD" rate="1,1136"/></Cube>
+		<Cube time="2020-05-29"><Cube currency="USD" rate="1.1101"/></Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+	dbManager := &historyDBHandler{}
+	e := &Envelope{dbManager: dbManager}
+	run := &dbdata.IngestionRun{Source: ECBSource}
+	err := e.storeDays(run, func(emit func(dbdata.Envelope) error, flush func() error) error {
+		return decodeXMLStream(strings.NewReader(document), emit)
+	})
+	if err != nil {
+		t.Fatalf("Envelope.storeDays() error = %v, want the malformed days quarantined", err)
+	}
+
+	if len(dbManager.days) != 2 || run.DaysRejected != 2 || len(dbManager.quarantined) != 2 {
+		t.Fatalf("Envelope.storeDays() stored %v, rejected %v, quarantined %v days, want 2, 2, 2",
+			len(dbManager.days), run.DaysRejected, len(dbManager.quarantined))
+	}
+	if got := dbManager.quarantined[0].Reasons; got != `invalid date "June 2nd"` {
+		t.Errorf("Envelope.storeDays() reasons = %v, want the invalid date", got)
+	}
+	if got := dbManager.quarantined[1].Reasons; !strings.HasPrefix(got, `invalid rate "1,1136" of USD`) {
+		t.Errorf("Envelope.storeDays() reasons = %v, want the invalid rate", got)
+	}
+}
@@ -0,0 +1,195 @@
+package fetcher
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/emanpicar/currency-api/logger"
+	"github.com/emanpicar/currency-api/settings"
+)
+
+// maxBackoff caps the doubling delay between retries
+const maxBackoff = 30 * time.Second
+
+var (
+	// ErrNotModified is returned when the upstream has nothing new since the last consumed download
+	ErrNotModified = errors.New("Not modified since the last download")
+	errTooLarge    = errors.New("Response exceeds the download size limit")
+)
+
+type (
+	Manager interface {
+		// Fetch downloads rawURL and passes the body to consume, size is -1 when unknown.
+		// The ETag and Last-Modified of a body consumed without error are sent with the next download of rawURL,
+		// which returns ErrNotModified when the upstream answers 304.
+		Fetch(rawURL string, consume func(body io.Reader, size int64) error) error
+	}
+
+	// validators are the caching hints of the last consumed download of a URL
+	validators struct {
+		etag         string
+		lastModified string
+	}
+
+	fetcherHandler struct {
+		client   *http.Client
+		retries  int
+		backoff  time.Duration
+		maxBytes int64
+		sleep    func(time.Duration)
+
+		mutex      sync.Mutex
+		validators map[string]validators
+	}
+
+	// limitedReader fails once the body is larger than the limit instead of silently truncating it
+	limitedReader struct {
+		reader    io.Reader
+		remaining int64
+	}
+)
+
+func NewManager() Manager {
+	transport, err := newTransport(settings.GetFetchProxyURL(), settings.GetFetchCAFile())
+	if err != nil {
+		logger.Log.Fatalln(err)
+	}
+
+	return &fetcherHandler{
+		client:     &http.Client{Timeout: settings.GetFetchTimeout(), Transport: transport},
+		retries:    settings.GetFetchRetries(),
+		backoff:    settings.GetFetchRetryBackoff(),
+		maxBytes:   int64(settings.GetFetchMaxBytes()),
+		sleep:      time.Sleep,
+		validators: make(map[string]validators),
+	}
+}
+
+// newTransport routes downloads through proxyURL when set and also trusts the authorities of the PEM caFile when set
+func newTransport(proxyURL, caFile string) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if proxyURL != "" {
+		proxy, err := url.Parse(proxyURL)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("Invalid fetch proxy URL: %q", proxyURL)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read fetch CA file: %v", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificates found in fetch CA file %v", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return transport, nil
+}
+
+func (f *fetcherHandler) Fetch(rawURL string, consume func(body io.Reader, size int64) error) error {
+	resp, err := f.get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		logger.Log.Infof("%v not modified since the last download", rawURL)
+		return ErrNotModified
+	}
+
+	body := io.Reader(resp.Body)
+	if f.maxBytes > 0 {
+		if resp.ContentLength > f.maxBytes {
+			return fmt.Errorf("%v: %v bytes, at most %v allowed", errTooLarge, resp.ContentLength, f.maxBytes)
+		}
+		body = &limitedReader{reader: resp.Body, remaining: f.maxBytes + 1}
+	}
+
+	if err := consume(body, resp.ContentLength); err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	f.validators[rawURL] = validators{etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}
+	f.mutex.Unlock()
+
+	return nil
+}
+
+// get sends the validators of the last consumed download of rawURL.
+// Network errors, 429 and 5xx are retried with exponential backoff, other statuses than 200 and 304 fail right away.
+func (f *fetcherHandler) get(rawURL string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	previous := f.validators[rawURL]
+	f.mutex.Unlock()
+	if previous.etag != "" {
+		request.Header.Set("If-None-Match", previous.etag)
+	}
+	if previous.lastModified != "" {
+		request.Header.Set("If-Modified-Since", previous.lastModified)
+	}
+
+	backoff := f.backoff
+	for attempt := 1; ; attempt++ {
+		resp, err := f.client.Do(request)
+		if err == nil {
+			if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotModified {
+				return resp, nil
+			}
+
+			resp.Body.Close()
+			err = fmt.Errorf("Invalid response status: %v", resp.StatusCode)
+			if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError {
+				return nil, err
+			}
+		}
+
+		if attempt > f.retries {
+			return nil, fmt.Errorf("Unable to download %v after %v attempts: %v", rawURL, attempt, err)
+		}
+
+		logger.Log.Warnf("Download of %v failed, retrying in %v: %v", rawURL, backoff, err)
+		f.sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining <= 0 {
+		return n, errTooLarge
+	}
+
+	return n, err
+}
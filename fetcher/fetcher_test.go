@@ -0,0 +1,355 @@
+package fetcher
+
+import (
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFetcher(client *http.Client, maxBytes int64, sleeps *[]time.Duration) *fetcherHandler {
+	return &fetcherHandler{
+		client:     client,
+		retries:    3,
+		backoff:    10 * time.Millisecond,
+		maxBytes:   maxBytes,
+		sleep:      func(delay time.Duration) { *sleeps = append(*sleeps, delay) },
+		validators: make(map[string]validators),
+	}
+}
+
+func readAll(body io.Reader, size int64) error {
+	_, err := ioutil.ReadAll(body)
+	return err
+}
+
+func Test_fetcherHandler_Fetch(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		body         string
+		chunked      bool
+		wantAttempts int
+		wantSleeps   []time.Duration
+		wantErr      bool
+	}{
+		struct {
+			name         string
+			statuses     []int
+			body         string
+			chunked      bool
+			wantAttempts int
+			wantSleeps   []time.Duration
+			wantErr      bool
+		}{
+			name:         "Download at once",
+			statuses:     []int{http.StatusOK},
+			body:         "<Cube/>",
+			wantAttempts: 1,
+			wantSleeps:   []time.Duration{},
+		},
+		struct {
+			name         string
+			statuses     []int
+			body         string
+			chunked      bool
+			wantAttempts int
+			wantSleeps   []time.Duration
+			wantErr      bool
+		}{
+			name:         "Retry server errors and rate limits with backoff",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			body:         "<Cube/>",
+			wantAttempts: 3,
+			wantSleeps:   []time.Duration{10 * time.Millisecond, 20 * time.Millisecond},
+		},
+		struct {
+			name         string
+			statuses     []int
+			body         string
+			chunked      bool
+			wantAttempts int
+			wantSleeps   []time.Duration
+			wantErr      bool
+		}{
+			name:         "Give up after the retries",
+			statuses:     []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			wantAttempts: 4,
+			wantSleeps:   []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond},
+			wantErr:      true,
+		},
+		struct {
+			name         string
+			statuses     []int
+			body         string
+			chunked      bool
+			wantAttempts int
+			wantSleeps   []time.Duration
+			wantErr      bool
+		}{
+			name:         "Client errors are not retried",
+			statuses:     []int{http.StatusNotFound},
+			wantAttempts: 1,
+			wantSleeps:   []time.Duration{},
+			wantErr:      true,
+		},
+		struct {
+			name         string
+			statuses     []int
+			body         string
+			chunked      bool
+			wantAttempts int
+			wantSleeps   []time.Duration
+			wantErr      bool
+		}{
+			name:         "Announced length above the limit",
+			statuses:     []int{http.StatusOK},
+			body:         strings.Repeat("x", 65),
+			wantAttempts: 1,
+			wantSleeps:   []time.Duration{},
+			wantErr:      true,
+		},
+		struct {
+			name         string
+			statuses     []int
+			body         string
+			chunked      bool
+			wantAttempts int
+			wantSleeps   []time.Duration
+			wantErr      bool
+		}{
+			name:         "Streamed body above the limit",
+			statuses:     []int{http.StatusOK},
+			body:         strings.Repeat("x", 65),
+			chunked:      true,
+			wantAttempts: 1,
+			wantSleeps:   []time.Duration{},
+			wantErr:      true,
+		},
+		struct {
+			name         string
+			statuses     []int
+			body         string
+			chunked      bool
+			wantAttempts int
+			wantSleeps   []time.Duration
+			wantErr      bool
+		}{
+			name:         "Streamed body at the limit",
+			statuses:     []int{http.StatusOK},
+			body:         strings.Repeat("x", 64),
+			chunked:      true,
+			wantAttempts: 1,
+			wantSleeps:   []time.Duration{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[attempts]
+				attempts++
+				if tt.chunked {
+					w.(http.Flusher).Flush()
+				}
+				if status != http.StatusOK {
+					w.WriteHeader(status)
+					return
+				}
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			sleeps := []time.Duration{}
+			f := newTestFetcher(server.Client(), 64, &sleeps)
+
+			got := ""
+			err := f.Fetch(server.URL, func(body io.Reader, size int64) error {
+				data, err := ioutil.ReadAll(body)
+				got = string(data)
+				return err
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fetcherHandler.Fetch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.body {
+				t.Errorf("fetcherHandler.Fetch() body = %q, want %q", got, tt.body)
+			}
+			if attempts != tt.wantAttempts || !reflect.DeepEqual(sleeps, tt.wantSleeps) {
+				t.Errorf("fetcherHandler.Fetch() attempts = %v, sleeps = %v, want %v, %v", attempts, sleeps, tt.wantAttempts, tt.wantSleeps)
+			}
+		})
+	}
+}
+
+func Test_fetcherHandler_Fetch_conditional(t *testing.T) {
+	lastModified := "Mon, 01 Jun 2020 15:00:00 GMT"
+	requests := []http.Header{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Write([]byte("<Cube/>"))
+	}))
+	defer server.Close()
+
+	sleeps := []time.Duration{}
+	f := newTestFetcher(server.Client(), 0, &sleeps)
+
+	consumeErr := f.Fetch(server.URL, func(body io.Reader, size int64) error {
+		return io.ErrUnexpectedEOF
+	})
+	if consumeErr != io.ErrUnexpectedEOF {
+		t.Fatalf("fetcherHandler.Fetch() error = %v, want %v", consumeErr, io.ErrUnexpectedEOF)
+	}
+	if err := f.Fetch(server.URL, readAll); err != nil {
+		t.Fatalf("fetcherHandler.Fetch() error = %v", err)
+	}
+	if err := f.Fetch(server.URL, readAll); err != ErrNotModified {
+		t.Fatalf("fetcherHandler.Fetch() error = %v, want %v", err, ErrNotModified)
+	}
+
+	if len(requests) != 3 {
+		t.Fatalf("fetcherHandler.Fetch() sent %v requests, want 3", len(requests))
+	}
+	if requests[1].Get("If-None-Match") != "" {
+		t.Errorf("fetcherHandler.Fetch() sent validators of a download that was not consumed")
+	}
+	if requests[2].Get("If-None-Match") != `"v1"` || requests[2].Get("If-Modified-Since") != lastModified {
+		t.Errorf("fetcherHandler.Fetch() validators = %q, %q, want %q, %q",
+			requests[2].Get("If-None-Match"), requests[2].Get("If-Modified-Since"), `"v1"`, lastModified)
+	}
+}
+
+func Test_newTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<Cube/>"))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "currency-api-fetcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	emptyFile := filepath.Join(dir, "empty.pem")
+	ioutil.WriteFile(emptyFile, []byte("no certificates"), 0600)
+
+	tests := []struct {
+		name          string
+		proxyURL      string
+		caFile        string
+		wantErr       bool
+		wantTrustsTLS bool
+	}{
+		struct {
+			name          string
+			proxyURL      string
+			caFile        string
+			wantErr       bool
+			wantTrustsTLS bool
+		}{
+			name: "System authorities only",
+		},
+		struct {
+			name          string
+			proxyURL      string
+			caFile        string
+			wantErr       bool
+			wantTrustsTLS bool
+		}{
+			name:          "Custom authority",
+			caFile:        caFile,
+			wantTrustsTLS: true,
+		},
+		struct {
+			name          string
+			proxyURL      string
+			caFile        string
+			wantErr       bool
+			wantTrustsTLS bool
+		}{
+			name:    "Missing authority file",
+			caFile:  filepath.Join(dir, "missing.pem"),
+			wantErr: true,
+		},
+		struct {
+			name          string
+			proxyURL      string
+			caFile        string
+			wantErr       bool
+			wantTrustsTLS bool
+		}{
+			name:    "Authority file without certificates",
+			caFile:  emptyFile,
+			wantErr: true,
+		},
+		struct {
+			name          string
+			proxyURL      string
+			caFile        string
+			wantErr       bool
+			wantTrustsTLS bool
+		}{
+			name:     "Invalid proxy",
+			proxyURL: "proxy without scheme",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, err := newTransport(tt.proxyURL, tt.caFile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newTransport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err == nil) != tt.wantTrustsTLS {
+				t.Errorf("newTransport() download error = %v, want trusted %v", err, tt.wantTrustsTLS)
+			}
+		})
+	}
+}
+
+func Test_newTransport_proxy(t *testing.T) {
+	proxied := ""
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte("<Cube/>"))
+	}))
+	defer proxy.Close()
+
+	transport, err := newTransport(proxy.URL, "")
+	if err != nil {
+		t.Fatalf("newTransport() error = %v", err)
+	}
+
+	sleeps := []time.Duration{}
+	f := newTestFetcher(&http.Client{Transport: transport}, 0, &sleeps)
+	if err := f.Fetch("http://ecb.example/eurofxref-daily.xml", readAll); err != nil {
+		t.Fatalf("fetcherHandler.Fetch() error = %v", err)
+	}
+	if proxied != "http://ecb.example/eurofxref-daily.xml" {
+		t.Errorf("fetcherHandler.Fetch() proxied %q, want http://ecb.example/eurofxref-daily.xml", proxied)
+	}
+}
@@ -7,5 +7,7 @@ require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gorilla/mux v1.7.4
 	github.com/jinzhu/gorm v1.9.12
+	github.com/lib/pq v1.1.1
 	github.com/sirupsen/logrus v1.6.0
+	golang.org/x/crypto v0.0.0-20191205180655-e7c4368fe9dd
 )
@@ -0,0 +1,189 @@
+package iso4217
+
+import "strings"
+
+// Currency is the ISO 4217 entry of a currency, MinorUnits is the number of digits after the decimal separator
+type Currency struct {
+	Code       string
+	Name       string
+	Numeric    string
+	MinorUnits int
+}
+
+// Lookup finds code among the current currencies and the withdrawn ones the ECB used to publish
+func Lookup(code string) (Currency, bool) {
+	currency, ok := currencies[strings.ToUpper(code)]
+	return currency, ok
+}
+
+// currencies is compiled in so the metadata is available without network or data files
+var currencies = map[string]Currency{
+	"AED": Currency{Code: "AED", Name: "UAE Dirham", Numeric: "784", MinorUnits: 2},
+	"AFN": Currency{Code: "AFN", Name: "Afghani", Numeric: "971", MinorUnits: 2},
+	"ALL": Currency{Code: "ALL", Name: "Lek", Numeric: "008", MinorUnits: 2},
+	"AMD": Currency{Code: "AMD", Name: "Armenian Dram", Numeric: "051", MinorUnits: 2},
+	"ANG": Currency{Code: "ANG", Name: "Netherlands Antillean Guilder", Numeric: "532", MinorUnits: 2},
+	"AOA": Currency{Code: "AOA", Name: "Kwanza", Numeric: "973", MinorUnits: 2},
+	"ARS": Currency{Code: "ARS", Name: "Argentine Peso", Numeric: "032", MinorUnits: 2},
+	"AUD": Currency{Code: "AUD", Name: "Australian Dollar", Numeric: "036", MinorUnits: 2},
+	"AWG": Currency{Code: "AWG", Name: "Aruban Florin", Numeric: "533", MinorUnits: 2},
+	"AZN": Currency{Code: "AZN", Name: "Azerbaijan Manat", Numeric: "944", MinorUnits: 2},
+	"BAM": Currency{Code: "BAM", Name: "Convertible Mark", Numeric: "977", MinorUnits: 2},
+	"BBD": Currency{Code: "BBD", Name: "Barbados Dollar", Numeric: "052", MinorUnits: 2},
+	"BDT": Currency{Code: "BDT", Name: "Taka", Numeric: "050", MinorUnits: 2},
+	"BGN": Currency{Code: "BGN", Name: "Bulgarian Lev", Numeric: "975", MinorUnits: 2},
+	"BHD": Currency{Code: "BHD", Name: "Bahraini Dinar", Numeric: "048", MinorUnits: 3},
+	"BIF": Currency{Code: "BIF", Name: "Burundi Franc", Numeric: "108", MinorUnits: 0},
+	"BMD": Currency{Code: "BMD", Name: "Bermudian Dollar", Numeric: "060", MinorUnits: 2},
+	"BND": Currency{Code: "BND", Name: "Brunei Dollar", Numeric: "096", MinorUnits: 2},
+	"BOB": Currency{Code: "BOB", Name: "Boliviano", Numeric: "068", MinorUnits: 2},
+	"BRL": Currency{Code: "BRL", Name: "Brazilian Real", Numeric: "986", MinorUnits: 2},
+	"BSD": Currency{Code: "BSD", Name: "Bahamian Dollar", Numeric: "044", MinorUnits: 2},
+	"BTN": Currency{Code: "BTN", Name: "Ngultrum", Numeric: "064", MinorUnits: 2},
+	"BWP": Currency{Code: "BWP", Name: "Pula", Numeric: "072", MinorUnits: 2},
+	"BYN": Currency{Code: "BYN", Name: "Belarusian Ruble", Numeric: "933", MinorUnits: 2},
+	"BZD": Currency{Code: "BZD", Name: "Belize Dollar", Numeric: "084", MinorUnits: 2},
+	"CAD": Currency{Code: "CAD", Name: "Canadian Dollar", Numeric: "124", MinorUnits: 2},
+	"CDF": Currency{Code: "CDF", Name: "Congolese Franc", Numeric: "976", MinorUnits: 2},
+	"CHF": Currency{Code: "CHF", Name: "Swiss Franc", Numeric: "756", MinorUnits: 2},
+	"CLP": Currency{Code: "CLP", Name: "Chilean Peso", Numeric: "152", MinorUnits: 0},
+	"CNY": Currency{Code: "CNY", Name: "Yuan Renminbi", Numeric: "156", MinorUnits: 2},
+	"COP": Currency{Code: "COP", Name: "Colombian Peso", Numeric: "170", MinorUnits: 2},
+	"CRC": Currency{Code: "CRC", Name: "Costa Rican Colon", Numeric: "188", MinorUnits: 2},
+	"CUP": Currency{Code: "CUP", Name: "Cuban Peso", Numeric: "192", MinorUnits: 2},
+	"CVE": Currency{Code: "CVE", Name: "Cabo Verde Escudo", Numeric: "132", MinorUnits: 2},
+	"CYP": Currency{Code: "CYP", Name: "Cyprus Pound", Numeric: "196", MinorUnits: 2},
+	"CZK": Currency{Code: "CZK", Name: "Czech Koruna", Numeric: "203", MinorUnits: 2},
+	"DJF": Currency{Code: "DJF", Name: "Djibouti Franc", Numeric: "262", MinorUnits: 0},
+	"DKK": Currency{Code: "DKK", Name: "Danish Krone", Numeric: "208", MinorUnits: 2},
+	"DOP": Currency{Code: "DOP", Name: "Dominican Peso", Numeric: "214", MinorUnits: 2},
+	"DZD": Currency{Code: "DZD", Name: "Algerian Dinar", Numeric: "012", MinorUnits: 2},
+	"EEK": Currency{Code: "EEK", Name: "Kroon", Numeric: "233", MinorUnits: 2},
+	"EGP": Currency{Code: "EGP", Name: "Egyptian Pound", Numeric: "818", MinorUnits: 2},
+	"ERN": Currency{Code: "ERN", Name: "Nakfa", Numeric: "232", MinorUnits: 2},
+	"ETB": Currency{Code: "ETB", Name: "Ethiopian Birr", Numeric: "230", MinorUnits: 2},
+	"EUR": Currency{Code: "EUR", Name: "Euro", Numeric: "978", MinorUnits: 2},
+	"FJD": Currency{Code: "FJD", Name: "Fiji Dollar", Numeric: "242", MinorUnits: 2},
+	"FKP": Currency{Code: "FKP", Name: "Falkland Islands Pound", Numeric: "238", MinorUnits: 2},
+	"GBP": Currency{Code: "GBP", Name: "Pound Sterling", Numeric: "826", MinorUnits: 2},
+	"GEL": Currency{Code: "GEL", Name: "Lari", Numeric: "981", MinorUnits: 2},
+	"GHS": Currency{Code: "GHS", Name: "Ghana Cedi", Numeric: "936", MinorUnits: 2},
+	"GIP": Currency{Code: "GIP", Name: "Gibraltar Pound", Numeric: "292", MinorUnits: 2},
+	"GMD": Currency{Code: "GMD", Name: "Dalasi", Numeric: "270", MinorUnits: 2},
+	"GNF": Currency{Code: "GNF", Name: "Guinean Franc", Numeric: "324", MinorUnits: 0},
+	"GTQ": Currency{Code: "GTQ", Name: "Quetzal", Numeric: "320", MinorUnits: 2},
+	"GYD": Currency{Code: "GYD", Name: "Guyana Dollar", Numeric: "328", MinorUnits: 2},
+	"HKD": Currency{Code: "HKD", Name: "Hong Kong Dollar", Numeric: "344", MinorUnits: 2},
+	"HNL": Currency{Code: "HNL", Name: "Lempira", Numeric: "340", MinorUnits: 2},
+	"HRK": Currency{Code: "HRK", Name: "Kuna", Numeric: "191", MinorUnits: 2},
+	"HTG": Currency{Code: "HTG", Name: "Gourde", Numeric: "332", MinorUnits: 2},
+	"HUF": Currency{Code: "HUF", Name: "Forint", Numeric: "348", MinorUnits: 2},
+	"IDR": Currency{Code: "IDR", Name: "Rupiah", Numeric: "360", MinorUnits: 2},
+	"ILS": Currency{Code: "ILS", Name: "New Israeli Sheqel", Numeric: "376", MinorUnits: 2},
+	"INR": Currency{Code: "INR", Name: "Indian Rupee", Numeric: "356", MinorUnits: 2},
+	"IQD": Currency{Code: "IQD", Name: "Iraqi Dinar", Numeric: "368", MinorUnits: 3},
+	"IRR": Currency{Code: "IRR", Name: "Iranian Rial", Numeric: "364", MinorUnits: 2},
+	"ISK": Currency{Code: "ISK", Name: "Iceland Krona", Numeric: "352", MinorUnits: 0},
+	"JMD": Currency{Code: "JMD", Name: "Jamaican Dollar", Numeric: "388", MinorUnits: 2},
+	"JOD": Currency{Code: "JOD", Name: "Jordanian Dinar", Numeric: "400", MinorUnits: 3},
+	"JPY": Currency{Code: "JPY", Name: "Yen", Numeric: "392", MinorUnits: 0},
+	"KES": Currency{Code: "KES", Name: "Kenyan Shilling", Numeric: "404", MinorUnits: 2},
+	"KGS": Currency{Code: "KGS", Name: "Som", Numeric: "417", MinorUnits: 2},
+	"KHR": Currency{Code: "KHR", Name: "Riel", Numeric: "116", MinorUnits: 2},
+	"KMF": Currency{Code: "KMF", Name: "Comorian Franc", Numeric: "174", MinorUnits: 0},
+	"KPW": Currency{Code: "KPW", Name: "North Korean Won", Numeric: "408", MinorUnits: 2},
+	"KRW": Currency{Code: "KRW", Name: "Won", Numeric: "410", MinorUnits: 0},
+	"KWD": Currency{Code: "KWD", Name: "Kuwaiti Dinar", Numeric: "414", MinorUnits: 3},
+	"KYD": Currency{Code: "KYD", Name: "Cayman Islands Dollar", Numeric: "136", MinorUnits: 2},
+	"KZT": Currency{Code: "KZT", Name: "Tenge", Numeric: "398", MinorUnits: 2},
+	"LAK": Currency{Code: "LAK", Name: "Lao Kip", Numeric: "418", MinorUnits: 2},
+	"LBP": Currency{Code: "LBP", Name: "Lebanese Pound", Numeric: "422", MinorUnits: 2},
+	"LKR": Currency{Code: "LKR", Name: "Sri Lanka Rupee", Numeric: "144", MinorUnits: 2},
+	"LRD": Currency{Code: "LRD", Name: "Liberian Dollar", Numeric: "430", MinorUnits: 2},
+	"LSL": Currency{Code: "LSL", Name: "Loti", Numeric: "426", MinorUnits: 2},
+	"LTL": Currency{Code: "LTL", Name: "Lithuanian Litas", Numeric: "440", MinorUnits: 2},
+	"LVL": Currency{Code: "LVL", Name: "Latvian Lats", Numeric: "428", MinorUnits: 2},
+	"LYD": Currency{Code: "LYD", Name: "Libyan Dinar", Numeric: "434", MinorUnits: 3},
+	"MAD": Currency{Code: "MAD", Name: "Moroccan Dirham", Numeric: "504", MinorUnits: 2},
+	"MDL": Currency{Code: "MDL", Name: "Moldovan Leu", Numeric: "498", MinorUnits: 2},
+	"MGA": Currency{Code: "MGA", Name: "Malagasy Ariary", Numeric: "969", MinorUnits: 2},
+	"MKD": Currency{Code: "MKD", Name: "Denar", Numeric: "807", MinorUnits: 2},
+	"MMK": Currency{Code: "MMK", Name: "Kyat", Numeric: "104", MinorUnits: 2},
+	"MNT": Currency{Code: "MNT", Name: "Tugrik", Numeric: "496", MinorUnits: 2},
+	"MOP": Currency{Code: "MOP", Name: "Pataca", Numeric: "446", MinorUnits: 2},
+	"MRU": Currency{Code: "MRU", Name: "Ouguiya", Numeric: "929", MinorUnits: 2},
+	"MTL": Currency{Code: "MTL", Name: "Maltese Lira", Numeric: "470", MinorUnits: 2},
+	"MUR": Currency{Code: "MUR", Name: "Mauritius Rupee", Numeric: "480", MinorUnits: 2},
+	"MVR": Currency{Code: "MVR", Name: "Rufiyaa", Numeric: "462", MinorUnits: 2},
+	"MWK": Currency{Code: "MWK", Name: "Malawi Kwacha", Numeric: "454", MinorUnits: 2},
+	"MXN": Currency{Code: "MXN", Name: "Mexican Peso", Numeric: "484", MinorUnits: 2},
+	"MYR": Currency{Code: "MYR", Name: "Malaysian Ringgit", Numeric: "458", MinorUnits: 2},
+	"MZN": Currency{Code: "MZN", Name: "Mozambique Metical", Numeric: "943", MinorUnits: 2},
+	"NAD": Currency{Code: "NAD", Name: "Namibia Dollar", Numeric: "516", MinorUnits: 2},
+	"NGN": Currency{Code: "NGN", Name: "Naira", Numeric: "566", MinorUnits: 2},
+	"NIO": Currency{Code: "NIO", Name: "Cordoba Oro", Numeric: "558", MinorUnits: 2},
+	"NOK": Currency{Code: "NOK", Name: "Norwegian Krone", Numeric: "578", MinorUnits: 2},
+	"NPR": Currency{Code: "NPR", Name: "Nepalese Rupee", Numeric: "524", MinorUnits: 2},
+	"NZD": Currency{Code: "NZD", Name: "New Zealand Dollar", Numeric: "554", MinorUnits: 2},
+	"OMR": Currency{Code: "OMR", Name: "Rial Omani", Numeric: "512", MinorUnits: 3},
+	"PAB": Currency{Code: "PAB", Name: "Balboa", Numeric: "590", MinorUnits: 2},
+	"PEN": Currency{Code: "PEN", Name: "Sol", Numeric: "604", MinorUnits: 2},
+	"PGK": Currency{Code: "PGK", Name: "Kina", Numeric: "598", MinorUnits: 2},
+	"PHP": Currency{Code: "PHP", Name: "Philippine Peso", Numeric: "608", MinorUnits: 2},
+	"PKR": Currency{Code: "PKR", Name: "Pakistan Rupee", Numeric: "586", MinorUnits: 2},
+	"PLN": Currency{Code: "PLN", Name: "Zloty", Numeric: "985", MinorUnits: 2},
+	"PYG": Currency{Code: "PYG", Name: "Guarani", Numeric: "600", MinorUnits: 0},
+	"QAR": Currency{Code: "QAR", Name: "Qatari Rial", Numeric: "634", MinorUnits: 2},
+	"ROL": Currency{Code: "ROL", Name: "Romanian Leu (old)", Numeric: "642", MinorUnits: 2},
+	"RON": Currency{Code: "RON", Name: "Romanian Leu", Numeric: "946", MinorUnits: 2},
+	"RSD": Currency{Code: "RSD", Name: "Serbian Dinar", Numeric: "941", MinorUnits: 2},
+	"RUB": Currency{Code: "RUB", Name: "Russian Ruble", Numeric: "643", MinorUnits: 2},
+	"RWF": Currency{Code: "RWF", Name: "Rwanda Franc", Numeric: "646", MinorUnits: 0},
+	"SAR": Currency{Code: "SAR", Name: "Saudi Riyal", Numeric: "682", MinorUnits: 2},
+	"SBD": Currency{Code: "SBD", Name: "Solomon Islands Dollar", Numeric: "090", MinorUnits: 2},
+	"SCR": Currency{Code: "SCR", Name: "Seychelles Rupee", Numeric: "690", MinorUnits: 2},
+	"SDG": Currency{Code: "SDG", Name: "Sudanese Pound", Numeric: "938", MinorUnits: 2},
+	"SEK": Currency{Code: "SEK", Name: "Swedish Krona", Numeric: "752", MinorUnits: 2},
+	"SGD": Currency{Code: "SGD", Name: "Singapore Dollar", Numeric: "702", MinorUnits: 2},
+	"SHP": Currency{Code: "SHP", Name: "Saint Helena Pound", Numeric: "654", MinorUnits: 2},
+	"SIT": Currency{Code: "SIT", Name: "Tolar", Numeric: "705", MinorUnits: 2},
+	"SKK": Currency{Code: "SKK", Name: "Slovak Koruna", Numeric: "703", MinorUnits: 2},
+	"SLE": Currency{Code: "SLE", Name: "Leone", Numeric: "925", MinorUnits: 2},
+	"SLL": Currency{Code: "SLL", Name: "Leone (old)", Numeric: "694", MinorUnits: 2},
+	"SOS": Currency{Code: "SOS", Name: "Somali Shilling", Numeric: "706", MinorUnits: 2},
+	"SRD": Currency{Code: "SRD", Name: "Surinam Dollar", Numeric: "968", MinorUnits: 2},
+	"SSP": Currency{Code: "SSP", Name: "South Sudanese Pound", Numeric: "728", MinorUnits: 2},
+	"STN": Currency{Code: "STN", Name: "Dobra", Numeric: "930", MinorUnits: 2},
+	"SVC": Currency{Code: "SVC", Name: "El Salvador Colon", Numeric: "222", MinorUnits: 2},
+	"SYP": Currency{Code: "SYP", Name: "Syrian Pound", Numeric: "760", MinorUnits: 2},
+	"SZL": Currency{Code: "SZL", Name: "Lilangeni", Numeric: "748", MinorUnits: 2},
+	"THB": Currency{Code: "THB", Name: "Baht", Numeric: "764", MinorUnits: 2},
+	"TJS": Currency{Code: "TJS", Name: "Somoni", Numeric: "972", MinorUnits: 2},
+	"TMT": Currency{Code: "TMT", Name: "Turkmenistan New Manat", Numeric: "934", MinorUnits: 2},
+	"TND": Currency{Code: "TND", Name: "Tunisian Dinar", Numeric: "788", MinorUnits: 3},
+	"TOP": Currency{Code: "TOP", Name: "Pa'anga", Numeric: "776", MinorUnits: 2},
+	"TRL": Currency{Code: "TRL", Name: "Turkish Lira (old)", Numeric: "792", MinorUnits: 0},
+	"TRY": Currency{Code: "TRY", Name: "Turkish Lira", Numeric: "949", MinorUnits: 2},
+	"TTD": Currency{Code: "TTD", Name: "Trinidad and Tobago Dollar", Numeric: "780", MinorUnits: 2},
+	"TWD": Currency{Code: "TWD", Name: "New Taiwan Dollar", Numeric: "901", MinorUnits: 2},
+	"TZS": Currency{Code: "TZS", Name: "Tanzanian Shilling", Numeric: "834", MinorUnits: 2},
+	"UAH": Currency{Code: "UAH", Name: "Hryvnia", Numeric: "980", MinorUnits: 2},
+	"UGX": Currency{Code: "UGX", Name: "Uganda Shilling", Numeric: "800", MinorUnits: 0},
+	"USD": Currency{Code: "USD", Name: "US Dollar", Numeric: "840", MinorUnits: 2},
+	"UYU": Currency{Code: "UYU", Name: "Peso Uruguayo", Numeric: "858", MinorUnits: 2},
+	"UZS": Currency{Code: "UZS", Name: "Uzbekistan Sum", Numeric: "860", MinorUnits: 2},
+	"VED": Currency{Code: "VED", Name: "Bolívar Soberano", Numeric: "926", MinorUnits: 2},
+	"VES": Currency{Code: "VES", Name: "Bolívar Soberano", Numeric: "928", MinorUnits: 2},
+	"VND": Currency{Code: "VND", Name: "Dong", Numeric: "704", MinorUnits: 0},
+	"VUV": Currency{Code: "VUV", Name: "Vatu", Numeric: "548", MinorUnits: 0},
+	"WST": Currency{Code: "WST", Name: "Tala", Numeric: "882", MinorUnits: 2},
+	"XAF": Currency{Code: "XAF", Name: "CFA Franc BEAC", Numeric: "950", MinorUnits: 0},
+	"XCD": Currency{Code: "XCD", Name: "East Caribbean Dollar", Numeric: "951", MinorUnits: 2},
+	"XCG": Currency{Code: "XCG", Name: "Caribbean Guilder", Numeric: "532", MinorUnits: 2},
+	"XOF": Currency{Code: "XOF", Name: "CFA Franc BCEAO", Numeric: "952", MinorUnits: 0},
+	"XPF": Currency{Code: "XPF", Name: "CFP Franc", Numeric: "953", MinorUnits: 0},
+	"YER": Currency{Code: "YER", Name: "Yemeni Rial", Numeric: "886", MinorUnits: 2},
+	"ZAR": Currency{Code: "ZAR", Name: "Rand", Numeric: "710", MinorUnits: 2},
+	"ZMW": Currency{Code: "ZMW", Name: "Zambian Kwacha", Numeric: "967", MinorUnits: 2},
+	"ZWG": Currency{Code: "ZWG", Name: "Zimbabwe Gold", Numeric: "924", MinorUnits: 2},
+}
@@ -0,0 +1,64 @@
+package iso4217
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   string
+		want   Currency
+		wantOk bool
+	}{
+		struct {
+			name   string
+			code   string
+			want   Currency
+			wantOk bool
+		}{
+			name:   "Current currency",
+			code:   "usd",
+			want:   Currency{Code: "USD", Name: "US Dollar", Numeric: "840", MinorUnits: 2},
+			wantOk: true,
+		},
+		struct {
+			name   string
+			code   string
+			want   Currency
+			wantOk bool
+		}{
+			name:   "Withdrawn currency published by the ECB",
+			code:   "HRK",
+			want:   Currency{Code: "HRK", Name: "Kuna", Numeric: "191", MinorUnits: 2},
+			wantOk: true,
+		},
+		struct {
+			name   string
+			code   string
+			want   Currency
+			wantOk bool
+		}{
+			name: "Unknown code",
+			code: "HPH",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Lookup(tt.code)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("Lookup() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_currencies(t *testing.T) {
+	numericPattern := regexp.MustCompile(`^[0-9]{3}$`)
+	for code, currency := range currencies {
+		if currency.Code != code || currency.Name == "" || !numericPattern.MatchString(currency.Numeric) || currency.MinorUnits < 0 {
+			t.Errorf("currencies[%v] = %+v", code, currency)
+		}
+	}
+}
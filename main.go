@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/emanpicar/currency-api/auth"
 	"github.com/emanpicar/currency-api/db"
 	"github.com/emanpicar/currency-api/envelope"
+	"github.com/emanpicar/currency-api/fetcher"
 	"github.com/emanpicar/currency-api/logger"
+	"github.com/emanpicar/currency-api/ratelimit"
 	"github.com/emanpicar/currency-api/routes"
+	"github.com/emanpicar/currency-api/scheduler"
 	"github.com/emanpicar/currency-api/settings"
 )
 
@@ -17,15 +25,46 @@ func main() {
 	logger.Log.Infoln("Initializing Currency API")
 
 	dbManager := db.NewManager()
-	envelopeManager := envelope.NewManager(dbManager)
-	authHandler := auth.NewManager()
+	envelopeManager := envelope.NewManager(dbManager, fetcher.NewManager())
+	authHandler := auth.NewManager(dbManager)
+	rateLimitManager := ratelimit.NewManager(dbManager)
 
 	envelopeManager.UpsertInitialData()
 
-	logger.Log.Fatal(http.ListenAndServeTLS(
-		fmt.Sprintf("%v:%v", settings.GetServerHost(), settings.GetServerPort()),
-		settings.GetServerPublicKey(),
-		settings.GetServerPrivateKey(),
-		routes.NewRouter(envelopeManager, authHandler),
-	))
+	if source := settings.GetHistoryImportSource(); source != "" {
+		go func() {
+			if _, err := envelopeManager.ImportHistory(source); err != nil {
+				logger.Log.Errorf("Unable to import rate history: %v", err)
+			}
+		}()
+	}
+
+	refreshScheduler := scheduler.NewManager("rates-refresh", envelopeManager.RefreshRates)
+	refreshScheduler.Start()
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%v:%v", settings.GetServerHost(), settings.GetServerPort()),
+		Handler: routes.NewRouter(envelopeManager, authHandler, rateLimitManager),
+	}
+
+	go func() {
+		err := server.ListenAndServeTLS(settings.GetServerPublicKey(), settings.GetServerPrivateKey())
+		if err != nil && err != http.ErrServerClosed {
+			logger.Log.Fatal(err)
+		}
+	}()
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	<-shutdown
+
+	logger.Log.Infoln("Shutting down Currency API")
+	refreshScheduler.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Log.Errorf("Unable to shut down server gracefully: %v", err)
+	}
 }
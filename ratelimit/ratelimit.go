@@ -0,0 +1,186 @@
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emanpicar/currency-api/logger"
+	"github.com/emanpicar/currency-api/settings"
+)
+
+type (
+	Manager interface {
+		Allow(subject, route string) *Result
+	}
+
+	// Result describes the state of the subject's bucket and quota after a request, used for the X-RateLimit-* headers
+	Result struct {
+		Allowed    bool
+		Limit      int
+		Remaining  int
+		Reset      time.Duration
+		RetryAfter time.Duration
+
+		QuotaLimit     int
+		QuotaRemaining int
+		QuotaReset     time.Duration
+	}
+
+	quotaManager interface {
+		IncrementQuotaUsage(subject string, day time.Time, weight int) (int, error)
+	}
+
+	limiterHandler struct {
+		quotaManager quotaManager
+		enabled      bool
+		perSecond    float64
+		burst        int
+		weights      map[string]int
+		dailyQuota   int
+		now          func() time.Time
+
+		mutex     sync.Mutex
+		buckets   map[string]*bucket
+		lastSweep time.Time
+	}
+
+	bucket struct {
+		tokens    float64
+		updatedAt time.Time
+	}
+)
+
+// sweepInterval bounds how often idle buckets are dropped from memory
+const sweepInterval = time.Minute
+
+func NewManager(quotaManager quotaManager) Manager {
+	return &limiterHandler{
+		quotaManager: quotaManager,
+		enabled:      settings.GetRateLimitEnabled(),
+		perSecond:    float64(settings.GetRateLimitPerMinute()) / 60,
+		burst:        settings.GetRateLimitBurst(),
+		weights:      parseRouteWeights(settings.GetRateLimitRouteWeights()),
+		dailyQuota:   settings.GetDailyQuota(),
+		now:          time.Now,
+		buckets:      make(map[string]*bucket),
+	}
+}
+
+// Allow takes the weight of route from the subject's token bucket, then counts it against the daily quota if any.
+// Quota storage errors are logged and the request is let through.
+func (l *limiterHandler) Allow(subject, route string) *Result {
+	if !l.enabled || l.perSecond <= 0 || l.burst <= 0 {
+		return &Result{Allowed: true}
+	}
+
+	weight := l.weight(route)
+	now := l.now()
+	result := l.takeTokens(subject, weight, now)
+	if !result.Allowed || l.dailyQuota <= 0 || l.quotaManager == nil {
+		return result
+	}
+
+	day := now.UTC().Truncate(24 * time.Hour)
+	used, err := l.quotaManager.IncrementQuotaUsage(subject, day, weight)
+	if err != nil {
+		logger.Log.Errorf("Unable to count quota usage of %v: %v", subject, err)
+		return result
+	}
+
+	result.QuotaLimit = l.dailyQuota
+	result.QuotaRemaining = l.dailyQuota - used
+	result.QuotaReset = day.Add(24 * time.Hour).Sub(now)
+	if result.QuotaRemaining < 0 {
+		result.Allowed = false
+		result.QuotaRemaining = 0
+		result.RetryAfter = result.QuotaReset
+		logger.Log.Warnf("Daily quota of %v exceeded by %v", l.dailyQuota, subject)
+	}
+
+	return result
+}
+
+func (l *limiterHandler) takeTokens(subject string, weight int, now time.Time) *Result {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[subject]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), updatedAt: now}
+		l.buckets[subject] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.updatedAt = now
+
+	result := &Result{Limit: l.burst}
+	if b.tokens >= float64(weight) {
+		b.tokens -= float64(weight)
+		result.Allowed = true
+	} else {
+		result.RetryAfter = l.timeToRefill(float64(weight) - b.tokens)
+	}
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = l.timeToRefill(float64(l.burst) - b.tokens)
+
+	return result
+}
+
+func (l *limiterHandler) refill(b *bucket, now time.Time) float64 {
+	return math.Min(float64(l.burst), b.tokens+now.Sub(b.updatedAt).Seconds()*l.perSecond)
+}
+
+func (l *limiterHandler) timeToRefill(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens / l.perSecond * float64(time.Second)))
+}
+
+// sweep drops buckets that have refilled completely, they are indistinguishable from new ones
+func (l *limiterHandler) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for subject, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.burst) {
+			delete(l.buckets, subject)
+		}
+	}
+}
+
+// weight is capped at the burst so an expensive route can still be called with a full bucket
+func (l *limiterHandler) weight(route string) int {
+	weight, ok := l.weights[route]
+	if !ok || weight < 1 {
+		weight = 1
+	}
+	if weight > l.burst {
+		weight = l.burst
+	}
+
+	return weight
+}
+
+func parseRouteWeights(routeWeights []string) map[string]int {
+	weights := make(map[string]int)
+	for _, routeWeight := range routeWeights {
+		parts := strings.SplitN(routeWeight, "=", 2)
+		if len(parts) != 2 {
+			logger.Log.Warnf("Ignoring rate limit route weight %q, expected Route=weight", routeWeight)
+			continue
+		}
+
+		weight, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || weight < 1 {
+			logger.Log.Warnf("Ignoring rate limit route weight %q, weight must be a positive integer", routeWeight)
+			continue
+		}
+		weights[strings.TrimSpace(parts[0])] = weight
+	}
+
+	return weights
+}
@@ -0,0 +1,216 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type (
+	quotaManagerMock struct {
+		usage map[string]int
+		err   error
+	}
+)
+
+func (q *quotaManagerMock) IncrementQuotaUsage(subject string, day time.Time, weight int) (int, error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+
+	key := subject + day.Format("2006-01-02")
+	q.usage[key] += weight
+
+	return q.usage[key], nil
+}
+
+func newTestLimiter(now *time.Time, dailyQuota int, quotaErr error) *limiterHandler {
+	return &limiterHandler{
+		quotaManager: &quotaManagerMock{usage: make(map[string]int), err: quotaErr},
+		enabled:      true,
+		perSecond:    1,
+		burst:        10,
+		weights:      parseRouteWeights([]string{"RatesAnalyze=4", "Broken", "RatesTimeSeries=-1"}),
+		dailyQuota:   dailyQuota,
+		now:          func() time.Time { return *now },
+		buckets:      make(map[string]*bucket),
+	}
+}
+
+func Test_limiterHandler_Allow(t *testing.T) {
+	start := time.Date(2020, 6, 1, 23, 59, 0, 0, time.UTC)
+
+	type request struct {
+		subject string
+		route   string
+		after   time.Duration
+	}
+	tests := []struct {
+		name           string
+		dailyQuota     int
+		quotaErr       error
+		requests       []request
+		wantAllowed    bool
+		wantRemaining  int
+		wantRetryAfter time.Duration
+	}{
+		struct {
+			name           string
+			dailyQuota     int
+			quotaErr       error
+			requests       []request
+			wantAllowed    bool
+			wantRemaining  int
+			wantRetryAfter time.Duration
+		}{
+			name:          "Weighted route takes its weight",
+			requests:      []request{request{subject: "user123", route: "RatesAnalyze"}},
+			wantAllowed:   true,
+			wantRemaining: 6,
+		},
+		struct {
+			name           string
+			dailyQuota     int
+			quotaErr       error
+			requests       []request
+			wantAllowed    bool
+			wantRemaining  int
+			wantRetryAfter time.Duration
+		}{
+			name: "Burst exhausted",
+			requests: []request{
+				request{subject: "user123", route: "RatesAnalyze"},
+				request{subject: "user123", route: "RatesAnalyze"},
+				request{subject: "user123", route: "RatesAnalyze"},
+			},
+			wantAllowed:    false,
+			wantRemaining:  2,
+			wantRetryAfter: 2 * time.Second,
+		},
+		struct {
+			name           string
+			dailyQuota     int
+			quotaErr       error
+			requests       []request
+			wantAllowed    bool
+			wantRemaining  int
+			wantRetryAfter time.Duration
+		}{
+			name: "Bucket refills over time",
+			requests: []request{
+				request{subject: "user123", route: "RatesAnalyze"},
+				request{subject: "user123", route: "RatesAnalyze"},
+				request{subject: "user123", route: "RatesAnalyze", after: 2 * time.Second},
+			},
+			wantAllowed:   true,
+			wantRemaining: 0,
+		},
+		struct {
+			name           string
+			dailyQuota     int
+			quotaErr       error
+			requests       []request
+			wantAllowed    bool
+			wantRemaining  int
+			wantRetryAfter time.Duration
+		}{
+			name: "Subjects have separate buckets",
+			requests: []request{
+				request{subject: "user123", route: "RatesAnalyze"},
+				request{subject: "user123", route: "RatesAnalyze"},
+				request{subject: "cak_other", route: "RatesLatest"},
+			},
+			wantAllowed:   true,
+			wantRemaining: 9,
+		},
+		struct {
+			name           string
+			dailyQuota     int
+			quotaErr       error
+			requests       []request
+			wantAllowed    bool
+			wantRemaining  int
+			wantRetryAfter time.Duration
+		}{
+			name:       "Daily quota exceeded until midnight UTC",
+			dailyQuota: 5,
+			requests: []request{
+				request{subject: "user123", route: "RatesAnalyze"},
+				request{subject: "user123", route: "RatesAnalyze", after: 10 * time.Second},
+			},
+			wantAllowed:    false,
+			wantRemaining:  6,
+			wantRetryAfter: 50 * time.Second,
+		},
+		struct {
+			name           string
+			dailyQuota     int
+			quotaErr       error
+			requests       []request
+			wantAllowed    bool
+			wantRemaining  int
+			wantRetryAfter time.Duration
+		}{
+			name:       "Daily quota resets the next day",
+			dailyQuota: 5,
+			requests: []request{
+				request{subject: "user123", route: "RatesAnalyze"},
+				request{subject: "user123", route: "RatesAnalyze", after: time.Minute},
+			},
+			wantAllowed:   true,
+			wantRemaining: 6,
+		},
+		struct {
+			name           string
+			dailyQuota     int
+			quotaErr       error
+			requests       []request
+			wantAllowed    bool
+			wantRemaining  int
+			wantRetryAfter time.Duration
+		}{
+			name:          "Quota storage failure lets the request through",
+			dailyQuota:    1,
+			quotaErr:      errors.New("connection refused"),
+			requests:      []request{request{subject: "user123", route: "RatesAnalyze"}},
+			wantAllowed:   true,
+			wantRemaining: 6,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start
+			l := newTestLimiter(&now, tt.dailyQuota, tt.quotaErr)
+
+			var got *Result
+			for _, request := range tt.requests {
+				now = now.Add(request.after)
+				got = l.Allow(request.subject, request.route)
+			}
+
+			if got.Allowed != tt.wantAllowed {
+				t.Errorf("limiterHandler.Allow() allowed = %v, want %v", got.Allowed, tt.wantAllowed)
+			}
+			if got.Remaining != tt.wantRemaining {
+				t.Errorf("limiterHandler.Allow() remaining = %v, want %v", got.Remaining, tt.wantRemaining)
+			}
+			if got.RetryAfter != tt.wantRetryAfter {
+				t.Errorf("limiterHandler.Allow() retry after = %v, want %v", got.RetryAfter, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func Test_limiterHandler_sweep(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := newTestLimiter(&now, 0, nil)
+
+	l.Allow("user123", "RatesLatest")
+	l.Allow("cak_other", "RatesLatest")
+	now = now.Add(sweepInterval)
+	l.Allow("user123", "RatesLatest")
+
+	if len(l.buckets) != 1 {
+		t.Errorf("limiterHandler.sweep() buckets = %v, want 1", len(l.buckets))
+	}
+}
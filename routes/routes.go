@@ -1,13 +1,27 @@
 package routes
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/emanpicar/currency-api/apperror"
 	"github.com/emanpicar/currency-api/auth"
+	"github.com/emanpicar/currency-api/decimal"
 	"github.com/emanpicar/currency-api/entities/jsondata"
 	"github.com/emanpicar/currency-api/envelope"
 	"github.com/emanpicar/currency-api/logger"
+	"github.com/emanpicar/currency-api/ratelimit"
+	"github.com/emanpicar/currency-api/settings"
 	"github.com/gorilla/mux"
 )
 
@@ -16,15 +30,53 @@ type (
 		ServeHTTP(http.ResponseWriter, *http.Request)
 	}
 
+	requestIDContextKey struct{}
+
 	routeHandler struct {
-		envelopeManager envelope.Manager
-		authManager     auth.Manager
-		router          *mux.Router
+		envelopeManager  envelope.Manager
+		authManager      auth.Manager
+		rateLimitManager ratelimit.Manager
+		router           *mux.Router
+	}
+
+	// uploadBody tells whether the upload failed because MaxBytesReader refused a body larger than limit
+	uploadBody struct {
+		reader   io.Reader
+		limit    int64
+		read     int64
+		exceeded bool
+	}
+)
+
+const (
+	requestIDHeader  = "X-Request-ID"
+	provenanceHeader = "X-Rates-Provenance"
+)
+
+var (
+	validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+	statusCodes    = map[apperror.Kind]int{
+		apperror.Internal:     http.StatusInternalServerError,
+		apperror.BadRequest:   http.StatusBadRequest,
+		apperror.Unauthorized: http.StatusUnauthorized,
+		apperror.Forbidden:    http.StatusForbidden,
+		apperror.NotFound:     http.StatusNotFound,
+		apperror.Invalid:      http.StatusUnprocessableEntity,
+		apperror.RateLimited:  http.StatusTooManyRequests,
+		apperror.Unavailable:  http.StatusServiceUnavailable,
+		apperror.Conflict:     http.StatusConflict,
+		apperror.TooLarge:     http.StatusRequestEntityTooLarge,
 	}
+
+	errInvalidAmount     = apperror.New(apperror.Invalid, "invalid_amount", "Invalid amount")
+	errInvalidLimit      = apperror.New(apperror.Invalid, "invalid_limit", "Invalid limit")
+	errRateLimitExceeded = apperror.New(apperror.RateLimited, "rate_limit_exceeded", "Rate limit exceeded")
+	errRouteNotFound     = apperror.New(apperror.NotFound, "route_not_found", "Route not found")
+	errUploadTooLarge    = apperror.New(apperror.TooLarge, "upload_too_large", "Upload too large")
 )
 
-func NewRouter(envelopeManager envelope.Manager, authManager auth.Manager) Router {
-	routeHandler := &routeHandler{envelopeManager: envelopeManager, authManager: authManager}
+func NewRouter(envelopeManager envelope.Manager, authManager auth.Manager, rateLimitManager ratelimit.Manager) Router {
+	routeHandler := &routeHandler{envelopeManager: envelopeManager, authManager: authManager, rateLimitManager: rateLimitManager}
 
 	return routeHandler.newRouter(mux.NewRouter())
 }
@@ -36,30 +88,121 @@ func (rh *routeHandler) newRouter(router *mux.Router) *mux.Router {
 }
 
 func (rh *routeHandler) registerRoutes(router *mux.Router) {
+	router.Use(rh.requestIDMiddleware)
+	router.NotFoundHandler = rh.requestIDMiddleware(http.HandlerFunc(rh.notFound))
+
+	router.HandleFunc("/health", rh.getHealth).Methods(http.MethodGet).Name("Health")
+	router.HandleFunc("/.well-known/jwks.json", rh.getJWKS).Methods(http.MethodGet).Name("JWKS")
 	router.HandleFunc("/api/auth", rh.authenticate).Methods(http.MethodPost).Name("Auth")
-	router.HandleFunc("/rates/latest", rh.authMiddleware(rh.getLatestRates)).Methods(http.MethodGet).Name("RatesLatest")
-	router.HandleFunc("/rates/analyze", rh.authMiddleware(rh.getAnalyzedRates)).Methods(http.MethodGet).Name("RatesAnalyze")
-	router.HandleFunc("/rates/{cubeTime:[0-9]{4}-[0-9]{2}-[0-9]{2}}", rh.authMiddleware(rh.getRatesByDate)).Methods(http.MethodGet).Name("RatesByDate")
+	router.HandleFunc("/api/auth/refresh", rh.refreshToken).Methods(http.MethodPost).Name("AuthRefresh")
+	router.HandleFunc("/api/auth/logout", rh.logout).Methods(http.MethodPost).Name("AuthLogout")
+	router.HandleFunc("/api/keys", rh.authMiddleware(rh.createAPIKey)).Methods(http.MethodPost).Name("APIKeysCreate")
+	router.HandleFunc("/api/keys", rh.authMiddleware(rh.listAPIKeys)).Methods(http.MethodGet).Name("APIKeysList")
+	router.HandleFunc("/api/keys/{prefix}", rh.authMiddleware(rh.revokeAPIKey)).Methods(http.MethodDelete).Name("APIKeysRevoke")
+	router.HandleFunc("/rates/latest", rh.authMiddleware(rh.withProvenance(rh.getLatestRates), auth.ScopeRatesRead)).Methods(http.MethodGet).Name("RatesLatest")
+	router.HandleFunc("/rates/convert", rh.authMiddleware(rh.withProvenance(rh.convertAmount), auth.ScopeRatesRead)).Methods(http.MethodGet).Name("RatesConvert")
+	router.HandleFunc("/rates/timeseries", rh.authMiddleware(rh.withProvenance(rh.getTimeSeries), auth.ScopeRatesRead)).Methods(http.MethodGet).Name("RatesTimeSeries")
+	router.HandleFunc("/rates/analyze", rh.authMiddleware(rh.withProvenance(rh.getAnalyzedRates), auth.ScopeRatesRead)).Methods(http.MethodGet).Name("RatesAnalyze")
+	router.HandleFunc("/currencies", rh.authMiddleware(rh.withProvenance(rh.listCurrencies), auth.ScopeRatesRead)).Methods(http.MethodGet).Name("Currencies")
+	router.HandleFunc("/rates/{cubeTime:[0-9]{4}-[0-9]{2}-[0-9]{2}}", rh.authMiddleware(rh.withProvenance(rh.getRatesByDate), auth.ScopeRatesRead)).Methods(http.MethodGet).Name("RatesByDate")
+	router.HandleFunc("/admin/ingestions", rh.authMiddleware(rh.listIngestionRuns, auth.ScopeRatesAdmin)).Methods(http.MethodGet).Name("AdminIngestions")
+	router.HandleFunc("/admin/ingestions/refresh", rh.authMiddleware(rh.refreshRates, auth.ScopeRatesAdmin)).Methods(http.MethodPost).Name("AdminRefresh")
+	router.HandleFunc("/admin/quarantine", rh.authMiddleware(rh.listQuarantinedDays, auth.ScopeRatesAdmin)).Methods(http.MethodGet).Name("AdminQuarantine")
+	router.HandleFunc("/admin/ingestions/upload", rh.authMiddleware(rh.uploadRates, auth.ScopeRatesAdmin)).Methods(http.MethodPost).Name("AdminUpload")
 
 	rh.router = router
 }
 
+// getHealth reports degraded while the served rates were not downloaded since startup, it keeps answering 200
+// since stored rates are still served
+func (rh *routeHandler) getHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	health := &jsondata.Health{Status: "ok", Provenance: rh.envelopeManager.GetProvenance()}
+	if health.Provenance != envelope.ProvenanceLive {
+		health.Status = "degraded"
+	}
+
+	if lastRefresh := rh.envelopeManager.GetLastRefresh(); !lastRefresh.IsZero() {
+		health.LastRefresh = &lastRefresh
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(health), w)
+}
+
+func (rh *routeHandler) getJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	rh.encodeError(json.NewEncoder(w).Encode(rh.authManager.GetJWKS()), w)
+}
+
 func (rh *routeHandler) authenticate(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	data, err := rh.authManager.Authenticate(r.Body)
 	if err != nil {
-		rh.badRequest(err, w)
+		rh.writeError(err, w, r)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) refreshToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.authManager.Refresh(r.Body)
+	if err != nil {
+		rh.writeError(err, w, r)
 		return
 	}
 
 	rh.encodeError(json.NewEncoder(w).Encode(data), w)
 }
 
+func (rh *routeHandler) logout(w http.ResponseWriter, r *http.Request) {
+	if err := rh.authManager.Logout(r); err != nil {
+		rh.writeError(err, w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rh *routeHandler) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	result, err := rh.authManager.CreateAPIKey(auth.FromContext(r.Context()), r.Body)
+	if err != nil {
+		rh.writeError(err, w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	rh.encodeError(json.NewEncoder(w).Encode(result), w)
+}
+
+func (rh *routeHandler) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	result, err := rh.authManager.ListAPIKeys(auth.FromContext(r.Context()))
+	if err != nil {
+		rh.writeError(err, w, r)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(result), w)
+}
+
+func (rh *routeHandler) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	err := rh.authManager.RevokeAPIKey(auth.FromContext(r.Context()), mux.Vars(r)["prefix"])
+	if err != nil {
+		rh.writeError(err, w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (rh *routeHandler) getLatestRates(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	result, err := rh.envelopeManager.GetLatestRates()
+	result, err := rh.envelopeManager.GetLatestRates(r.URL.Query().Get("base"), r.URL.Query().Get("as_of"))
 	if err != nil {
-		rh.badRequest(err, w)
+		rh.writeError(err, w, r)
 		return
 	}
 
@@ -68,9 +211,10 @@ func (rh *routeHandler) getLatestRates(w http.ResponseWriter, r *http.Request) {
 
 func (rh *routeHandler) getRatesByDate(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	result, err := rh.envelopeManager.GetRatesByDate(mux.Vars(r)["cubeTime"])
+	query := r.URL.Query()
+	result, err := rh.envelopeManager.GetRatesByDate(mux.Vars(r)["cubeTime"], query.Get("base"), query.Get("fallback"), query.Get("as_of"))
 	if err != nil {
-		rh.badRequest(err, w)
+		rh.writeError(err, w, r)
 		return
 	}
 
@@ -79,27 +223,242 @@ func (rh *routeHandler) getRatesByDate(w http.ResponseWriter, r *http.Request) {
 
 func (rh *routeHandler) getAnalyzedRates(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	result, err := rh.envelopeManager.GetAnalyzedRates()
+	query := r.URL.Query()
+	result, err := rh.envelopeManager.GetAnalyzedRates(query.Get("start"), query.Get("end"), rh.splitSymbols(query.Get("symbols")), query.Get("base"), query.Get("as_of"))
+	if err != nil {
+		rh.writeError(err, w, r)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(result), w)
+}
+
+func (rh *routeHandler) convertAmount(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+
+	amount, err := decimal.NewFromString(query.Get("amount"))
+	if err != nil || amount.Sign() <= 0 {
+		rh.writeError(errInvalidAmount.WithMessage(fmt.Sprintf("Invalid amount: %q", query.Get("amount"))), w, r)
+		return
+	}
+
+	result, err := rh.envelopeManager.ConvertAmount(query.Get("from"), query.Get("to"), amount, query.Get("date"), query.Get("as_of"))
+	if err != nil {
+		rh.writeError(err, w, r)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(result), w)
+}
+
+func (rh *routeHandler) getTimeSeries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+
+	result, err := rh.envelopeManager.GetTimeSeries(query.Get("start"), query.Get("end"), rh.splitSymbols(query.Get("symbols")), query.Get("base"), query.Get("as_of"))
+	if err != nil {
+		rh.writeError(err, w, r)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(result), w)
+}
+
+func (rh *routeHandler) listIngestionRuns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+
+	limit, err := rh.parseLimit(query.Get("limit"))
+	if err != nil {
+		rh.writeError(err, w, r)
+		return
+	}
+
+	result, err := rh.envelopeManager.ListIngestionRuns(query.Get("source"), limit)
+	if err != nil {
+		rh.writeError(err, w, r)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(result), w)
+}
+
+func (rh *routeHandler) listCurrencies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	result, err := rh.envelopeManager.ListCurrencies()
+	if err != nil {
+		rh.writeError(err, w, r)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(result), w)
+}
+
+func (rh *routeHandler) listQuarantinedDays(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+
+	limit, err := rh.parseLimit(query.Get("limit"))
+	if err != nil {
+		rh.writeError(err, w, r)
+		return
+	}
+
+	result, err := rh.envelopeManager.ListQuarantinedDays(query.Get("source"), limit)
+	if err != nil {
+		rh.writeError(err, w, r)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(result), w)
+}
+
+// parseLimit returns 0 for an empty limit so the default applies, otherwise a positive number
+func (rh *routeHandler) parseLimit(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, errInvalidLimit.WithMessage(fmt.Sprintf("Invalid limit: %q", value))
+	}
+
+	return limit, nil
+}
+
+func (rh *routeHandler) refreshRates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	result, err := rh.envelopeManager.RefreshNow()
+	if err != nil {
+		rh.writeError(err, w, r)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(result), w)
+}
+
+// uploadRates reads the format from the format parameter, otherwise csv when the Content-Type says so and xml by default
+func (rh *routeHandler) uploadRates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = "xml"
+		if strings.Contains(r.Header.Get("Content-Type"), "csv") {
+			format = "csv"
+		}
+	}
+
+	maxBytes := int64(settings.GetUploadMaxBytes())
+	tooLarge := errUploadTooLarge.WithMessage(fmt.Sprintf("Upload exceeds %v bytes", maxBytes)).
+		WithDetails(map[string]interface{}{"max_bytes": maxBytes})
+	if r.ContentLength > maxBytes {
+		rh.writeError(tooLarge, w, r)
+		return
+	}
+
+	body := &uploadBody{reader: http.MaxBytesReader(w, r.Body, maxBytes), limit: maxBytes}
+	result, err := rh.envelopeManager.UploadRates(body, format, query.Get("source"))
+	if err != nil && body.exceeded {
+		err = tooLarge.WithErr(err)
+	}
 	if err != nil {
-		rh.badRequest(err, w)
+		rh.writeError(err, w, r)
 		return
 	}
 
+	w.WriteHeader(http.StatusCreated)
 	rh.encodeError(json.NewEncoder(w).Encode(result), w)
 }
 
-func (rh *routeHandler) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+func (b *uploadBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.read >= b.limit {
+		b.exceeded = true
+	}
+
+	return n, err
+}
+
+// withProvenance tells in the X-Rates-Provenance header whether the rates are live, from a snapshot, demo data or none
+func (rh *routeHandler) withProvenance(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(provenanceHeader, rh.envelopeManager.GetProvenance())
+		next(w, r)
+	})
+}
+
+func (rh *routeHandler) splitSymbols(symbols string) []string {
+	result := []string{}
+	for _, symbol := range strings.Split(symbols, ",") {
+		if symbol = strings.TrimSpace(symbol); symbol != "" {
+			result = append(result, symbol)
+		}
+	}
+
+	return result
+}
+
+// authMiddleware rejects requests without a valid token, or with 403 when the token lacks a required scope.
+// Authenticated requests are then rate limited per token subject or API key.
+func (rh *routeHandler) authMiddleware(next http.HandlerFunc, requiredScopes ...string) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		err := rh.authManager.ValidateRequest(r)
+		principal, err := rh.authManager.ValidateRequest(r, requiredScopes...)
 		if err != nil {
-			rh.badRequest(err, w)
+			rh.writeError(err, w, r)
 			return
 		}
 
-		next(w, r)
+		if !rh.rateLimit(w, r, principal) {
+			return
+		}
+
+		next(w, r.WithContext(auth.NewContext(r.Context(), principal)))
 	})
 }
 
+// rateLimit sets the X-RateLimit-* headers and answers 429 with Retry-After once the bucket or daily quota is exhausted
+func (rh *routeHandler) rateLimit(w http.ResponseWriter, r *http.Request, principal *auth.Principal) bool {
+	if rh.rateLimitManager == nil {
+		return true
+	}
+
+	routeName := ""
+	if route := mux.CurrentRoute(r); route != nil {
+		routeName = route.GetName()
+	}
+
+	result := rh.rateLimitManager.Allow(principal.Subject(), routeName)
+	if result.Limit > 0 {
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("X-RateLimit-Reset", rh.seconds(result.Reset))
+	}
+	if result.QuotaLimit > 0 {
+		w.Header().Set("X-RateLimit-Quota-Limit", strconv.Itoa(result.QuotaLimit))
+		w.Header().Set("X-RateLimit-Quota-Remaining", strconv.Itoa(result.QuotaRemaining))
+		w.Header().Set("X-RateLimit-Quota-Reset", rh.seconds(result.QuotaReset))
+	}
+
+	if !result.Allowed {
+		logger.Log.Warnf("Rate limit exceeded by %v on %v", principal.Subject(), routeName)
+		w.Header().Set("Retry-After", rh.seconds(result.RetryAfter))
+		rh.writeError(errRateLimitExceeded.WithDetails(map[string]interface{}{"retry_after": result.RetryAfter.Seconds()}), w, r)
+		return false
+	}
+
+	return true
+}
+
+func (rh *routeHandler) seconds(duration time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(duration.Seconds())))
+}
+
 func (rh *routeHandler) encodeError(err error, w http.ResponseWriter) {
 	if err != nil {
 		logger.Log.Warnf("Error occurred: %v", err)
@@ -107,8 +466,59 @@ func (rh *routeHandler) encodeError(err error, w http.ResponseWriter) {
 	}
 }
 
-func (rh *routeHandler) badRequest(err error, w http.ResponseWriter) {
-	logger.Log.Warnf("Error occurred: %v", err)
-	w.WriteHeader(http.StatusBadRequest)
-	rh.encodeError(json.NewEncoder(w).Encode(&jsondata.ResponseMessage{err.Error()}), w)
+// writeError maps the error kind to a status code, causes of server errors are only logged, never sent to the client
+func (rh *routeHandler) writeError(err error, w http.ResponseWriter, r *http.Request) {
+	appErr := apperror.As(err)
+	status, ok := statusCodes[appErr.Kind]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+
+	requestID := requestIDFromContext(r.Context())
+	if status >= http.StatusInternalServerError {
+		logger.Log.Errorf("Request %v failed with %v: %v", requestID, status, err)
+	} else {
+		logger.Log.Warnf("Request %v rejected with %v: %v", requestID, status, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	rh.encodeError(json.NewEncoder(w).Encode(&jsondata.Error{
+		Code:      appErr.Code,
+		Message:   appErr.Message,
+		RequestID: requestID,
+		Details:   appErr.Details,
+	}), w)
+}
+
+func (rh *routeHandler) notFound(w http.ResponseWriter, r *http.Request) {
+	rh.writeError(errRouteNotFound, w, r)
+}
+
+// requestIDMiddleware keeps a well-formed incoming X-Request-ID or generates one, and echoes it in the response
+func (rh *routeHandler) requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = rh.newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, requestID)))
+	})
+}
+
+func (rh *routeHandler) newRequestID() string {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		logger.Log.Errorf("Unable to generate request id: %v", err)
+	}
+
+	return hex.EncodeToString(data)
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+
+	return requestID
 }
@@ -1,11 +1,86 @@
 package routes
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/emanpicar/currency-api/apperror"
+	"github.com/emanpicar/currency-api/auth"
+	"github.com/emanpicar/currency-api/decimal"
+	"github.com/emanpicar/currency-api/entities/jsondata"
+	"github.com/emanpicar/currency-api/envelope"
+	"github.com/emanpicar/currency-api/ratelimit"
 	"github.com/gorilla/mux"
 )
 
+type (
+	// authManagerMock embeds auth.Manager so only the methods used by a test need stubs
+	authManagerMock struct {
+		auth.Manager
+		validateErr error
+	}
+
+	rateLimitManagerMock struct {
+		result *ratelimit.Result
+	}
+
+	// envelopeManagerMock embeds envelope.Manager so only the methods used by a test need stubs
+	envelopeManagerMock struct {
+		envelope.Manager
+		provenance  string
+		lastRefresh time.Time
+		uploadErr   error
+	}
+)
+
+func (m *envelopeManagerMock) GetProvenance() string {
+	return m.provenance
+}
+
+func (m *envelopeManagerMock) GetLastRefresh() time.Time {
+	return m.lastRefresh
+}
+
+func (m *envelopeManagerMock) GetLatestRates(base, asOf string) (string, error) {
+	return `{"base": "EUR", "rates": {}}`, nil
+}
+
+func (m *envelopeManagerMock) ConvertAmount(from, to string, amount decimal.Decimal, cubeTime, asOf string) (*jsondata.Conversion, error) {
+	return &jsondata.Conversion{From: from, To: to, Amount: amount, Rate: decimal.New(1), Result: amount}, nil
+}
+
+func (m *envelopeManagerMock) UploadRates(reader io.Reader, format, source string) (*jsondata.IngestionRun, error) {
+	if _, err := ioutil.ReadAll(reader); err != nil {
+		return nil, apperror.New(apperror.Invalid, "invalid_upload", "Invalid upload").WithErr(err)
+	}
+	if m.uploadErr != nil {
+		return nil, m.uploadErr
+	}
+
+	return &jsondata.IngestionRun{Source: "ecb", Trigger: envelope.TriggerUpload}, nil
+}
+
+func (m *rateLimitManagerMock) Allow(subject, route string) *ratelimit.Result {
+	return m.result
+}
+
+func (m *authManagerMock) ValidateRequest(r *http.Request, requiredScopes ...string) (*auth.Principal, error) {
+	if m.validateErr != nil {
+		return nil, m.validateErr
+	}
+
+	return &auth.Principal{Username: "user123", Scopes: requiredScopes}, nil
+}
+
 func Test_routeHandler_registerRoutes(t *testing.T) {
 	dummyRouter := mux.NewRouter()
 	type args struct {
@@ -21,6 +96,28 @@ func Test_routeHandler_registerRoutes(t *testing.T) {
 		args         args
 		routeDetails routeDetails
 	}{
+		struct {
+			name         string
+			rh           *routeHandler
+			args         args
+			routeDetails routeDetails
+		}{
+			name:         "Validate Health route",
+			rh:           &routeHandler{},
+			args:         args{router: dummyRouter},
+			routeDetails: routeDetails{"Health", "/health"},
+		},
+		struct {
+			name         string
+			rh           *routeHandler
+			args         args
+			routeDetails routeDetails
+		}{
+			name:         "Validate JWKS route",
+			rh:           &routeHandler{},
+			args:         args{router: dummyRouter},
+			routeDetails: routeDetails{"JWKS", "/.well-known/jwks.json"},
+		},
 		struct {
 			name         string
 			rh           *routeHandler
@@ -32,6 +129,50 @@ func Test_routeHandler_registerRoutes(t *testing.T) {
 			args:         args{router: dummyRouter},
 			routeDetails: routeDetails{"Auth", "/api/auth"},
 		},
+		struct {
+			name         string
+			rh           *routeHandler
+			args         args
+			routeDetails routeDetails
+		}{
+			name:         "Validate AuthRefresh route",
+			rh:           &routeHandler{},
+			args:         args{router: dummyRouter},
+			routeDetails: routeDetails{"AuthRefresh", "/api/auth/refresh"},
+		},
+		struct {
+			name         string
+			rh           *routeHandler
+			args         args
+			routeDetails routeDetails
+		}{
+			name:         "Validate AuthLogout route",
+			rh:           &routeHandler{},
+			args:         args{router: dummyRouter},
+			routeDetails: routeDetails{"AuthLogout", "/api/auth/logout"},
+		},
+		struct {
+			name         string
+			rh           *routeHandler
+			args         args
+			routeDetails routeDetails
+		}{
+			name:         "Validate APIKeysCreate route",
+			rh:           &routeHandler{},
+			args:         args{router: dummyRouter},
+			routeDetails: routeDetails{"APIKeysCreate", "/api/keys"},
+		},
+		struct {
+			name         string
+			rh           *routeHandler
+			args         args
+			routeDetails routeDetails
+		}{
+			name:         "Validate APIKeysRevoke route",
+			rh:           &routeHandler{},
+			args:         args{router: dummyRouter},
+			routeDetails: routeDetails{"APIKeysRevoke", "/api/keys/{prefix}"},
+		},
 		struct {
 			name         string
 			rh           *routeHandler
@@ -43,6 +184,28 @@ func Test_routeHandler_registerRoutes(t *testing.T) {
 			args:         args{router: dummyRouter},
 			routeDetails: routeDetails{"RatesLatest", "/rates/latest"},
 		},
+		struct {
+			name         string
+			rh           *routeHandler
+			args         args
+			routeDetails routeDetails
+		}{
+			name:         "Validate RatesConvert route",
+			rh:           &routeHandler{},
+			args:         args{router: dummyRouter},
+			routeDetails: routeDetails{"RatesConvert", "/rates/convert"},
+		},
+		struct {
+			name         string
+			rh           *routeHandler
+			args         args
+			routeDetails routeDetails
+		}{
+			name:         "Validate RatesTimeSeries route",
+			rh:           &routeHandler{},
+			args:         args{router: dummyRouter},
+			routeDetails: routeDetails{"RatesTimeSeries", "/rates/timeseries"},
+		},
 		struct {
 			name         string
 			rh           *routeHandler
@@ -65,6 +228,61 @@ func Test_routeHandler_registerRoutes(t *testing.T) {
 			args:         args{router: dummyRouter},
 			routeDetails: routeDetails{"RatesByDate", "/rates/{cubeTime:[0-9]{4}-[0-9]{2}-[0-9]{2}}"},
 		},
+		struct {
+			name         string
+			rh           *routeHandler
+			args         args
+			routeDetails routeDetails
+		}{
+			name:         "Validate AdminIngestions route",
+			rh:           &routeHandler{},
+			args:         args{router: dummyRouter},
+			routeDetails: routeDetails{"AdminIngestions", "/admin/ingestions"},
+		},
+		struct {
+			name         string
+			rh           *routeHandler
+			args         args
+			routeDetails routeDetails
+		}{
+			name:         "Validate AdminRefresh route",
+			rh:           &routeHandler{},
+			args:         args{router: dummyRouter},
+			routeDetails: routeDetails{"AdminRefresh", "/admin/ingestions/refresh"},
+		},
+		struct {
+			name         string
+			rh           *routeHandler
+			args         args
+			routeDetails routeDetails
+		}{
+			name:         "Validate AdminUpload route",
+			rh:           &routeHandler{},
+			args:         args{router: dummyRouter},
+			routeDetails: routeDetails{"AdminUpload", "/admin/ingestions/upload"},
+		},
+		struct {
+			name         string
+			rh           *routeHandler
+			args         args
+			routeDetails routeDetails
+		}{
+			name:         "Validate AdminQuarantine route",
+			rh:           &routeHandler{},
+			args:         args{router: dummyRouter},
+			routeDetails: routeDetails{"AdminQuarantine", "/admin/quarantine"},
+		},
+		struct {
+			name         string
+			rh           *routeHandler
+			args         args
+			routeDetails routeDetails
+		}{
+			name:         "Validate Currencies route",
+			rh:           &routeHandler{},
+			args:         args{router: dummyRouter},
+			routeDetails: routeDetails{"Currencies", "/currencies"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -77,3 +295,402 @@ func Test_routeHandler_registerRoutes(t *testing.T) {
 		})
 	}
 }
+
+func Test_routeHandler_authMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		rh             *routeHandler
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		struct {
+			name           string
+			rh             *routeHandler
+			wantStatus     int
+			wantRetryAfter string
+		}{
+			name:       "Authorized",
+			rh:         &routeHandler{authManager: &authManagerMock{}},
+			wantStatus: http.StatusOK,
+		},
+		struct {
+			name           string
+			rh             *routeHandler
+			wantStatus     int
+			wantRetryAfter string
+		}{
+			name:       "Insufficient scope",
+			rh:         &routeHandler{authManager: &authManagerMock{validateErr: fmt.Errorf("%w: rates:admin required", auth.ErrInsufficientScope)}},
+			wantStatus: http.StatusForbidden,
+		},
+		struct {
+			name           string
+			rh             *routeHandler
+			wantStatus     int
+			wantRetryAfter string
+		}{
+			name:       "Invalid token",
+			rh:         &routeHandler{authManager: &authManagerMock{validateErr: apperror.New(apperror.Unauthorized, "invalid_token", "Invalid authorization token")}},
+			wantStatus: http.StatusUnauthorized,
+		},
+		struct {
+			name           string
+			rh             *routeHandler
+			wantStatus     int
+			wantRetryAfter string
+		}{
+			name: "Within rate limit",
+			rh: &routeHandler{
+				authManager:      &authManagerMock{},
+				rateLimitManager: &rateLimitManagerMock{result: &ratelimit.Result{Allowed: true, Limit: 20, Remaining: 19}},
+			},
+			wantStatus: http.StatusOK,
+		},
+		struct {
+			name           string
+			rh             *routeHandler
+			wantStatus     int
+			wantRetryAfter string
+		}{
+			name: "Rate limit exceeded",
+			rh: &routeHandler{
+				authManager:      &authManagerMock{},
+				rateLimitManager: &rateLimitManagerMock{result: &ratelimit.Result{Allowed: false, Limit: 20, RetryAfter: 1500 * time.Millisecond}},
+			},
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler := tt.rh.authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}, auth.ScopeRatesRead)
+
+			handler(recorder, httptest.NewRequest(http.MethodGet, "/rates/latest", nil))
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("routeHandler.authMiddleware() status = %v, want %v", recorder.Code, tt.wantStatus)
+			}
+			if got := recorder.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("routeHandler.authMiddleware() Retry-After = %v, want %v", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func Test_routeHandler_writeError(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		requestID     string
+		wantStatus    int
+		wantCode      string
+		wantMessage   string
+		wantRequestID string
+	}{
+		struct {
+			name          string
+			err           error
+			requestID     string
+			wantStatus    int
+			wantCode      string
+			wantMessage   string
+			wantRequestID string
+		}{
+			name:          "Not found",
+			err:           apperror.Wrap(apperror.NotFound, "not_found", "No rates available for 2020-06-01", errors.New("record not found")),
+			requestID:     "client-request-1",
+			wantStatus:    http.StatusNotFound,
+			wantCode:      "not_found",
+			wantMessage:   "No rates available for 2020-06-01",
+			wantRequestID: "client-request-1",
+		},
+		struct {
+			name          string
+			err           error
+			requestID     string
+			wantStatus    int
+			wantCode      string
+			wantMessage   string
+			wantRequestID string
+		}{
+			name:        "Validation error",
+			err:         apperror.New(apperror.Invalid, "invalid_date_range", "End date 2020-01-01 is before start date 2020-02-01"),
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantCode:    "invalid_date_range",
+			wantMessage: "End date 2020-01-01 is before start date 2020-02-01",
+		},
+		struct {
+			name          string
+			err           error
+			requestID     string
+			wantStatus    int
+			wantCode      string
+			wantMessage   string
+			wantRequestID string
+		}{
+			name:        "Insufficient scope wrapped",
+			err:         fmt.Errorf("checking scopes: %w", auth.ErrInsufficientScope),
+			wantStatus:  http.StatusForbidden,
+			wantCode:    "insufficient_scope",
+			wantMessage: "Insufficient scope",
+		},
+		struct {
+			name          string
+			err           error
+			requestID     string
+			wantStatus    int
+			wantCode      string
+			wantMessage   string
+			wantRequestID string
+		}{
+			name:        "Database unavailable",
+			err:         apperror.Wrap(apperror.Unavailable, "database_unavailable", "Database is unavailable", errors.New("dial tcp: connection refused")),
+			wantStatus:  http.StatusServiceUnavailable,
+			wantCode:    "database_unavailable",
+			wantMessage: "Database is unavailable",
+		},
+		struct {
+			name          string
+			err           error
+			requestID     string
+			wantStatus    int
+			wantCode      string
+			wantMessage   string
+			wantRequestID string
+		}{
+			name:        "Untyped error does not leak",
+			err:         errors.New("pq: relation cubes does not exist"),
+			requestID:   "not a valid request id",
+			wantStatus:  http.StatusInternalServerError,
+			wantCode:    "internal_error",
+			wantMessage: "Internal server error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rh := &routeHandler{}
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/rates/latest", nil)
+			request.Header.Set(requestIDHeader, tt.requestID)
+
+			rh.requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				rh.writeError(tt.err, w, r)
+			})).ServeHTTP(recorder, request)
+
+			var got jsondata.Error
+			if err := json.NewDecoder(recorder.Body).Decode(&got); err != nil {
+				t.Fatalf("json.Decode() error = %v", err)
+			}
+			if recorder.Code != tt.wantStatus || got.Code != tt.wantCode || got.Message != tt.wantMessage {
+				t.Errorf("routeHandler.writeError() = %v %v %q, want %v %v %q", recorder.Code, got.Code, got.Message, tt.wantStatus, tt.wantCode, tt.wantMessage)
+			}
+			if got.RequestID == "" || got.RequestID != recorder.Header().Get(requestIDHeader) {
+				t.Errorf("routeHandler.writeError() request id = %q, header %q", got.RequestID, recorder.Header().Get(requestIDHeader))
+			}
+			if tt.wantRequestID != "" && got.RequestID != tt.wantRequestID {
+				t.Errorf("routeHandler.writeError() request id = %q, want %q", got.RequestID, tt.wantRequestID)
+			}
+		})
+	}
+}
+
+func Test_routeHandler_getHealth(t *testing.T) {
+	lastRefresh := time.Date(2020, 6, 1, 14, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		envelopeManager *envelopeManagerMock
+		want            jsondata.Health
+	}{
+		struct {
+			name            string
+			envelopeManager *envelopeManagerMock
+			want            jsondata.Health
+		}{
+			name:            "Live rates",
+			envelopeManager: &envelopeManagerMock{provenance: envelope.ProvenanceLive, lastRefresh: lastRefresh},
+			want:            jsondata.Health{Status: "ok", Provenance: envelope.ProvenanceLive, LastRefresh: &lastRefresh},
+		},
+		struct {
+			name            string
+			envelopeManager *envelopeManagerMock
+			want            jsondata.Health
+		}{
+			name:            "Snapshot rates",
+			envelopeManager: &envelopeManagerMock{provenance: envelope.ProvenanceSnapshot},
+			want:            jsondata.Health{Status: "degraded", Provenance: envelope.ProvenanceSnapshot},
+		},
+		struct {
+			name            string
+			envelopeManager *envelopeManagerMock
+			want            jsondata.Health
+		}{
+			name:            "Nothing loaded",
+			envelopeManager: &envelopeManagerMock{provenance: envelope.ProvenanceNone},
+			want:            jsondata.Health{Status: "degraded", Provenance: envelope.ProvenanceNone},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			rh := &routeHandler{envelopeManager: tt.envelopeManager}
+			rh.getHealth(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+			got := jsondata.Health{}
+			if err := json.NewDecoder(recorder.Body).Decode(&got); err != nil {
+				t.Fatalf("routeHandler.getHealth() body error = %v", err)
+			}
+			if recorder.Code != http.StatusOK || got.Status != tt.want.Status || got.Provenance != tt.want.Provenance {
+				t.Errorf("routeHandler.getHealth() = %v %+v, want 200 %+v", recorder.Code, got, tt.want)
+			}
+			if (got.LastRefresh == nil) != (tt.want.LastRefresh == nil) || (got.LastRefresh != nil && !got.LastRefresh.Equal(*tt.want.LastRefresh)) {
+				t.Errorf("routeHandler.getHealth() last refresh = %v, want %v", got.LastRefresh, tt.want.LastRefresh)
+			}
+		})
+	}
+}
+
+func Test_routeHandler_withProvenance(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	rh := &routeHandler{envelopeManager: &envelopeManagerMock{provenance: envelope.ProvenanceDemo}}
+	rh.withProvenance(rh.getLatestRates)(recorder, httptest.NewRequest(http.MethodGet, "/rates/latest", nil))
+
+	if got := recorder.Header().Get(provenanceHeader); got != envelope.ProvenanceDemo {
+		t.Errorf("routeHandler.withProvenance() %v = %q, want %q", provenanceHeader, got, envelope.ProvenanceDemo)
+	}
+}
+
+func Test_routeHandler_convertAmount(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   string
+		wantCode int
+	}{
+		struct {
+			name     string
+			amount   string
+			wantCode int
+		}{
+			name:     "Positive amount",
+			amount:   "125.50",
+			wantCode: http.StatusOK,
+		},
+		struct {
+			name     string
+			amount   string
+			wantCode int
+		}{
+			name:     "Zero amount",
+			amount:   "0",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		struct {
+			name     string
+			amount   string
+			wantCode int
+		}{
+			name:     "Negative amount",
+			amount:   "-10",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		struct {
+			name     string
+			amount   string
+			wantCode int
+		}{
+			name:     "Not a number",
+			amount:   "NaN",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			rh := &routeHandler{envelopeManager: &envelopeManagerMock{}}
+			rh.convertAmount(recorder, httptest.NewRequest(http.MethodGet, "/rates/convert?from=EUR&to=USD&amount="+tt.amount, nil))
+
+			if recorder.Code != tt.wantCode {
+				t.Errorf("routeHandler.convertAmount() status = %v, want %v", recorder.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func Test_routeHandler_uploadRates(t *testing.T) {
+	os.Setenv("UPLOAD_MAX_BYTES", "16")
+	defer os.Unsetenv("UPLOAD_MAX_BYTES")
+
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		uploadErr     error
+		wantCode      int
+	}{
+		struct {
+			name          string
+			body          string
+			contentLength int64
+			uploadErr     error
+			wantCode      int
+		}{
+			name:     "Upload within the limit",
+			body:     "Date,USD\n",
+			wantCode: http.StatusCreated,
+		},
+		struct {
+			name          string
+			body          string
+			contentLength int64
+			uploadErr     error
+			wantCode      int
+		}{
+			name:          "Declared length over the limit",
+			body:          strings.Repeat("x", 32),
+			contentLength: 32,
+			wantCode:      http.StatusRequestEntityTooLarge,
+		},
+		struct {
+			name          string
+			body          string
+			contentLength int64
+			uploadErr     error
+			wantCode      int
+		}{
+			name:          "Streamed body over the limit",
+			body:          strings.Repeat("x", 32),
+			contentLength: -1,
+			wantCode:      http.StatusRequestEntityTooLarge,
+		},
+		struct {
+			name          string
+			body          string
+			contentLength int64
+			uploadErr     error
+			wantCode      int
+		}{
+			name:      "Ingestion already running",
+			body:      "Date,USD\n",
+			uploadErr: apperror.New(apperror.Conflict, "refresh_in_progress", "A refresh or upload is already running"),
+			wantCode:  http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/admin/rates/upload?format=csv", strings.NewReader(tt.body))
+			if tt.contentLength != 0 {
+				request.ContentLength = tt.contentLength
+			}
+
+			rh := &routeHandler{envelopeManager: &envelopeManagerMock{uploadErr: tt.uploadErr}}
+			rh.uploadRates(recorder, request)
+
+			if recorder.Code != tt.wantCode {
+				t.Errorf("routeHandler.uploadRates() status = %v, want %v, body %v", recorder.Code, tt.wantCode, recorder.Body)
+			}
+		})
+	}
+}
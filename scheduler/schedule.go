@@ -0,0 +1,174 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Schedule is a parsed 5-field cron expression: minute hour day-of-month month day-of-week
+	Schedule struct {
+		minute     map[int]bool
+		hour       map[int]bool
+		dayOfMonth map[int]bool
+		month      map[int]bool
+		dayOfWeek  map[int]bool
+		anyDay     bool
+		anyWeekday bool
+		location   *time.Location
+	}
+
+	fieldBounds struct {
+		name     string
+		min, max int
+	}
+)
+
+var scheduleFields = []fieldBounds{
+	fieldBounds{name: "minute", min: 0, max: 59},
+	fieldBounds{name: "hour", min: 0, max: 23},
+	fieldBounds{name: "day of month", min: 1, max: 31},
+	fieldBounds{name: "month", min: 1, max: 12},
+	fieldBounds{name: "day of week", min: 0, max: 7},
+}
+
+// ParseSchedule supports "*", single values, ranges "a-b", lists "a,b" and steps "*/n" or "a-b/n"
+func ParseSchedule(spec string, location *time.Location) (*Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(scheduleFields) {
+		return nil, fmt.Errorf("Invalid schedule %q: expected %v fields, got %v", spec, len(scheduleFields), len(fields))
+	}
+
+	parsed := make([]map[int]bool, len(fields))
+	for index, field := range fields {
+		values, err := parseField(field, scheduleFields[index])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid schedule %q: %v", spec, err)
+		}
+		parsed[index] = values
+	}
+
+	// Sunday can be written as either 0 or 7
+	if parsed[4][7] {
+		parsed[4][0] = true
+	}
+
+	if location == nil {
+		location = time.UTC
+	}
+
+	return &Schedule{
+		minute:     parsed[0],
+		hour:       parsed[1],
+		dayOfMonth: parsed[2],
+		month:      parsed[3],
+		dayOfWeek:  parsed[4],
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+		location:   location,
+	}, nil
+}
+
+// Next returns the first activation strictly after t, or the zero time if none is found within five years
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := s.location
+	t = t.In(loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !s.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if !s.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+
+		if !s.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// Follows cron semantics: when both day fields are restricted, either one matching is enough
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dayOfMonth[t.Day()]
+	dowMatch := s.dayOfWeek[int(t.Weekday())]
+
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return dowMatch
+	case s.anyWeekday:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+func parseField(field string, bounds fieldBounds) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if index := strings.Index(part, "/"); index >= 0 {
+			var err error
+			rangePart = part[:index]
+			step, err = strconv.Atoi(part[index+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %v field: %q", bounds.name, part)
+			}
+		}
+
+		start, end, err := parseRange(rangePart, bounds)
+		if err != nil {
+			return nil, err
+		}
+
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+
+	return values, nil
+}
+
+func parseRange(rangePart string, bounds fieldBounds) (int, int, error) {
+	if rangePart == "*" {
+		return bounds.min, bounds.max, nil
+	}
+
+	limits := strings.SplitN(rangePart, "-", 2)
+	start, err := strconv.Atoi(limits[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid value in %v field: %q", bounds.name, rangePart)
+	}
+
+	end := start
+	if len(limits) == 2 {
+		if end, err = strconv.Atoi(limits[1]); err != nil {
+			return 0, 0, fmt.Errorf("invalid value in %v field: %q", bounds.name, rangePart)
+		}
+	}
+
+	if start < bounds.min || end > bounds.max || start > end {
+		return 0, 0, fmt.Errorf("%v field out of range %v-%v: %q", bounds.name, bounds.min, bounds.max, rangePart)
+	}
+
+	return start, end, nil
+}
@@ -0,0 +1,133 @@
+package scheduler
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emanpicar/currency-api/logger"
+	"github.com/emanpicar/currency-api/settings"
+)
+
+type (
+	Manager interface {
+		Start()
+		Stop()
+	}
+
+	// Job is the unit of work executed on every scheduled activation
+	Job func() error
+
+	schedulerHandler struct {
+		name     string
+		job      Job
+		schedule *Schedule
+		jitter   time.Duration
+		enabled  bool
+
+		running int32
+		stop    chan struct{}
+		wg      sync.WaitGroup
+	}
+)
+
+func NewManager(name string, job Job) Manager {
+	location, err := time.LoadLocation(settings.GetRefreshTimezone())
+	if err != nil {
+		logger.Log.Warnf("Unable to load timezone %v, falling back to CET: %v", settings.GetRefreshTimezone(), err)
+		location = time.FixedZone("CET", 60*60)
+	}
+
+	schedule, err := ParseSchedule(settings.GetRefreshSchedule(), location)
+	if err != nil {
+		logger.Log.Fatalln(err)
+	}
+
+	return &schedulerHandler{
+		name:     name,
+		job:      job,
+		schedule: schedule,
+		jitter:   settings.GetRefreshJitter(),
+		enabled:  settings.GetRefreshEnabled(),
+		stop:     make(chan struct{}),
+	}
+}
+
+func (s *schedulerHandler) Start() {
+	if !s.enabled {
+		logger.Log.Infof("Scheduler %v is disabled", s.name)
+		return
+	}
+
+	logger.Log.Infof("Starting scheduler %v", s.name)
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop prevents further activations and waits for a run in progress to finish
+func (s *schedulerHandler) Stop() {
+	if !s.enabled {
+		return
+	}
+
+	logger.Log.Infof("Stopping scheduler %v", s.name)
+	close(s.stop)
+	s.wg.Wait()
+	logger.Log.Infof("Scheduler %v stopped", s.name)
+}
+
+func (s *schedulerHandler) loop() {
+	defer s.wg.Done()
+
+	for {
+		next := s.schedule.Next(time.Now())
+		if next.IsZero() {
+			logger.Log.Warnf("Scheduler %v has no upcoming activation", s.name)
+			return
+		}
+
+		next = next.Add(s.randomJitter())
+		logger.Log.Infof("Scheduler %v next run at %v", s.name, next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.run()
+			}()
+		}
+	}
+}
+
+// run executes the job unless a previous run is still in progress
+func (s *schedulerHandler) run() {
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		logger.Log.Warnf("Scheduler %v skipped a run, previous run still in progress", s.name)
+		return
+	}
+	defer atomic.StoreInt32(&s.running, 0)
+
+	started := time.Now()
+	logger.Log.Infof("Scheduler %v run started", s.name)
+
+	if err := s.job(); err != nil {
+		logger.Log.Errorf("Scheduler %v run failed after %v: %v", s.name, time.Since(started), err)
+		return
+	}
+
+	logger.Log.Infof("Scheduler %v run completed in %v", s.name, time.Since(started))
+}
+
+func (s *schedulerHandler) randomJitter() time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(s.jitter)))
+}
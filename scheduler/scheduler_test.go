@@ -0,0 +1,167 @@
+package scheduler
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr bool
+	}{
+		struct {
+			name    string
+			spec    string
+			wantErr bool
+		}{
+			name:    "Weekdays after publication",
+			spec:    "30 16 * * 1-5",
+			wantErr: false,
+		},
+		struct {
+			name    string
+			spec    string
+			wantErr bool
+		}{
+			name:    "Steps and lists",
+			spec:    "*/15 8,16 1-15/2 * *",
+			wantErr: false,
+		},
+		struct {
+			name    string
+			spec    string
+			wantErr bool
+		}{
+			name:    "Missing field",
+			spec:    "30 16 * *",
+			wantErr: true,
+		},
+		struct {
+			name    string
+			spec    string
+			wantErr bool
+		}{
+			name:    "Out of range",
+			spec:    "60 16 * * *",
+			wantErr: true,
+		},
+		struct {
+			name    string
+			spec    string
+			wantErr bool
+		}{
+			name:    "Invalid step",
+			spec:    "*/0 16 * * *",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSchedule(tt.spec, time.UTC)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	cet := time.FixedZone("CET", 60*60)
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		struct {
+			name string
+			spec string
+			from time.Time
+			want time.Time
+		}{
+			name: "Same day before publication",
+			spec: "30 16 * * 1-5",
+			from: time.Date(2020, 5, 29, 10, 0, 0, 0, cet),
+			want: time.Date(2020, 5, 29, 16, 30, 0, 0, cet),
+		},
+		struct {
+			name string
+			spec string
+			from time.Time
+			want time.Time
+		}{
+			name: "Friday evening rolls over to Monday",
+			spec: "30 16 * * 1-5",
+			from: time.Date(2020, 5, 29, 16, 30, 0, 0, cet),
+			want: time.Date(2020, 6, 1, 16, 30, 0, 0, cet),
+		},
+		struct {
+			name string
+			spec string
+			from time.Time
+			want time.Time
+		}{
+			name: "Every fifteen minutes",
+			spec: "*/15 * * * *",
+			from: time.Date(2020, 5, 29, 10, 7, 42, 0, cet),
+			want: time.Date(2020, 5, 29, 10, 15, 0, 0, cet),
+		},
+		struct {
+			name string
+			spec string
+			from time.Time
+			want time.Time
+		}{
+			name: "Day of month or day of week",
+			spec: "0 0 1 * 0",
+			from: time.Date(2020, 5, 29, 10, 0, 0, 0, cet),
+			want: time.Date(2020, 5, 31, 0, 0, 0, 0, cet),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.spec, cet)
+			if err != nil {
+				t.Fatalf("ParseSchedule() error = %v", err)
+			}
+			if got := schedule.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Schedule.Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_schedulerHandler_run(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	s := &schedulerHandler{name: "test", job: func() error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return errors.New("Job failed")
+	}}
+
+	done := make(chan struct{})
+	go func() {
+		s.run()
+		close(done)
+	}()
+
+	for atomic.LoadInt32(&s.running) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	s.run()
+	close(release)
+	<-done
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("schedulerHandler.run() overlapping calls = %v, want 1", got)
+	}
+	if got := atomic.LoadInt32(&s.running); got != 0 {
+		t.Errorf("schedulerHandler.run() running = %v, want 0", got)
+	}
+}
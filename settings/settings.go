@@ -2,6 +2,9 @@ package settings
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func getEnv(envName, envDefault string) string {
@@ -12,6 +15,35 @@ func getEnv(envName, envDefault string) string {
 	return envDefault
 }
 
+func getListEnv(envName string) []string {
+	values := []string{}
+	for _, value := range strings.Split(getEnv(envName, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+func getIntEnv(envName string, envDefault int) int {
+	value, err := strconv.Atoi(getEnv(envName, strconv.Itoa(envDefault)))
+	if err != nil {
+		return envDefault
+	}
+
+	return value
+}
+
+func getDurationEnv(envName string, envDefault time.Duration) time.Duration {
+	duration, err := time.ParseDuration(getEnv(envName, envDefault.String()))
+	if err != nil {
+		return envDefault
+	}
+
+	return duration
+}
+
 func GetLogLevel() string {
 	return getEnv("LOG_LEVEL", "info")
 }
@@ -63,3 +95,197 @@ func GetServerPrivateKey() string {
 func GetTokenSecret() string {
 	return getEnv("TOKEN_SECRET", "notSoSecret")
 }
+
+// GetJWTSigningAlgorithm is one of HS256 (shared TOKEN_SECRET), RS256 or ES256
+func GetJWTSigningAlgorithm() string {
+	return getEnv("JWT_SIGNING_ALG", "HS256")
+}
+
+// GetJWTSigningKey is the PEM private key path used for RS256/ES256
+func GetJWTSigningKey() string {
+	return getEnv("JWT_SIGNING_KEY", "./certs/jwt-signing-key.pem")
+}
+
+// GetJWTVerificationKeys lists extra PEM public key paths still accepted while rotating keys
+func GetJWTVerificationKeys() []string {
+	return getListEnv("JWT_VERIFICATION_KEYS")
+}
+
+func GetAccessTokenTTL() time.Duration {
+	return getDurationEnv("ACCESS_TOKEN_TTL", time.Hour)
+}
+
+func GetRefreshTokenTTL() time.Duration {
+	return getDurationEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+func GetRefreshEnabled() bool {
+	return getEnv("REFRESH_ENABLED", "true") == "true"
+}
+
+// GetRefreshSchedule returns a 5-field cron expression: minute hour day-of-month month day-of-week
+func GetRefreshSchedule() string {
+	return getEnv("REFRESH_SCHEDULE", "30 16 * * 1-5")
+}
+
+func GetRefreshTimezone() string {
+	return getEnv("REFRESH_TIMEZONE", "Europe/Berlin")
+}
+
+func GetRefreshJitter() time.Duration {
+	return getDurationEnv("REFRESH_JITTER", 5*time.Minute)
+}
+
+func GetTimeSeriesMaxDays() int {
+	return getIntEnv("TIMESERIES_MAX_DAYS", 366)
+}
+
+// GetFallbackMaxDays limits how far a date without rates may be resolved to the previous or next stored day
+func GetFallbackMaxDays() int {
+	return getIntEnv("FALLBACK_MAX_DAYS", 7)
+}
+
+// GetInMemoryUsersEnabled enables the hard-coded development users, never enable in production
+func GetInMemoryUsersEnabled() bool {
+	return getEnv("AUTH_IN_MEMORY_USERS", "false") == "true"
+}
+
+func GetBootstrapUsername() string {
+	return getEnv("BOOTSTRAP_USERNAME", "")
+}
+
+func GetBootstrapPassword() string {
+	return getEnv("BOOTSTRAP_PASSWORD", "")
+}
+
+func GetRateLimitEnabled() bool {
+	return getEnv("RATE_LIMIT_ENABLED", "true") == "true"
+}
+
+// GetRateLimitPerMinute is the rate at which each subject's token bucket refills
+func GetRateLimitPerMinute() int {
+	return getIntEnv("RATE_LIMIT_PER_MINUTE", 60)
+}
+
+// GetRateLimitBurst is the capacity of each subject's token bucket
+func GetRateLimitBurst() int {
+	return getIntEnv("RATE_LIMIT_BURST", 20)
+}
+
+// GetRateLimitRouteWeights lists route name and cost pairs, e.g. "RatesAnalyze=10,RatesTimeSeries=5", other routes cost 1
+func GetRateLimitRouteWeights() []string {
+	if weights := getListEnv("RATE_LIMIT_ROUTE_WEIGHTS"); len(weights) > 0 {
+		return weights
+	}
+
+	return []string{"RatesAnalyze=10", "RatesTimeSeries=5"}
+}
+
+// GetDailyQuota limits the weighted requests per subject and UTC day, 0 disables the quota
+func GetDailyQuota() int {
+	return getIntEnv("DAILY_QUOTA", 0)
+}
+
+// GetHistoryImportSource is an ECB history URL or local path (.xml, .csv or .zip) imported on startup when set
+func GetHistoryImportSource() string {
+	return getEnv("HISTORY_IMPORT_SOURCE", "")
+}
+
+// GetXMLStreamingThreshold is the size in bytes from which xml data is decoded one day at a time instead of at once
+func GetXMLStreamingThreshold() int {
+	return getIntEnv("XML_STREAMING_THRESHOLD", 1<<20)
+}
+
+// GetRateProviders lists the providers fetched on startup and refresh, see GetProviderURL for providers other than ecb
+func GetRateProviders() []string {
+	if providers := getListEnv("RATE_PROVIDERS"); len(providers) > 0 {
+		return providers
+	}
+
+	return []string{"ecb"}
+}
+
+// GetRatesSource is the provider whose rates are served by the rates endpoints
+func GetRatesSource() string {
+	return strings.ToLower(getEnv("RATES_SOURCE", "ecb"))
+}
+
+// GetProviderURL is the URL or local path of a provider, read from PROVIDER_{NAME}_URL
+func GetProviderURL(name string) string {
+	return getEnv("PROVIDER_"+strings.ToUpper(name)+"_URL", "")
+}
+
+// GetProviderFormat is xml, csv, json or zip, read from PROVIDER_{NAME}_FORMAT, empty picks it from the URL extension
+func GetProviderFormat(name string) string {
+	return strings.ToLower(getEnv("PROVIDER_"+strings.ToUpper(name)+"_FORMAT", ""))
+}
+
+// GetUploadMaxBytes limits the size of documents uploaded to the admin upload endpoint
+func GetUploadMaxBytes() int {
+	return getIntEnv("UPLOAD_MAX_BYTES", 50<<20)
+}
+
+// GetValidationEnabled checks every ingested day before it is stored, see the other GetValidation settings for the rules
+func GetValidationEnabled() bool {
+	return getEnv("VALIDATION_ENABLED", "true") == "true"
+}
+
+// GetValidationAction is quarantine to keep failing days for review, or reject to drop them
+func GetValidationAction() string {
+	return strings.ToLower(getEnv("VALIDATION_ACTION", "quarantine"))
+}
+
+// GetValidationMaxJumpPercent is the largest accepted change of a rate from the previous day, 0 disables the rule
+func GetValidationMaxJumpPercent() int {
+	return getIntEnv("VALIDATION_MAX_JUMP_PERCENT", 25)
+}
+
+// GetValidationMinCurrencies is the least number of rates a day must have
+func GetValidationMinCurrencies() int {
+	return getIntEnv("VALIDATION_MIN_CURRENCIES", 1)
+}
+
+// GetFetchTimeout bounds a whole upstream download, body included
+func GetFetchTimeout() time.Duration {
+	return getDurationEnv("FETCH_TIMEOUT", time.Minute)
+}
+
+// GetFetchRetries is how often a failed upstream download is retried, only network errors, 429 and 5xx are retried
+func GetFetchRetries() int {
+	return getIntEnv("FETCH_RETRIES", 3)
+}
+
+// GetFetchRetryBackoff is the delay before the first retry, doubled on every further retry
+func GetFetchRetryBackoff() time.Duration {
+	return getDurationEnv("FETCH_RETRY_BACKOFF", time.Second)
+}
+
+// GetFetchMaxBytes limits the size of an upstream download
+func GetFetchMaxBytes() int {
+	return getIntEnv("FETCH_MAX_BYTES", 100<<20)
+}
+
+// GetFetchProxyURL is the proxy used for upstream downloads, the HTTPS_PROXY and HTTP_PROXY variables apply when empty
+func GetFetchProxyURL() string {
+	return getEnv("FETCH_PROXY_URL", "")
+}
+
+// GetFetchCAFile is a PEM bundle of certificate authorities trusted for upstream downloads besides the system ones
+func GetFetchCAFile() string {
+	return getEnv("FETCH_CA_FILE", "")
+}
+
+// GetSnapshotDir holds the last successfully stored download of every provider, loaded when the next download fails
+func GetSnapshotDir() string {
+	return getEnv("SNAPSHOT_DIR", "./snapshots")
+}
+
+// GetRatePrecision is the number of decimal places of computed rates, conversion results and statistics
+func GetRatePrecision() int {
+	return getIntEnv("RATE_PRECISION", 10)
+}
+
+// GetRateRounding is half-even, half-up or down, applied when computed values are cut to the rate precision
+func GetRateRounding() string {
+	return strings.ToLower(getEnv("RATE_ROUNDING", "half-even"))
+}